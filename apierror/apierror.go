@@ -0,0 +1,216 @@
+package apierror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/repository"
+)
+
+// Code is a stable, machine readable identifier of an error, clients may branch on it
+type Code string
+
+const (
+	CodeNotFound         Code = "not_found"
+	CodeValidation       Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeConflict         Code = "conflict"
+	CodeRateLimited      Code = "rate_limited"
+	CodeTimeout          Code = "timeout"
+	CodeInternal         Code = "internal_error"
+	CodePlanningFailed   Code = "planning_unavailable"
+	CodePlaidFailed      Code = "plaid_error"
+	CodePlaidReauth      Code = "plaid_item_login_required"
+	CodePlaidItemGone    Code = "plaid_item_not_found"
+	CodePlaidInstitution Code = "plaid_institution_unavailable"
+	CodePlaidNotReady    Code = "plaid_product_not_ready"
+	CodePlaidRateLimited Code = "plaid_rate_limited"
+	CodePlaidBadToken    Code = "plaid_invalid_public_token"
+	CodePlaidNoAccounts  Code = "plaid_no_accounts"
+)
+
+// Error is an error returned by a handler, rendered by ErrorHandler. Message and Details are sent to the
+// client, Err is the cause and is only logged.
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	Details any
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NotFound(message string) *Error {
+	return &Error{Status: fiber.StatusNotFound, Code: CodeNotFound, Message: message}
+}
+
+// Validation reports bad input, details tells the client what to fix
+func Validation(message string, details any) *Error {
+	return &Error{Status: fiber.StatusBadRequest, Code: CodeValidation, Message: message, Details: details}
+}
+
+func Unauthorized(message string) *Error {
+	return &Error{Status: fiber.StatusUnauthorized, Code: CodeUnauthorized, Message: message}
+}
+
+// Forbidden reports a signed in user acting beyond their rights
+func Forbidden(message string) *Error {
+	return &Error{Status: fiber.StatusForbidden, Code: CodeForbidden, Message: message}
+}
+
+func Conflict(message string, details any) *Error {
+	return &Error{Status: fiber.StatusConflict, Code: CodeConflict, Message: message, Details: details}
+}
+
+func RateLimited(message string) *Error {
+	return &Error{Status: fiber.StatusTooManyRequests, Code: CodeRateLimited, Message: message}
+}
+
+func Internal(message string, err error) *Error {
+	return &Error{Status: fiber.StatusInternalServerError, Code: CodeInternal, Message: message, Err: err}
+}
+
+// UpstreamPlanning reports a failed call to the planning service
+func UpstreamPlanning(err error) *Error {
+	return &Error{Status: fiber.StatusBadGateway, Code: CodePlanningFailed, Message: "the planning service is unavailable, try again later", Err: err}
+}
+
+// plaidErrors translates plaid error codes into errors the user can act on
+var plaidErrors = map[string]Error{
+	"ITEM_LOGIN_REQUIRED":        {Status: fiber.StatusConflict, Code: CodePlaidReauth, Message: "your bank connection has expired, re-link the account to continue"},
+	"PENDING_EXPIRATION":         {Status: fiber.StatusConflict, Code: CodePlaidReauth, Message: "your bank connection is about to expire, re-link the account to continue"},
+	"ITEM_NOT_FOUND":             {Status: fiber.StatusGone, Code: CodePlaidItemGone, Message: "the bank connection no longer exists, link the account again"},
+	"INVALID_ACCESS_TOKEN":       {Status: fiber.StatusGone, Code: CodePlaidItemGone, Message: "the bank connection no longer exists, link the account again"},
+	"INSTITUTION_DOWN":           {Status: fiber.StatusServiceUnavailable, Code: CodePlaidInstitution, Message: "your bank is not responding, try again later"},
+	"INSTITUTION_NOT_RESPONDING": {Status: fiber.StatusServiceUnavailable, Code: CodePlaidInstitution, Message: "your bank is not responding, try again later"},
+	"INSTITUTION_NOT_AVAILABLE":  {Status: fiber.StatusServiceUnavailable, Code: CodePlaidInstitution, Message: "your bank is not available, try again later"},
+	"PRODUCT_NOT_READY":          {Status: fiber.StatusServiceUnavailable, Code: CodePlaidNotReady, Message: "your bank data is still being prepared, try again in a few minutes"},
+	"RATE_LIMIT_EXCEEDED":        {Status: fiber.StatusTooManyRequests, Code: CodePlaidRateLimited, Message: "too many requests to your bank, try again later"},
+	"INVALID_PUBLIC_TOKEN":       {Status: fiber.StatusBadRequest, Code: CodePlaidBadToken, Message: "the bank link has expired, start linking the account again"},
+	"NO_ACCOUNTS":                {Status: fiber.StatusUnprocessableEntity, Code: CodePlaidNoAccounts, Message: "no eligible accounts were found at your bank"},
+	"NO_LIABILITY_ACCOUNTS":      {Status: fiber.StatusUnprocessableEntity, Code: CodePlaidNoAccounts, Message: "no credit accounts were found at your bank"},
+	"PRODUCTS_NOT_SUPPORTED":     {Status: fiber.StatusUnprocessableEntity, Code: CodePlaidNoAccounts, Message: "your bank does not support this kind of account"},
+	"INSUFFICIENT_CREDENTIALS":   {Status: fiber.StatusConflict, Code: CodePlaidReauth, Message: "your bank needs more permissions, re-link the account to continue"},
+	"USER_PERMISSION_REVOKED":    {Status: fiber.StatusConflict, Code: CodePlaidReauth, Message: "access to your bank was revoked, re-link the account to continue"},
+	"ACCESS_NOT_GRANTED":         {Status: fiber.StatusConflict, Code: CodePlaidReauth, Message: "access to your bank was not granted, re-link the account to continue"},
+	"ITEM_LOCKED":                {Status: fiber.StatusConflict, Code: CodePlaidReauth, Message: "your bank account is locked, unlock it with your bank then re-link it"},
+	"INTERNAL_SERVER_ERROR":      {Status: fiber.StatusBadGateway, Code: CodePlaidFailed, Message: "your bank could not be reached, try again later"},
+	"PLANNED_MAINTENANCE":        {Status: fiber.StatusServiceUnavailable, Code: CodePlaidInstitution, Message: "your bank is under maintenance, try again later"},
+}
+
+// UpstreamPlaid translates a failed plaid call, code being its plaid error code, e.g. ITEM_LOGIN_REQUIRED
+func UpstreamPlaid(code string, err error) *Error {
+	if known, ok := plaidErrors[code]; ok {
+		known.Err = err
+		known.Details = fiber.Map{"plaid_error_code": code}
+		return &known
+	}
+	return &Error{
+		Status:  fiber.StatusBadGateway,
+		Code:    CodePlaidFailed,
+		Message: "your bank could not be reached, try again later",
+		Details: fiber.Map{"plaid_error_code": code},
+		Err:     err,
+	}
+}
+
+// Wrap returns err as an *Error: errors that already are one are kept, missing documents become not
+// found, refused writes conflicts and expired deadlines timeouts, anything else is an internal error
+// described by message
+func Wrap(err error, message string) *Error {
+	var apiErr *Error
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, repository.ErrNotFound):
+		return &Error{Status: fiber.StatusNotFound, Code: CodeNotFound, Message: message, Err: err}
+	case errors.Is(err, repository.ErrConflict):
+		return &Error{Status: fiber.StatusConflict, Code: CodeConflict, Message: message, Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Status: fiber.StatusGatewayTimeout, Code: CodeTimeout, Message: "the request took too long, try again later", Err: err}
+	}
+	return Internal(message, err)
+}
+
+// Status returns the status err is rendered with
+func Status(err error) int {
+	var apiErr *Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.Status
+	case errors.As(err, &fiberErr):
+		return fiberErr.Code
+	}
+	return Wrap(err, "").Status
+}
+
+// ErrorHandler is the fiber error handler of the app, it renders every error returned by a handler or
+// middleware with its status and code, and logs the server errors with their cause
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var apiErr *Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.As(err, &fiberErr):
+		apiErr = &Error{Status: fiberErr.Code, Code: codeForStatus(fiberErr.Code), Message: fiberErr.Message}
+	default:
+		apiErr = Wrap(err, http.StatusText(fiber.StatusInternalServerError))
+	}
+
+	if apiErr.Status >= fiber.StatusInternalServerError {
+		logging.Ctx(c).WithError(apiErr.Err).WithField("code", apiErr.Code).Error(apiErr.Message)
+	}
+	return c.Status(apiErr.Status).JSON(fiber.Map{
+		"status":  "error",
+		"code":    apiErr.Code,
+		"message": apiErr.Message,
+		"data":    apiErr.Details,
+	})
+}
+
+// Middleware renders the errors returned after it, so the middlewares before it observe the status
+// that is sent rather than the error
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return ErrorHandler(c, err)
+		}
+		return nil
+	}
+}
+
+func codeForStatus(status int) Code {
+	switch {
+	case status == fiber.StatusNotFound || status == fiber.StatusMethodNotAllowed:
+		return CodeNotFound
+	case status == fiber.StatusUnauthorized:
+		return CodeUnauthorized
+	case status == fiber.StatusForbidden:
+		return CodeForbidden
+	case status == fiber.StatusTooManyRequests:
+		return CodeRateLimited
+	case status == fiber.StatusRequestTimeout || status == fiber.StatusGatewayTimeout:
+		return CodeTimeout
+	case status == fiber.StatusConflict:
+		return CodeConflict
+	case status < fiber.StatusInternalServerError:
+		return CodeValidation
+	}
+	return CodeInternal
+}
@@ -1,34 +1,76 @@
 package app
 
 import (
+	"context"
+
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cache"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/limiter"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/config"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/metrics"
+	"github.com/jalexanderII/zero-railway/tracing"
+	"github.com/sirupsen/logrus"
+	"strings"
 	"time"
 )
 
 // FiberMiddleware provides Fiber's built-in middlewares.
 // See: https://docs.gofiber.io/api/middleware
-func FiberMiddleware(a *fiber.App) {
+func FiberMiddleware(a *fiber.App, cfg *config.Config, l *logrus.Logger) {
 	a.Use(
-		// Add simple logger.
-		logger.New(logger.Config{
-			Format: "[${ip}]:${port} ${status} - ${method} ${path} ${latency}\n",
-		}),
+		// Start a server span for every request
+		tracing.Middleware(),
+		// Bound the request's context, cancelling the database, cache, plaid and planning calls made with it
+		requestDeadline(cfg.RequestTimeout, cfg.RouteTimeouts),
+		// Reuse the caller's X-Request-ID or generate one, and echo it in the response
+		requestid.New(requestid.Config{ContextKey: logging.RequestIDKey}),
+		// Store a request scoped logger in the user context and log every request once handled
+		logging.Middleware(l),
+		// Record request counts and latencies
+		metrics.Middleware(),
+		// Render errors here so the middlewares above observe the status sent to the client
+		apierror.Middleware(),
 		// Add CORS to each route.
 		cors.New(),
-		// Add Cache
-		cache.New(),
 		// add rate limiter
 		limiter.New(limiter.Config{
+			Next:              isProbe,
 			Max:               20,
 			Expiration:        30 * time.Second,
 			LimiterMiddleware: limiter.SlidingWindow{},
+			LimitReached: func(c *fiber.Ctx) error {
+				return apierror.RateLimited("too many requests, try again later")
+			},
 		}),
 		// recover from panic
 		recover.New(),
 	)
 }
+
+// isProbe matches the health and metrics endpoints polled by the platform
+func isProbe(c *fiber.Ctx) bool {
+	return strings.HasPrefix(c.Path(), "/health") || c.Path() == "/metrics"
+}
+
+// requestDeadline sets the deadline of the request's user context to the timeout of the longest route
+// prefix matching its path, or to fallback. fasthttp doesn't report client disconnects, so the deadline is
+// what stops the downstream work of abandoned requests.
+func requestDeadline(fallback time.Duration, routes map[string]time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		timeout, matched := fallback, ""
+		for prefix, d := range routes {
+			if strings.HasPrefix(c.Path(), prefix) && len(prefix) > len(matched) {
+				timeout, matched = d, prefix
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/metrics"
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// PlaidAPI is the subset of the plaid API used by PlaidClient, so it can be replaced by a fake
+type PlaidAPI interface {
+	LinkTokenCreate(ctx context.Context, req plaid.LinkTokenCreateRequest) (plaid.LinkTokenCreateResponse, error)
+	ItemPublicTokenExchange(ctx context.Context, req plaid.ItemPublicTokenExchangeRequest) (plaid.ItemPublicTokenExchangeResponse, error)
+	LiabilitiesGet(ctx context.Context, req plaid.LiabilitiesGetRequest) (plaid.LiabilitiesGetResponse, error)
+	AccountsGet(ctx context.Context, req plaid.AccountsGetRequest) (plaid.AccountsGetResponse, error)
+	ItemGet(ctx context.Context, req plaid.ItemGetRequest) (plaid.ItemGetResponse, error)
+	ItemRemove(ctx context.Context, req plaid.ItemRemoveRequest) (plaid.ItemRemoveResponse, error)
+	TransactionsGet(ctx context.Context, req plaid.TransactionsGetRequest) (plaid.TransactionsGetResponse, error)
+	TransferAuthorizationCreate(ctx context.Context, req plaid.TransferAuthorizationCreateRequest) (plaid.TransferAuthorizationCreateResponse, error)
+	TransferCreate(ctx context.Context, req plaid.TransferCreateRequest) (plaid.TransferCreateResponse, error)
+}
+
+// plaidService implements PlaidAPI over the generated plaid-go client
+type plaidService struct {
+	api *plaid.PlaidApiService
+}
+
+func NewPlaidAPI(api *plaid.PlaidApiService) PlaidAPI {
+	return &plaidService{api: api}
+}
+
+func (s *plaidService) LinkTokenCreate(ctx context.Context, req plaid.LinkTokenCreateRequest) (plaid.LinkTokenCreateResponse, error) {
+	start := time.Now()
+	resp, _, err := s.api.LinkTokenCreate(ctx).LinkTokenCreateRequest(req).Execute()
+	metrics.ObservePlaidCall("/link/token/create", start, err, GetPlaidErrorCode(err))
+	return resp, err
+}
+
+func (s *plaidService) ItemPublicTokenExchange(ctx context.Context, req plaid.ItemPublicTokenExchangeRequest) (plaid.ItemPublicTokenExchangeResponse, error) {
+	start := time.Now()
+	resp, _, err := s.api.ItemPublicTokenExchange(ctx).ItemPublicTokenExchangeRequest(req).Execute()
+	metrics.ObservePlaidCall("/item/public_token/exchange", start, err, GetPlaidErrorCode(err))
+	return resp, err
+}
+
+func (s *plaidService) LiabilitiesGet(ctx context.Context, req plaid.LiabilitiesGetRequest) (plaid.LiabilitiesGetResponse, error) {
+	start := time.Now()
+	resp, _, err := s.api.LiabilitiesGet(ctx).LiabilitiesGetRequest(req).Execute()
+	metrics.ObservePlaidCall("/liabilities/get", start, err, GetPlaidErrorCode(err))
+	return resp, err
+}
+
+func (s *plaidService) AccountsGet(ctx context.Context, req plaid.AccountsGetRequest) (plaid.AccountsGetResponse, error) {
+	start := time.Now()
+	resp, _, err := s.api.AccountsGet(ctx).AccountsGetRequest(req).Execute()
+	metrics.ObservePlaidCall("/accounts/get", start, err, GetPlaidErrorCode(err))
+	return resp, err
+}
+
+func (s *plaidService) ItemGet(ctx context.Context, req plaid.ItemGetRequest) (plaid.ItemGetResponse, error) {
+	start := time.Now()
+	resp, _, err := s.api.ItemGet(ctx).ItemGetRequest(req).Execute()
+	metrics.ObservePlaidCall("/item/get", start, err, GetPlaidErrorCode(err))
+	return resp, err
+}
+
+func (s *plaidService) ItemRemove(ctx context.Context, req plaid.ItemRemoveRequest) (plaid.ItemRemoveResponse, error) {
+	start := time.Now()
+	resp, _, err := s.api.ItemRemove(ctx).ItemRemoveRequest(req).Execute()
+	metrics.ObservePlaidCall("/item/remove", start, err, GetPlaidErrorCode(err))
+	return resp, err
+}
+
+func (s *plaidService) TransactionsGet(ctx context.Context, req plaid.TransactionsGetRequest) (plaid.TransactionsGetResponse, error) {
+	start := time.Now()
+	resp, _, err := s.api.TransactionsGet(ctx).TransactionsGetRequest(req).Execute()
+	metrics.ObservePlaidCall("/transactions/get", start, err, GetPlaidErrorCode(err))
+	return resp, err
+}
+
+func (s *plaidService) TransferAuthorizationCreate(ctx context.Context, req plaid.TransferAuthorizationCreateRequest) (plaid.TransferAuthorizationCreateResponse, error) {
+	start := time.Now()
+	resp, _, err := s.api.TransferAuthorizationCreate(ctx).TransferAuthorizationCreateRequest(req).Execute()
+	metrics.ObservePlaidCall("/transfer/authorization/create", start, err, GetPlaidErrorCode(err))
+	return resp, err
+}
+
+func (s *plaidService) TransferCreate(ctx context.Context, req plaid.TransferCreateRequest) (plaid.TransferCreateResponse, error) {
+	start := time.Now()
+	resp, _, err := s.api.TransferCreate(ctx).TransferCreateRequest(req).Execute()
+	metrics.ObservePlaidCall("/transfer/create", start, err, GetPlaidErrorCode(err))
+	return resp, err
+}
+
+// GetPlaidErrorCode returns the plaid error code of a failed API call, e.g. ITEM_LOGIN_REQUIRED, or
+// unknown when the error does not carry one. It returns an empty string for a nil error.
+func GetPlaidErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	var apiErr plaid.GenericOpenAPIError
+	if errors.As(err, &apiErr) {
+		if plaidErr, convErr := plaid.ToPlaidError(apiErr); convErr == nil && plaidErr.ErrorCode != "" {
+			return plaidErr.ErrorCode
+		}
+	}
+
+	// fall back to the code embedded in the error message
+	errorMessage := err.Error()
+	start := strings.Index(errorMessage, ", code: ")
+	if start < 0 {
+		return "unknown"
+	}
+	start += len(", code: ")
+	end := strings.Index(errorMessage[start:], ", ")
+	if end < 0 {
+		return errorMessage[start:]
+	}
+	return errorMessage[start : start+end]
+}
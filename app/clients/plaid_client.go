@@ -4,20 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/go-redis/cache/v8"
 	"math"
-	"os"
-	"strings"
+	"net/http"
 	"time"
 
-	"github.com/jalexanderII/zero-railway/database"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/config"
+	"github.com/jalexanderII/zero-railway/logging"
 	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/tracing"
 
 	"github.com/plaid/plaid-go/plaid"
 	"github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var environments = map[string]plaid.Environment{
@@ -26,89 +25,67 @@ var environments = map[string]plaid.Environment{
 	"production":  plaid.Production,
 }
 
-var environmentSecret = map[string]string{
-	"sandbox":     "PLAID_SECRET_SANDBOX",
-	"development": "PLAID_SECRET_DEV",
-	"production":  "PLAID_SECRET_PROD",
-}
-
 var purposeToAccountFilter = map[models.Purpose]plaid.LinkTokenAccountFilters{
 	models.PURPOSE_CREDIT: {Credit: &plaid.CreditFilter{AccountSubtypes: []plaid.AccountSubtype{plaid.ACCOUNTSUBTYPE_CREDIT_CARD}}},
 	models.PURPOSE_DEBIT:  {Depository: &plaid.DepositoryFilter{AccountSubtypes: []plaid.AccountSubtype{plaid.ACCOUNTSUBTYPE_CHECKING}}},
 }
 
+// IngestHook is called with a user's freshly fetched account details once they have been saved. The
+// context outlives the request that triggered the fetch.
+type IngestHook func(ctx context.Context, userId primitive.ObjectID, details *models.AccountDetailsResponse)
+
+// TransactionsWindow is how far back the transactions of an Item are fetched, the last quarter
+const TransactionsWindow = 90 * 24 * time.Hour
+
+// ingestHookTimeout bounds each ingest hook, as they no longer run under a request's deadline
+const ingestHookTimeout = 2 * time.Minute
+
 type PlaidClient struct {
 	// Name of the service
 	Name string
-	// Client is the object that contains all database functionalities
-	Client       *plaid.PlaidApiService
+	// Client is the plaid API the client calls
+	Client       PlaidAPI
 	RedirectURL  string
 	Products     []plaid.Products
 	CountryCodes []plaid.CountryCode
 	// custom logger
 	L *logrus.Logger
-	C context.Context
-	// Database collection
-	PlaidDb *mongo.Collection
-	UserDb  *mongo.Collection
-	AccDb   *mongo.Collection
-	TrxnDb  *mongo.Collection
 	// to pass tokens through methods
 	LinkToken   *models.Token
 	PublicToken *models.Token
+	// hooks run after account details are fetched from plaid
+	ingestHooks []IngestHook
 }
 
-func NewPlaidClient(collectionName string, l *logrus.Logger) *PlaidClient {
-	// set constants from env
-	PlaidDb := database.GetCollection(collectionName)
-	UserDb := database.GetCollection(os.Getenv("USER_COLLECTION"))
-	AccDb := database.GetCollection(os.Getenv("ACCOUNT_COLLECTION"))
-	TrxnDb := database.GetCollection(os.Getenv("TRANSACTION_COLLECTION"))
-	plaidEnv := os.Getenv("PLAID_ENV")
-	plaidSecret := os.Getenv(environmentSecret[plaidEnv])
-	plaidClient := os.Getenv("PLAID_CLIENT_ID")
-
+func NewPlaidClient(cfg config.PlaidConfig, l *logrus.Logger) *PlaidClient {
 	// create Plaid client
 	configuration := plaid.NewConfiguration()
-	configuration.AddDefaultHeader("PLAID-CLIENT-ID", plaidClient)
-	configuration.AddDefaultHeader("PLAID-SECRET", plaidSecret)
-	configuration.UseEnvironment(environments[plaidEnv])
+	configuration.AddDefaultHeader("PLAID-CLIENT-ID", cfg.ClientID)
+	configuration.AddDefaultHeader("PLAID-SECRET", cfg.Secret())
+	configuration.UseEnvironment(environments[cfg.Env])
+	configuration.HTTPClient = &http.Client{Transport: tracing.Transport(nil)}
 
-	countryCodes := convertCountryCodes(strings.Split(os.Getenv("PLAID_COUNTRY_CODES"), ","))
-	products := convertProducts(strings.Split(os.Getenv("PLAID_PRODUCTS"), ","))
+	countryCodes := convertCountryCodes(cfg.CountryCodes)
+	products := convertProducts(cfg.Products)
 	client := plaid.NewAPIClient(configuration)
 	return &PlaidClient{
 		Name:         "ZeroFintech",
-		Client:       client.PlaidApi,
-		RedirectURL:  os.Getenv("PLAID_REDIRECT_URI"),
+		Client:       NewPlaidAPI(client.PlaidApi),
+		RedirectURL:  cfg.RedirectURI,
 		Products:     products,
 		CountryCodes: countryCodes,
 		L:            l,
-		C:            context.Background(),
-		PlaidDb:      PlaidDb,
-		UserDb:       UserDb,
-		AccDb:        AccDb,
-		TrxnDb:       TrxnDb,
 		LinkToken:    nil,
 		PublicToken:  nil,
 	}
 }
 
-// LinkTokenCreate creates a link token using the specified parameters
-func (p *PlaidClient) LinkTokenCreate(email, purpose string) (*models.CreateLinkTokenResponse, error) {
-	fmt.Printf("email: %+v", email)
-	fmt.Printf(" purpose: %+v", purpose)
-
+// LinkTokenCreate creates a link token for the user using the specified parameters
+func (p *PlaidClient) LinkTokenCreate(ctx context.Context, DbUser *models.User, purpose string) (*models.CreateLinkTokenResponse, error) {
 	purp, err := models.PurposeFromString(purpose)
 	if err != nil {
 		return nil, err
 	}
-
-	DbUser, err := p.GetUser(email)
-	if err != nil {
-		p.L.Error("[DB Error] error fetching user", err)
-		return nil, err
-	}
 	id := DbUser.ID.Hex()
 
 	user := plaid.LinkTokenCreateRequestUser{
@@ -117,22 +94,21 @@ func (p *PlaidClient) LinkTokenCreate(email, purpose string) (*models.CreateLink
 	request := plaid.NewLinkTokenCreateRequest(p.Name, "en", p.CountryCodes, user)
 	request.SetRedirectUri(p.RedirectURL)
 
-	p.L.Infof("The link purpose is %+v", purp)
+	products := p.Products
 	if purp == models.PURPOSE_DEBIT {
-		p.Products = convertProducts([]string{"transactions"})
+		products = convertProducts([]string{"transactions"})
 	}
 
-	request.SetProducts(p.Products)
+	request.SetProducts(products)
 	request.SetAccountFilters(purposeToAccountFilter[purp])
 
-	p.L.Infof("Link token request %+v", request)
-	linkTokenCreateResp, _, err := p.Client.LinkTokenCreate(p.C).LinkTokenCreateRequest(*request).Execute()
+	linkTokenCreateResp, err := p.Client.LinkTokenCreate(ctx, *request)
 	if err != nil {
-		p.L.Errorf("[Plaid Error] error creating link token %+v", renderError(err)["error"])
-		return nil, err
+		plaidErrorLog(ctx, err).Error("[Plaid Error] error creating link token")
+		return nil, upstreamError(err)
 	}
 
-	p.L.Info("link token created: ", linkTokenCreateResp)
+	logging.FromContext(ctx).WithField("purpose", purp).Info("link token created")
 	return &models.CreateLinkTokenResponse{Token: linkTokenCreateResp.GetLinkToken(), UserId: id}, nil
 }
 
@@ -142,12 +118,10 @@ func (p *PlaidClient) LinkTokenCreate(email, purpose string) (*models.CreateLink
 // are json responses and logs that will adequately reflect all issues
 func (p *PlaidClient) ExchangePublicToken(ctx context.Context, publicToken string) (*models.Token, error) {
 	// exchange the public_token for an access_token
-	exchangePublicTokenResp, _, err := p.Client.ItemPublicTokenExchange(ctx).ItemPublicTokenExchangeRequest(
-		*plaid.NewItemPublicTokenExchangeRequest(publicToken),
-	).Execute()
+	exchangePublicTokenResp, err := p.Client.ItemPublicTokenExchange(ctx, *plaid.NewItemPublicTokenExchangeRequest(publicToken))
 	if err != nil {
-		p.L.Errorf("[Plaid Error] error getting exchangePublicTokenResp %+v", renderError(err)["error"])
-		return nil, err
+		plaidErrorLog(ctx, err).Error("[Plaid Error] error getting exchangePublicTokenResp")
+		return nil, upstreamError(err)
 	}
 
 	accessToken := exchangePublicTokenResp.GetAccessToken()
@@ -155,24 +129,22 @@ func (p *PlaidClient) ExchangePublicToken(ctx context.Context, publicToken strin
 	if itemExists(p.Products, plaid.PRODUCTS_TRANSFER) {
 		_, err = p.authorizeAndCreateTransfer(ctx, accessToken)
 		if err != nil {
-			p.L.Errorf("[Plaid Error] error authorizeAndCreateTransfer %+v", renderError(err)["error"])
-			return nil, err
+			plaidErrorLog(ctx, err).Error("[Plaid Error] error authorizeAndCreateTransfer")
+			return nil, upstreamError(err)
 		}
 	}
 
-	p.L.Info("public token: " + publicToken)
-	p.L.Info("access token: " + accessToken)
-	p.L.Info("item ID: " + itemID)
+	logging.FromContext(ctx).WithField("item_id", itemID).Info("public token exchanged")
 	return &models.Token{Value: accessToken, ItemId: itemID}, nil
 }
 
-func (p *PlaidClient) GetAccountDetails(token *models.Token) (*models.AccountDetailsResponse, error) {
+func (p *PlaidClient) GetAccountDetails(ctx context.Context, token *models.Token) (*models.AccountDetailsResponse, error) {
 	var liabilitiesResponse models.LiabilitiesResponse
 	var transactionsResponse models.TransactionsResponse
 
 	if token.Purpose == models.PURPOSE_DEBIT {
 		// if debit get account info only
-		creditAccounts, creditTransactions, err := p.fetchDebitInfo(token.Value)
+		creditAccounts, creditTransactions, err := p.fetchDebitInfo(ctx, token.Value)
 		if err != nil {
 			return nil, err
 		}
@@ -180,14 +152,14 @@ func (p *PlaidClient) GetAccountDetails(token *models.Token) (*models.AccountDet
 	} else {
 		// otherwise use liabilities request to get credit card accounts and also fetch transactions
 		liabilitiesReq := plaid.NewLiabilitiesGetRequest(token.Value)
-		liabilitiesResp, _, err := p.Client.LiabilitiesGet(p.C).LiabilitiesGetRequest(*liabilitiesReq).Execute()
+		liabilitiesResp, err := p.Client.LiabilitiesGet(ctx, *liabilitiesReq)
 		if err != nil {
-			p.L.Errorf("[Plaid Error] getting Liabilities %+v", renderError(err)["error"])
-			return nil, err
+			plaidErrorLog(ctx, err).Error("[Plaid Error] getting Liabilities")
+			return nil, upstreamError(err)
 		}
 		liabilitiesResponse = models.LiabilitiesResponse{Liabilities: liabilitiesResp.GetLiabilities().Credit}
 
-		creditAccounts, creditTransactions, err := p.fetchCreditInfo(token.Value, true)
+		creditAccounts, creditTransactions, err := p.fetchCreditInfo(ctx, token.Value, true)
 		if err != nil {
 			return nil, err
 		}
@@ -196,19 +168,76 @@ func (p *PlaidClient) GetAccountDetails(token *models.Token) (*models.AccountDet
 
 	response, err := p.PlaidResponseToPB(liabilitiesResponse, transactionsResponse, token.User, token.Purpose)
 	if err != nil {
-		p.L.Error("Error converting PlaidResponse to PB", "error", err)
+		logging.FromContext(ctx).WithError(err).Error("Error converting PlaidResponse to PB")
 		return nil, err
 	}
 	return response, nil
 }
 
-func (p *PlaidClient) fetchDebitInfo(accessToken string) ([]plaid.AccountBase, []plaid.Transaction, error) {
+// GetItemHealth reports the state of the Item behind token. An Item that plaid can no longer reach is
+// reported unhealthy with the error of the call rather than failing.
+func (p *PlaidClient) GetItemHealth(ctx context.Context, token *models.Token) (*models.ItemHealth, error) {
+	health := &models.ItemHealth{
+		TokenId:       token.ID,
+		ItemId:        token.ItemId,
+		Institution:   token.Institution,
+		InstitutionID: token.InstitutionID,
+		Purpose:       token.Purpose,
+	}
+	if token.User != nil {
+		health.UserId = token.User.ID
+	}
+
+	resp, err := p.Client.ItemGet(ctx, *plaid.NewItemGetRequest(token.Value))
+	if err != nil {
+		code := GetPlaidErrorCode(err)
+		if code == "unknown" {
+			plaidErrorLog(ctx, err).Error("[Plaid Error] getting Item")
+			return nil, upstreamError(err)
+		}
+		health.ErrorCode = code
+		return health, nil
+	}
+
+	item := resp.GetItem()
+	if plaidErr, ok := item.GetErrorOk(); ok && plaidErr != nil && plaidErr.ErrorCode != "" {
+		health.ErrorCode = plaidErr.GetErrorCode()
+		health.ErrorMessage = plaidErr.GetErrorMessage()
+	}
+	health.Healthy = health.ErrorCode == ""
+	if expires, ok := item.GetConsentExpirationTimeOk(); ok && expires != nil {
+		health.ConsentExpiresAt = expires
+	}
+	if status, ok := resp.GetStatusOk(); ok && status != nil {
+		transactions := status.GetTransactions()
+		if updated, ok := transactions.GetLastSuccessfulUpdateOk(); ok && updated != nil {
+			health.LastTransactionsUpdate = updated
+		}
+	}
+	return health, nil
+}
+
+// RemoveItem removes the Item behind token at plaid, revoking its access token. An Item plaid no longer
+// knows of counts as removed.
+func (p *PlaidClient) RemoveItem(ctx context.Context, token *models.Token) error {
+	if _, err := p.Client.ItemRemove(ctx, *plaid.NewItemRemoveRequest(token.Value)); err != nil {
+		switch GetPlaidErrorCode(err) {
+		case "ITEM_NOT_FOUND", "INVALID_ACCESS_TOKEN":
+			return nil
+		}
+		plaidErrorLog(ctx, err).Error("[Plaid Error] removing Item")
+		return upstreamError(err)
+	}
+	return nil
+}
+
+func (p *PlaidClient) fetchDebitInfo(ctx context.Context, accessToken string) ([]plaid.AccountBase, []plaid.Transaction, error) {
 	// if debit get account info only
 	accountsReq := plaid.NewAccountsGetRequest(accessToken)
-	accountsResp, _, err := p.Client.AccountsGet(p.C).AccountsGetRequest(*accountsReq).Execute()
+	accountsResp, err := p.Client.AccountsGet(ctx, *accountsReq)
 	if err != nil {
-		p.L.Errorf("[Plaid Error] getting Liabilities %+v", renderError(err)["error"])
-		return nil, nil, err
+		plaidErrorLog(ctx, err).Error("[Plaid Error] getting Liabilities")
+		return nil, nil, upstreamError(err)
 	}
 
 	var debitAccounts []plaid.AccountBase
@@ -220,7 +249,7 @@ func (p *PlaidClient) fetchDebitInfo(accessToken string) ([]plaid.AccountBase, [
 	return debitAccounts, nil, nil
 }
 
-func (p *PlaidClient) fetchCreditInfo(accessToken string, fetchAll bool) ([]plaid.AccountBase, []plaid.Transaction, error) {
+func (p *PlaidClient) fetchCreditInfo(ctx context.Context, accessToken string, fetchAll bool) ([]plaid.AccountBase, []plaid.Transaction, error) {
 	var creditAccounts []plaid.AccountBase
 	var creditTransactions []plaid.Transaction
 	accountIds := make(map[string]string)
@@ -228,8 +257,7 @@ func (p *PlaidClient) fetchCreditInfo(accessToken string, fetchAll bool) ([]plai
 
 	const iso8601TimeFormat = "2006-01-02"
 	endDate := time.Now().Local().Format(iso8601TimeFormat)
-	// Last Quarter (90 days)
-	startDate := time.Now().Local().Add(-90 * 24 * time.Hour).Format(iso8601TimeFormat)
+	startDate := time.Now().Local().Add(-TransactionsWindow).Format(iso8601TimeFormat)
 
 	request := plaid.NewTransactionsGetRequest(
 		accessToken,
@@ -240,10 +268,10 @@ func (p *PlaidClient) fetchCreditInfo(accessToken string, fetchAll bool) ([]plai
 	// last 350 transactions
 	request.SetOptions(plaid.TransactionsGetRequestOptions{Count: plaid.PtrInt32(fetchNext)})
 
-	transactionsResp, _, err := p.Client.TransactionsGet(p.C).TransactionsGetRequest(*request).Execute()
+	transactionsResp, err := p.Client.TransactionsGet(ctx, *request)
 	if err != nil {
-		p.L.Errorf("[Plaid Error] getting Transactions %+v", renderError(err)["error"])
-		return nil, nil, err
+		plaidErrorLog(ctx, err).Error("[Plaid Error] getting Transactions")
+		return nil, nil, upstreamError(err)
 	}
 
 	for _, account := range transactionsResp.GetAccounts() {
@@ -266,14 +294,14 @@ func (p *PlaidClient) fetchCreditInfo(accessToken string, fetchAll bool) ([]plai
 
 	totalNumberOfTransactions := transactionsResp.GetTotalTransactions()
 	if totalNumberOfTransactions > fetchNext && fetchAll {
-		p.L.Info("Fetching all transactions, there are more than ", fetchNext)
+		logging.FromContext(ctx).WithField("total_transactions", totalNumberOfTransactions).Info("fetching all transactions")
 		// if there are more than 500 transactions, fetch the rest
 		for i := fetchNext; i < totalNumberOfTransactions; i += int32(math.Min(float64(fetchNext), float64(totalNumberOfTransactions-i))) {
 			request.SetOptions(plaid.TransactionsGetRequestOptions{Count: plaid.PtrInt32(fetchNext), Offset: plaid.PtrInt32(i)})
-			transactionsResp, _, err = p.Client.TransactionsGet(p.C).TransactionsGetRequest(*request).Execute()
+			transactionsResp, err = p.Client.TransactionsGet(ctx, *request)
 			if err != nil {
-				p.L.Errorf("[Plaid Error] getting Transactions %+v", renderError(err)["error"])
-				return nil, nil, err
+				plaidErrorLog(ctx, err).Error("[Plaid Error] getting Transactions")
+				return nil, nil, upstreamError(err)
 			}
 			for _, account := range transactionsResp.GetAccounts() {
 				if account.Type == plaid.ACCOUNTTYPE_CREDIT {
@@ -306,7 +334,7 @@ func (p *PlaidClient) PlaidResponseToPB(lr models.LiabilitiesResponse, tr models
 			accId := al.AccountId.Get()
 			accountLiabilities[*accId] = al
 		} else {
-			p.L.Error("Error isolating accountLiabilities")
+			p.L.WithField("user_id", UserId).Error("Error isolating accountLiabilities")
 			return nil, errors.New("error isolating accountLiabilities")
 		}
 	}
@@ -422,102 +450,23 @@ func (p *PlaidClient) PlaidResponseToPB(lr models.LiabilitiesResponse, tr models
 	}, nil
 }
 
-// SaveToken method adds the permanent plaid token and stores into the plaid tokens' table with the
-// same id as the user.
-func (p *PlaidClient) SaveToken(token *models.Token) error {
-	token.ID = primitive.NewObjectID()
-	_, err := p.PlaidDb.InsertOne(p.C, token)
-	if err != nil {
-		p.L.Info("Error inserting new Token ", err)
-		return err
-	}
-	return nil
-}
-
-func (p *PlaidClient) UpdateToken(TokenId primitive.ObjectID, value, itemId string) error {
-	filter := bson.D{{Key: "_id", Value: TokenId}}
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "value", Value: value}, {Key: "item_id", Value: itemId}}}}
-	_, err := p.PlaidDb.UpdateOne(p.C, filter, update)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-// GetTokens returns every token associated to the user in the form of a slice of Token pointers.
-func (p *PlaidClient) GetTokens(Id primitive.ObjectID) (*[]models.Token, error) {
-	var results []models.Token
-	cursor, err := p.PlaidDb.Find(p.C, bson.D{{Key: "user._id", Value: Id}})
-	if err != nil {
-		return nil, err
-	}
-	if err = cursor.All(p.C, &results); err != nil {
-		p.L.Error("[PlaidDb] Error getting all users tokens", "error", err)
-		return nil, err
-	}
-	return &results, nil
-}
-
-// GetToken will get a token from the database and return it given the user's ID and the
-// token id
-func (p *PlaidClient) GetToken(accessToken, tokenId string) (*models.Token, error) {
-	var token models.Token
-	var filter []bson.M
-
-	if tokenId != "" {
-		id, err := primitive.ObjectIDFromHex(tokenId)
-		if err != nil {
-			return nil, err
-		}
-		filter = []bson.M{{"_id": id}, {"value": accessToken}}
-	} else {
-		filter = []bson.M{{"value": accessToken}}
-	}
-
-	err := p.PlaidDb.FindOne(p.C, bson.M{"$or": filter}).Decode(&token)
-	if err != nil {
-		return nil, err
-	}
-
-	return &token, nil
-}
-
-func (p *PlaidClient) GetUserToken(user *models.User) (*models.Token, error) {
-	var token models.Token
-	filter := []bson.M{{"user._id": user.ID}, {"user.username": user.Username}, {"user.email": user.Email}}
-	err := p.PlaidDb.FindOne(p.C, bson.M{"$or": filter}).Decode(&token)
-	if err != nil {
-		return nil, err
-	}
-	return &token, nil
-}
-
-func (p *PlaidClient) GetUser(email string) (*models.User, error) {
-	user, err := p.GetUserByEmail(email)
-	if err != nil {
-		fmt.Printf("failed to get a user: %+v", email)
-		return nil, err
-	}
-	return &models.User{
-		ID:       *user.GetID(),
-		Username: user.Username,
-		Email:    user.Email,
-	}, nil
+// AddIngestHook registers a hook to run every time a user's account details are fetched from plaid
+func (p *PlaidClient) AddIngestHook(hook IngestHook) {
+	p.ingestHooks = append(p.ingestHooks, hook)
 }
 
-func (p *PlaidClient) GetUserByClarkId(userId string) (*models.User, error) {
-	var user models.User
-	filter := bson.M{"clerk_id": userId}
-	err := p.UserDb.FindOne(p.C, filter).Decode(&user)
-	if err != nil {
-		p.L.Errorf("failed to get a user: %s", userId)
-		return nil, err
+// RunIngestHooks runs every registered hook in the background so the request that triggered the
+// fetch is not slowed down. The hooks keep the request's trace and logger but not its deadline or
+// cancellation.
+func (p *PlaidClient) RunIngestHooks(ctx context.Context, userId primitive.ObjectID, details *models.AccountDetailsResponse) {
+	background := logging.WithEntry(tracing.Detach(ctx), logging.FromContext(ctx))
+	for _, hook := range p.ingestHooks {
+		go func(hook IngestHook) {
+			ctx, cancel := context.WithTimeout(background, ingestHookTimeout)
+			defer cancel()
+			hook(ctx, userId, details)
+		}(hook)
 	}
-	return &models.User{
-		ID:       *user.GetID(),
-		Username: user.Username,
-		Email:    user.Email,
-	}, nil
 }
 
 func (p *PlaidClient) SetLinkToken(token *models.Token) {
@@ -556,14 +505,14 @@ func convertProducts(productStrs []string) []plaid.Products {
 	return products
 }
 
-func renderError(originalErr error) map[string]interface{} {
-	resp := make(map[string]interface{})
-	if plaidError, err := plaid.ToPlaidError(originalErr); err == nil {
-		resp["error"] = plaidError
-		return resp
-	}
-	resp["error"] = originalErr.Error()
-	return resp
+// plaidErrorLog returns the logger of ctx carrying err and its plaid error code
+func plaidErrorLog(ctx context.Context, err error) *logrus.Entry {
+	return logging.FromContext(ctx).WithError(err).WithField("plaid_error_code", GetPlaidErrorCode(err))
+}
+
+// upstreamError translates a failed plaid call into an api error the user can act on
+func upstreamError(err error) error {
+	return apierror.UpstreamPlaid(GetPlaidErrorCode(err), err)
 }
 
 // This is a helper function to authorize and create a Transfer after successful
@@ -573,9 +522,7 @@ func (p *PlaidClient) authorizeAndCreateTransfer(ctx context.Context, accessToke
 	// We call /accounts/get to obtain first account_id - in production,
 	// account_id's should be persisted in a data store and retrieved
 	// from there.
-	accountsGetResp, _, _ := p.Client.AccountsGet(ctx).AccountsGetRequest(
-		*plaid.NewAccountsGetRequest(accessToken),
-	).Execute()
+	accountsGetResp, _ := p.Client.AccountsGet(ctx, *plaid.NewAccountsGetRequest(accessToken))
 
 	accountID := accountsGetResp.GetAccounts()[0].AccountId
 
@@ -589,7 +536,7 @@ func (p *PlaidClient) authorizeAndCreateTransfer(ctx context.Context, accessToke
 		"ppd",
 		*transferAuthorizationCreateUser,
 	)
-	transferAuthorizationCreateResp, _, err := p.Client.TransferAuthorizationCreate(ctx).TransferAuthorizationCreateRequest(*transferAuthorizationCreateRequest).Execute()
+	transferAuthorizationCreateResp, err := p.Client.TransferAuthorizationCreate(ctx, *transferAuthorizationCreateRequest)
 	if err != nil {
 		return "", err
 	}
@@ -607,7 +554,7 @@ func (p *PlaidClient) authorizeAndCreateTransfer(ctx context.Context, accessToke
 		"ppd",
 		*transferAuthorizationCreateUser,
 	)
-	transferCreateResp, _, err := p.Client.TransferCreate(ctx).TransferCreateRequest(*transferCreateRequest).Execute()
+	transferCreateResp, err := p.Client.TransferCreate(ctx, *transferCreateRequest)
 	if err != nil {
 		return "", err
 	}
@@ -625,21 +572,3 @@ func itemExists(array []plaid.Products, product plaid.Products) bool {
 
 	return false
 }
-
-func (p *PlaidClient) GetUserByEmail(email string) (*models.User, error) {
-	var user models.User
-	filter := bson.M{"email": email}
-	err := p.UserDb.FindOne(p.C, filter).Decode(&user)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
-}
-
-func (p *PlaidClient) ClearCache(userID primitive.ObjectID, rcache *cache.Cache) error {
-	err := rcache.Delete(p.C, userID.Hex())
-	if err != nil {
-		return err
-	}
-	return nil
-}
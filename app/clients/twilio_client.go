@@ -1,46 +1,92 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/config"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/metrics"
 	"github.com/jalexanderII/zero-railway/models"
-	"github.com/sirupsen/logrus"
+	"github.com/jalexanderII/zero-railway/tracing"
 	"github.com/twilio/twilio-go"
+	twilioClient "github.com/twilio/twilio-go/client"
 	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
-	"os"
 )
 
 type TwilioClient struct {
-	Client *twilio.RestClient
-	L      *logrus.Logger
-	number string
+	// HTTP sends the requests made to twilio
+	HTTP       *http.Client
+	accountSid string
+	authToken  string
+	number     string
 }
 
-func NewTwilioClient(l *logrus.Logger) *TwilioClient {
-	accountSid := os.Getenv("TWILIO_ACCOUNT_SID")
-	authToken := os.Getenv("TWILIO_AUTH_TOKEN")
-	twilioNumber := os.Getenv("TWILIO_PHONE_NUMBER")
+func NewTwilioClient(cfg config.TwilioConfig) *TwilioClient {
 	return &TwilioClient{
-		Client: twilio.NewRestClientWithParams(twilio.ClientParams{
-			Username: accountSid,
-			Password: authToken,
-		}),
-		L:      l,
-		number: twilioNumber,
+		HTTP: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: tracing.Transport(nil),
+			// like twilio's default client, return redirects instead of following them
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		accountSid: cfg.AccountSid,
+		authToken:  cfg.AuthToken,
+		number:     cfg.PhoneNumber,
+	}
+}
+
+// api returns a twilio client whose requests are made with ctx. The twilio client does not take a
+// context, so it is given one through the transport of its HTTP client.
+func (t *TwilioClient) api(ctx context.Context) *twilio.RestClient {
+	httpClient := *t.HTTP
+	httpClient.Transport = contextTransport{ctx: ctx, next: t.HTTP.Transport}
+	base := &twilioClient.Client{
+		Credentials: twilioClient.NewCredentials(t.accountSid, t.authToken),
+		HTTPClient:  &httpClient,
+	}
+	base.SetAccountSid(t.accountSid)
+	return twilio.NewRestClientWithParams(twilio.ClientParams{
+		Username:   t.accountSid,
+		Password:   t.authToken,
+		AccountSid: t.accountSid,
+		Client:     base,
+	})
+}
+
+// contextTransport sends every request with ctx, so it is cancelled with ctx and traced under its span
+type contextTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
 	}
+	return next.RoundTrip(req.WithContext(t.ctx))
 }
 
-func (t *TwilioClient) SendSMS(to, body string) (*models.SendSMSResponse, error) {
+// SendSMS sends body to the given number
+func (t *TwilioClient) SendSMS(ctx context.Context, to, body string) (*models.SendSMSResponse, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "twilio.SendSMS")
+	defer span.End()
+
 	params := &twilioApi.CreateMessageParams{}
 	params.SetTo(to)
 	params.SetFrom(t.number)
 	params.SetBody(body)
 
-	resp, err := t.Client.Api.CreateMessage(params)
+	_, err := t.api(ctx).Api.CreateMessage(params)
+	metrics.ObserveSMS(err)
 	if err != nil {
-		t.L.Errorf("Error sending SMS: %s", err.Error())
+		span.RecordError(err)
+		logging.FromContext(ctx).WithError(err).Error("Error sending SMS")
 		return &models.SendSMSResponse{Successful: false, ErrorMessage: err.Error()}, err
-	} else {
-		_, _ = json.Marshal(*resp)
-		return &models.SendSMSResponse{Successful: true, ErrorMessage: "none"}, nil
 	}
+	return &models.SendSMSResponse{Successful: true, ErrorMessage: "none"}, nil
 }
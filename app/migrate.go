@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jalexanderII/zero-railway/config"
+	"github.com/sirupsen/logrus"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status"
+
+// RunMigrations handles the migrate subcommand: up applies every pending migration, down rolls back
+// the given number of migrations (default 1) and status lists them
+func RunMigrations(cfg *config.Config, l *logrus.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	store, err := connectStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore(store, l)
+
+	runner, err := newMigrationRunner(cfg, store, l)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := runner.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migration(s)\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return errors.New(migrateUsage)
+			}
+		}
+		rolledBack, err := runner.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rolled back %d migration(s)\n", rolledBack)
+	case "status":
+		statuses, err := runner.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-28s %s\n", status.Version, applied, status.Description)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
@@ -1,36 +1,99 @@
 package app
 
 import (
+	"context"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
 	"github.com/jalexanderII/zero-railway/config"
 	"github.com/jalexanderII/zero-railway/database"
+	"github.com/jalexanderII/zero-railway/database/migrations"
 	"github.com/jalexanderII/zero-railway/router"
+	"github.com/jalexanderII/zero-railway/tracing"
+	"github.com/sirupsen/logrus"
 )
 
 // SetupAndRunApp handle app and database start and graceful shutdown
-func SetupAndRunApp(port string) error {
+func SetupAndRunApp(cfg *config.Config, l *logrus.Logger) error {
+	// start tracing, flushing the remaining spans on shutdown
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			l.WithError(err).Error("failed flushing traces")
+		}
+	}()
+
 	// start database
-	err := database.StartMongoDB()
+	store, err := connectStore(cfg)
 	if err != nil {
 		return err
 	}
 
-	// defer closing database
-	defer database.CloseMongoDB()
+	// defer closing database, with a fresh context so the disconnect is not cut short
+	defer closeStore(store, l)
+
+	// apply pending migrations unless they are run separately through the migrate command
+	if cfg.MigrateOnStartup {
+		runner, err := newMigrationRunner(cfg, store, l)
+		if err != nil {
+			return err
+		}
+		if _, err = runner.Up(context.Background()); err != nil {
+			return err
+		}
+	}
 
 	// create app
-	app := fiber.New()
+	// render every returned error through the api error model
+	app := fiber.New(fiber.Config{ErrorHandler: apierror.ErrorHandler})
 
 	// attach middleware
-	FiberMiddleware(app)
+	FiberMiddleware(app, cfg, l)
 
 	// setup routes
-	router.SetupRoutes(app)
+	router.SetupRoutes(app, cfg, store, l)
 
 	// attach swagger
 	config.AddSwaggerRoutes(app)
 
-	StartServerWithGracefulShutdown(app, port)
+	StartServerWithGracefulShutdown(app, ":"+cfg.Port, l)
 
 	return nil
 }
+
+func connectStore(cfg *config.Config) (*database.Store, error) {
+	store, err := database.NewStore(database.Config{
+		URI:                    cfg.Mongo.URI,
+		Database:               cfg.Mongo.Database,
+		MaxPoolSize:            cfg.Mongo.MaxPoolSize,
+		MinPoolSize:            cfg.Mongo.MinPoolSize,
+		ConnectTimeout:         cfg.Mongo.ConnectTimeout,
+		ServerSelectionTimeout: cfg.Mongo.ServerSelectionTimeout,
+		PingTimeout:            cfg.Mongo.PingTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = store.Connect(context.Background()); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func closeStore(store *database.Store, l *logrus.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := store.Close(ctx); err != nil {
+		l.WithError(err).Error("failed closing database")
+	}
+}
+
+func newMigrationRunner(cfg *config.Config, store *database.Store, l *logrus.Logger) (*migrations.Runner, error) {
+	return migrations.NewRunner(store.Database(), migrations.All(cfg.Collections.Names()), l)
+}
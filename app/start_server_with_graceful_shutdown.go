@@ -1,15 +1,15 @@
 package app
 
 import (
-	"log"
 	"os"
 	"os/signal"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
 )
 
 // StartServerWithGracefulShutdown function for starting server with a graceful shutdown.
-func StartServerWithGracefulShutdown(a *fiber.App, port string) {
+func StartServerWithGracefulShutdown(a *fiber.App, port string, l *logrus.Logger) {
 	// Create a channel for idle connections.
 	idleConnsClosed := make(chan struct{})
 
@@ -21,7 +21,7 @@ func StartServerWithGracefulShutdown(a *fiber.App, port string) {
 		// Received an interrupt signal, shutdown.
 		if err := a.Shutdown(); err != nil {
 			// Error from closing listeners, or context timeout:
-			log.Printf("Oops... Server is not shutting down! Reason: %v", err)
+			l.WithError(err).Error("server is not shutting down")
 		}
 
 		close(idleConnsClosed)
@@ -29,7 +29,7 @@ func StartServerWithGracefulShutdown(a *fiber.App, port string) {
 
 	// Run server.
 	if err := a.Listen(port); err != nil {
-		log.Printf("Oops... Server is not running! Reason: %v", err)
+		l.WithError(err).Error("server is not running")
 	}
 	<-idleConnsClosed
 }
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// principalKey is the fiber local holding the authenticated user of the request
+const principalKey = "principal"
+
+// SetPrincipal records user as the authenticated user of the request
+func SetPrincipal(c *fiber.Ctx, user *models.User) {
+	c.Locals(principalKey, user)
+}
+
+// Principal returns the authenticated user of the request, nil when nobody is signed in
+func Principal(c *fiber.Ctx) *models.User {
+	user, _ := c.Locals(principalKey).(*models.User)
+	return user
+}
+
+// Owner reports whether the resource identified by value, taken from the path, belongs to principal
+type Owner func(c *fiber.Ctx, principal *models.User, value string) (bool, error)
+
+// RequireRole rejects requests whose user does not have role
+func RequireRole(role models.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal := Principal(c)
+		if principal == nil {
+			return apierror.Unauthorized("sign in to access this resource")
+		}
+		if !principal.HasRole(role) {
+			return apierror.Forbidden("you are not allowed to access this resource")
+		}
+		return c.Next()
+	}
+}
+
+// OwnsParam rejects requests for a resource, named by the path parameter param, that does not belong to
+// the signed in user. Admins may access every user's resources.
+func OwnsParam(param string, owns Owner) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal := Principal(c)
+		if principal == nil {
+			return apierror.Unauthorized("sign in to access this resource")
+		}
+		if principal.IsAdmin() {
+			return c.Next()
+		}
+		ok, err := owns(c, principal, c.Params(param))
+		if err != nil {
+			return apierror.Wrap(err, "failed checking access to the resource")
+		}
+		if !ok {
+			// reported as missing so ids of other users can't be probed
+			return apierror.NotFound("resource not found")
+		}
+		return c.Next()
+	}
+}
+
+// ActAs rejects acting on behalf of userId, unless it is the signed in user or that user is an admin
+func ActAs(c *fiber.Ctx, userId primitive.ObjectID) error {
+	principal := Principal(c)
+	if principal == nil {
+		return apierror.Unauthorized("sign in to access this resource")
+	}
+	if principal.ID != userId && !principal.IsAdmin() {
+		return apierror.Forbidden("you are not allowed to act for another user")
+	}
+	return nil
+}
+
+// UserID is the Owner of paths naming a user by id, they belong to that user
+func UserID(_ *fiber.Ctx, principal *models.User, value string) (bool, error) {
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		return false, apierror.Validation("invalid user id", err.Error())
+	}
+	return principal.ID == id, nil
+}
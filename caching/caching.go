@@ -0,0 +1,125 @@
+// Package caching is the typed cache of the app, kept in redis with a local in process layer on each
+// replica. Keys are namespaced and versioned as zero:<version>:<entity>:<id>, and invalidations are
+// broadcast over redis so every replica drops its local copy.
+package caching
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/metrics"
+)
+
+const (
+	namespace = "zero"
+	// version is bumped whenever the shape of a cached value changes, so entries written by older
+	// releases are never read
+	version = "v3"
+	// invalidationChannel carries the keys invalidated by any replica
+	invalidationChannel = namespace + ":" + version + ":invalidate"
+)
+
+// ErrMiss is returned when a value is not cached
+var ErrMiss = cache.ErrCacheMiss
+
+// Store holds a cache per entity over a shared redis and local cache
+type Store struct {
+	c   *cache.Cache
+	rdb redis.UniversalClient
+
+	Users          *UserCache
+	AccountDetails *AccountDetailsCache
+}
+
+// New returns a Store over rdb, keeping up to localSize entries in the local cache for localTTL. A nil
+// rdb keeps the store local to this process, for a single replica and for tests.
+func New(rdb redis.UniversalClient, localSize int, localTTL time.Duration) *Store {
+	s := &Store{
+		c:   cache.New(&cache.Options{Redis: rdb, LocalCache: cache.NewTinyLFU(localSize, localTTL)}),
+		rdb: rdb,
+	}
+	s.Users = &UserCache{s: s}
+	s.AccountDetails = &AccountDetailsCache{s: s}
+	return s
+}
+
+// Key returns the key of an entity, identified by one or more parts
+func Key(entity string, parts ...string) string {
+	return strings.Join(append([]string{namespace, version, entity}, parts...), ":")
+}
+
+// get reads the value cached under key, recording the lookup under name
+func (s *Store) get(ctx context.Context, name, key string, value any) error {
+	err := s.c.Get(ctx, key, value)
+	metrics.ObserveCacheLookup(name, err == nil)
+	return err
+}
+
+func (s *Store) set(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return s.c.Set(&cache.Item{Ctx: ctx, Key: key, Value: value, TTL: ttl})
+}
+
+// setShared caches value under key and drops the copies other replicas hold locally, for values that
+// replace a still valid entry
+func (s *Store) setShared(ctx context.Context, key string, value any, ttl time.Duration) error {
+	if err := s.set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	return s.publish(ctx, key)
+}
+
+// Invalidate removes the keys from redis and from the local cache of every replica
+func (s *Store) Invalidate(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := s.c.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return s.publish(ctx, keys...)
+}
+
+// publish tells every replica to drop its local copy of the keys
+func (s *Store) publish(ctx context.Context, keys ...string) error {
+	if s.rdb == nil {
+		return nil
+	}
+	payload, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Publish(ctx, invalidationChannel, payload).Err()
+}
+
+// Subscribe drops the local copies of the keys invalidated by any replica, until ctx is done
+func (s *Store) Subscribe(ctx context.Context) {
+	if s.rdb == nil {
+		return
+	}
+	pubsub := s.rdb.Subscribe(ctx, invalidationChannel)
+	go func() {
+		defer pubsub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-pubsub.Channel():
+				if !ok {
+					return
+				}
+				var keys []string
+				if err := json.Unmarshal([]byte(msg.Payload), &keys); err != nil {
+					logging.FromContext(ctx).WithError(err).Error("[Cache] Error decoding invalidation")
+					continue
+				}
+				for _, key := range keys {
+					s.c.DeleteFromLocalCache(key)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,135 @@
+package caching
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/metrics"
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	userTTL             = 24 * time.Hour
+	accountDetailsFresh = 24 * time.Hour
+	accountDetailsStale = 24 * time.Hour
+	// a snapshot missing the accounts of a failed Item is retried sooner and dropped sooner
+	accountDetailsPartialFresh = 5 * time.Minute
+	accountDetailsPartialStale = time.Hour
+)
+
+// UserCache caches users under their clerk id, for the signed in user of each request, and their email
+type UserCache struct {
+	s *Store
+}
+
+func userByClerkIdKey(clerkId string) string { return Key("user", "clerk_id", clerkId) }
+func userByEmailKey(email string) string     { return Key("user", "email", email) }
+
+func (c *UserCache) GetByClerkId(ctx context.Context, clerkId string) (*models.User, error) {
+	var user models.User
+	if err := c.s.get(ctx, metrics.CacheUserByClerkId, userByClerkIdKey(clerkId), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *UserCache) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	if err := c.s.get(ctx, metrics.CacheUserByEmail, userByEmailKey(email), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Set caches the user under their clerk id and email
+func (c *UserCache) Set(ctx context.Context, user *models.User) error {
+	for _, key := range c.keys(user.Email, user.ClerkId) {
+		if err := c.s.set(ctx, key, user, userTTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invalidate removes the user cached under their email and clerk id, either of which may be empty
+func (c *UserCache) Invalidate(ctx context.Context, email, clerkId string) error {
+	return c.s.Invalidate(ctx, c.keys(email, clerkId)...)
+}
+
+func (c *UserCache) keys(email, clerkId string) []string {
+	var keys []string
+	if email != "" {
+		keys = append(keys, userByEmailKey(email))
+	}
+	if clerkId != "" {
+		keys = append(keys, userByClerkIdKey(clerkId))
+	}
+	return keys
+}
+
+// AccountDetailsCache caches the accounts and transactions last fetched from plaid for each user. A
+// snapshot is fresh for accountDetailsFresh, then served stale for accountDetailsStale while it is
+// refreshed in the background. Partial snapshots use the shorter accountDetailsPartial periods.
+type AccountDetailsCache struct {
+	s *Store
+
+	mu sync.Mutex
+	// flights are the fetches running on this replica, by user
+	flights map[primitive.ObjectID]*flight
+}
+
+// accountDetailsEntry is a snapshot along with when it was fetched from plaid
+type accountDetailsEntry struct {
+	Details   *models.AccountDetailsResponse `json:"details"`
+	FetchedAt time.Time                      `json:"fetched_at"`
+}
+
+// fresh reports whether the snapshot is recent enough to be served without a refresh
+func (e *accountDetailsEntry) fresh() bool {
+	if e.Details.Partial() {
+		return time.Since(e.FetchedAt) <= accountDetailsPartialFresh
+	}
+	return time.Since(e.FetchedAt) <= accountDetailsFresh
+}
+
+// ttl is how long the snapshot is kept, fresh then stale
+func (e *accountDetailsEntry) ttl() time.Duration {
+	if e.Details.Partial() {
+		return accountDetailsPartialFresh + accountDetailsPartialStale
+	}
+	return accountDetailsFresh + accountDetailsStale
+}
+
+func accountDetailsKey(userId primitive.ObjectID) string { return Key("account_details", userId.Hex()) }
+
+// AccountDetailsFetcher fetches the account details of a user from plaid
+type AccountDetailsFetcher func(ctx context.Context) (*models.AccountDetailsResponse, error)
+
+// Fetch returns the cached account details of the user, calling fetch when none are cached. A stale
+// snapshot is returned right away while a single refresh runs in the background.
+func (c *AccountDetailsCache) Fetch(ctx context.Context, userId primitive.ObjectID, fetch AccountDetailsFetcher) (*models.AccountDetailsResponse, error) {
+	var entry accountDetailsEntry
+	err := c.s.get(ctx, metrics.CacheAccountDetails, accountDetailsKey(userId), &entry)
+	if err != nil && err != ErrMiss {
+		return nil, err
+	}
+	if err == nil && entry.Details != nil {
+		if !entry.fresh() {
+			c.start(ctx, userId, fetch, time.Time{})
+		}
+		return entry.Details, nil
+	}
+	return c.start(ctx, userId, fetch, time.Time{}).wait(ctx)
+}
+
+// Refresh fetches the account details of the user even if a fresh snapshot is cached, only sharing a
+// fetch that started after the call
+func (c *AccountDetailsCache) Refresh(ctx context.Context, userId primitive.ObjectID, fetch AccountDetailsFetcher) (*models.AccountDetailsResponse, error) {
+	return c.start(ctx, userId, fetch, time.Now()).wait(ctx)
+}
+
+func (c *AccountDetailsCache) Invalidate(ctx context.Context, userId primitive.ObjectID) error {
+	return c.s.Invalidate(ctx, accountDetailsKey(userId))
+}
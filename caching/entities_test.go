@@ -0,0 +1,60 @@
+package caching
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFetchRefreshesPartialSnapshotSooner(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  models.ItemSync
+		refresh bool
+	}{
+		{"complete snapshot is still fresh", models.ITEM_SYNC_OK, false},
+		{"partial snapshot is stale", models.ITEM_SYNC_ERROR, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := New(nil, 1000, time.Minute)
+			userId := primitive.NewObjectID()
+			cached := &models.AccountDetailsResponse{Items: []*models.ItemSyncStatus{{ItemId: "item", Status: tt.status}}}
+			entry := accountDetailsEntry{Details: cached, FetchedAt: time.Now().Add(-10 * time.Minute)}
+			if err := s.set(ctx, accountDetailsKey(userId), &entry, entry.ttl()); err != nil {
+				t.Fatal(err)
+			}
+
+			fetched := make(chan struct{})
+			fetch := func(ctx context.Context) (*models.AccountDetailsResponse, error) {
+				close(fetched)
+				return &models.AccountDetailsResponse{Items: []*models.ItemSyncStatus{{ItemId: "item", Status: models.ITEM_SYNC_OK}}}, nil
+			}
+			details, err := s.AccountDetails.Fetch(ctx, userId, fetch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if details.Items[0].Status != tt.status {
+				t.Errorf("Fetch returned status %s, want the cached %s", details.Items[0].Status, tt.status)
+			}
+
+			if !tt.refresh {
+				s.AccountDetails.mu.Lock()
+				defer s.AccountDetails.mu.Unlock()
+				if len(s.AccountDetails.flights) != 0 {
+					t.Error("Fetch refreshed a fresh snapshot")
+				}
+				return
+			}
+			select {
+			case <-fetched:
+			case <-time.After(time.Second):
+				t.Fatal("Fetch did not refresh the stale snapshot")
+			}
+		})
+	}
+}
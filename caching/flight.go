@@ -0,0 +1,165 @@
+package caching
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/tracing"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// fetchLockTTL bounds how long a replica holds the lock on a user's fetch, the fetch itself is
+	// cancelled by then so the lock is never released while it still runs
+	fetchLockTTL = time.Minute
+	// fetchLockPoll is how often a replica waiting on another one's fetch checks for its result
+	fetchLockPoll = 250 * time.Millisecond
+)
+
+// releaseLock deletes the lock only if it is still held with the token, so a lock that expired and was
+// taken by another replica is left alone
+var releaseLock = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// flight is a fetch of a user's account details, shared by every caller on this replica
+type flight struct {
+	startedAt time.Time
+	done      chan struct{}
+	details   *models.AccountDetailsResponse
+	err       error
+}
+
+// wait returns the result of the flight, or gives up once ctx is done without cancelling the flight
+func (f *flight) wait(ctx context.Context) (*models.AccountDetailsResponse, error) {
+	select {
+	case <-f.done:
+		return f.details, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// start joins the fetch of the user's account details running on this replica, or starts one. The
+// fetch runs detached from ctx so callers giving up don't cancel it for the others.
+func (c *AccountDetailsCache) start(ctx context.Context, userId primitive.ObjectID, fetch AccountDetailsFetcher, notBefore time.Time) *flight {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.flights == nil {
+		c.flights = make(map[primitive.ObjectID]*flight)
+	}
+	if f, ok := c.flights[userId]; ok && !f.startedAt.Before(notBefore) {
+		return f
+	}
+
+	f := &flight{startedAt: time.Now(), done: make(chan struct{})}
+	previous := c.flights[userId]
+	c.flights[userId] = f
+	background := logging.WithEntry(tracing.Detach(ctx), logging.FromContext(ctx))
+	go func() {
+		ctx, cancel := context.WithTimeout(background, fetchLockTTL)
+		defer cancel()
+		if previous != nil {
+			// a fetch that started too early still runs, let it finish rather than race it
+			<-previous.done
+		}
+		f.details, f.err = c.fetchLocked(ctx, userId, fetch, notBefore)
+		if f.err != nil {
+			logging.FromContext(ctx).WithError(f.err).WithField("user_id", userId.Hex()).Error("[Cache] Error fetching account details")
+		}
+
+		c.mu.Lock()
+		if c.flights[userId] == f {
+			delete(c.flights, userId)
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// fetchLocked fetches and caches the user's account details while holding their lock in redis, so a
+// single replica fetches them at once. Replicas that find the lock taken wait for its holder's result.
+func (c *AccountDetailsCache) fetchLocked(ctx context.Context, userId primitive.ObjectID, fetch AccountDetailsFetcher, notBefore time.Time) (*models.AccountDetailsResponse, error) {
+	if c.s.rdb == nil {
+		// no other replica to share the fetch with
+		return c.fetchAndStore(ctx, userId, fetch)
+	}
+	lockKey := Key("lock", "account_details", userId.Hex())
+	token, err := lockToken()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		acquired, err := c.s.rdb.SetNX(ctx, lockKey, token, fetchLockTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		// the holder that just released the lock may have stored what we need
+		if details, ok := c.fetchedSince(ctx, userId, notBefore); ok {
+			if acquired {
+				_ = releaseLock.Run(ctx, c.s.rdb, []string{lockKey}, token).Err()
+			}
+			return details, nil
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(fetchLockPoll):
+		}
+	}
+	defer func() {
+		// released even if ctx is done, otherwise other replicas wait for the lock to expire
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := releaseLock.Run(ctx, c.s.rdb, []string{lockKey}, token).Err(); err != nil {
+			logging.FromContext(ctx).WithError(err).Error("[Cache] Error releasing account details lock")
+		}
+	}()
+	return c.fetchAndStore(ctx, userId, fetch)
+}
+
+// fetchAndStore fetches the user's account details and caches them for every replica
+func (c *AccountDetailsCache) fetchAndStore(ctx context.Context, userId primitive.ObjectID, fetch AccountDetailsFetcher) (*models.AccountDetailsResponse, error) {
+	details, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entry := accountDetailsEntry{Details: details, FetchedAt: time.Now()}
+	if err = c.s.setShared(ctx, accountDetailsKey(userId), &entry, entry.ttl()); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// fetchedSince returns the account details cached in redis if they are fresh and were fetched after
+// notBefore. The local cache is skipped as it may hold a copy older than redis.
+func (c *AccountDetailsCache) fetchedSince(ctx context.Context, userId primitive.ObjectID, notBefore time.Time) (*models.AccountDetailsResponse, bool) {
+	var entry accountDetailsEntry
+	if err := c.s.c.GetSkippingLocalCache(ctx, accountDetailsKey(userId), &entry); err != nil || entry.Details == nil {
+		return nil, false
+	}
+	if !entry.fresh() || entry.FetchedAt.Before(notBefore) {
+		return nil, false
+	}
+	return entry.Details, true
+}
+
+// lockToken identifies the holder of a lock
+func lockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
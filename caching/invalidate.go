@@ -0,0 +1,121 @@
+package caching
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// InvalidateUsers wraps users so every write invalidates the cached copies of the user it changed
+func InvalidateUsers(users repository.UserRepo, s *Store) repository.UserRepo {
+	return &invalidatingUserRepo{UserRepo: users, s: s}
+}
+
+type invalidatingUserRepo struct {
+	repository.UserRepo
+	s *Store
+}
+
+func (r *invalidatingUserRepo) Create(ctx context.Context, user *models.User) (primitive.ObjectID, error) {
+	id, err := r.UserRepo.Create(ctx, user)
+	if err != nil {
+		return id, err
+	}
+	// a user previously deleted may still be cached under the same email or clerk id
+	return id, r.s.Users.Invalidate(ctx, user.Email, user.ClerkId)
+}
+
+func (r *invalidatingUserRepo) UpdatePhoneNumber(ctx context.Context, id primitive.ObjectID, phoneNumber string) (int64, error) {
+	modified, err := r.UserRepo.UpdatePhoneNumber(ctx, id, phoneNumber)
+	if err != nil {
+		return modified, err
+	}
+	return modified, r.invalidate(ctx, id)
+}
+
+func (r *invalidatingUserRepo) UpdateNotificationPreferences(ctx context.Context, id primitive.ObjectID, prefs *models.NotificationPreferences) (int64, error) {
+	modified, err := r.UserRepo.UpdateNotificationPreferences(ctx, id, prefs)
+	if err != nil {
+		return modified, err
+	}
+	return modified, r.invalidate(ctx, id)
+}
+
+func (r *invalidatingUserRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	user, err := r.UserRepo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = r.UserRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+	if err = r.s.Users.Invalidate(ctx, user.Email, user.ClerkId); err != nil {
+		return err
+	}
+	return r.s.AccountDetails.Invalidate(ctx, id)
+}
+
+// invalidate removes the cached copies of the user with id
+func (r *invalidatingUserRepo) invalidate(ctx context.Context, id primitive.ObjectID) error {
+	user, err := r.UserRepo.GetByID(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.s.Users.Invalidate(ctx, user.Email, user.ClerkId)
+}
+
+// InvalidateTokens wraps tokens so linking, relinking or removing an Item invalidates the cached account
+// details of its user
+func InvalidateTokens(tokens repository.TokenRepo, s *Store) repository.TokenRepo {
+	return &invalidatingTokenRepo{TokenRepo: tokens, s: s}
+}
+
+type invalidatingTokenRepo struct {
+	repository.TokenRepo
+	s *Store
+}
+
+func (r *invalidatingTokenRepo) Create(ctx context.Context, token *models.Token) error {
+	if err := r.TokenRepo.Create(ctx, token); err != nil {
+		return err
+	}
+	return r.invalidate(ctx, token)
+}
+
+func (r *invalidatingTokenRepo) Update(ctx context.Context, id primitive.ObjectID, value, itemId string) error {
+	if err := r.TokenRepo.Update(ctx, id, value, itemId); err != nil {
+		return err
+	}
+	token, err := r.TokenRepo.Get(ctx, value, id.Hex())
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.invalidate(ctx, token)
+}
+
+func (r *invalidatingTokenRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	token, err := r.TokenRepo.Get(ctx, "", id.Hex())
+	if err != nil {
+		return err
+	}
+	if err = r.TokenRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+	return r.invalidate(ctx, token)
+}
+
+func (r *invalidatingTokenRepo) invalidate(ctx context.Context, token *models.Token) error {
+	if token.User == nil {
+		return nil
+	}
+	return r.s.AccountDetails.Invalidate(ctx, token.User.ID)
+}
@@ -0,0 +1,371 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/repository"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the typed configuration of the app. Each setting is read, in increasing priority, from its
+// default, the optional YAML file named by CONFIG_FILE, the .env file and the process environment.
+//
+// Fields are described by struct tags: env names the environment variable, default its value when
+// unset, required fails Load when it stays empty and secret masks it when the config is printed.
+type Config struct {
+	Environment string `yaml:"environment" env:"RAILWAY_ENVIRONMENT" default:"development"`
+	Port        string `yaml:"port" env:"PORT" default:"8080"`
+	// LogLevel is the minimum logrus level logged, e.g. debug, info or warn
+	LogLevel         string `yaml:"log_level" env:"LOG_LEVEL" default:"info"`
+	MigrateOnStartup bool   `yaml:"migrate_on_startup" env:"MIGRATE_ON_STARTUP" default:"true"`
+	PlanningURL      string `yaml:"planning_url" env:"PLANNING_URL" required:"true"`
+	// HealthCheckTimeout bounds each dependency check of the readiness endpoint
+	HealthCheckTimeout time.Duration `yaml:"health_check_timeout" env:"HEALTH_CHECK_TIMEOUT" default:"2s"`
+	// RequestTimeout is the deadline of a request, RouteTimeouts overrides it for the routes under a path
+	// prefix, e.g. ROUTE_TIMEOUTS=/api/core/kpi=20s,/api/plaid=30s
+	RequestTimeout time.Duration            `yaml:"request_timeout" env:"REQUEST_TIMEOUT" default:"10s"`
+	RouteTimeouts  map[string]time.Duration `yaml:"route_timeouts" env:"ROUTE_TIMEOUTS"`
+	// ClerkWebhookSecret verifies the signature of clerk webhooks, the whsec_ secret of the endpoint.
+	// Without it webhooks are unverified and user deletions from clerk are refused.
+	ClerkWebhookSecret string `yaml:"clerk_webhook_secret" env:"CLERK_WEBHOOK_SECRET" secret:"true"`
+	// MetricsToken is the bearer token scrapers present to read /metrics, which is not served without it
+	MetricsToken string `yaml:"metrics_token" env:"METRICS_TOKEN" secret:"true"`
+	// DeletionTimeout bounds a single attempt at deleting an account
+	DeletionTimeout time.Duration `yaml:"deletion_timeout" env:"DELETION_TIMEOUT" default:"5m"`
+	// SnapshotJobTimeout bounds a run of the job refreshing every user's balance snapshot
+	SnapshotJobTimeout time.Duration `yaml:"snapshot_job_timeout" env:"SNAPSHOT_JOB_TIMEOUT" default:"30m"`
+
+	Mongo       MongoConfig       `yaml:"mongo"`
+	Redis       RedisConfig       `yaml:"redis"`
+	Collections CollectionsConfig `yaml:"collections"`
+	Plaid       PlaidConfig       `yaml:"plaid"`
+	Twilio      TwilioConfig      `yaml:"twilio"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Exports     ExportsConfig     `yaml:"exports"`
+}
+
+type MongoConfig struct {
+	URI                    string        `yaml:"uri" env:"MONGODB_URI" required:"true" secret:"true"`
+	Database               string        `yaml:"database" env:"DATABASE" required:"true"`
+	MaxPoolSize            uint64        `yaml:"max_pool_size" env:"MONGODB_MAX_POOL_SIZE"`
+	MinPoolSize            uint64        `yaml:"min_pool_size" env:"MONGODB_MIN_POOL_SIZE"`
+	ConnectTimeout         time.Duration `yaml:"connect_timeout" env:"MONGODB_CONNECT_TIMEOUT" default:"10s"`
+	ServerSelectionTimeout time.Duration `yaml:"server_selection_timeout" env:"MONGODB_SERVER_SELECTION_TIMEOUT" default:"10s"`
+	PingTimeout            time.Duration `yaml:"ping_timeout" env:"MONGODB_PING_TIMEOUT" default:"2s"`
+}
+
+type RedisConfig struct {
+	URI string `yaml:"uri" env:"REDIS_URI" required:"true" secret:"true"`
+}
+
+type CollectionsConfig struct {
+	Users             string `yaml:"users" env:"USER_COLLECTION" default:"users"`
+	Tokens            string `yaml:"tokens" env:"PLAID_COLLECTION" default:"plaid_tokens"`
+	Accounts          string `yaml:"accounts" env:"ACCOUNT_COLLECTION" default:"accounts"`
+	Transactions      string `yaml:"transactions" env:"TRANSACTION_COLLECTION" default:"transactions"`
+	PaymentTasks      string `yaml:"payment_tasks" env:"PAYMENT_TASK_COLLECTION" default:"payment_tasks"`
+	Budgets           string `yaml:"budgets" env:"BUDGET_COLLECTION" default:"budgets"`
+	BudgetAlerts      string `yaml:"budget_alerts" env:"BUDGET_ALERT_COLLECTION" default:"budget_alerts"`
+	BalanceSnapshots  string `yaml:"balance_snapshots" env:"BALANCE_SNAPSHOT_COLLECTION" default:"balance_snapshots"`
+	UtilizationAlerts string `yaml:"utilization_alerts" env:"UTILIZATION_ALERT_COLLECTION" default:"utilization_alerts"`
+	Reminders         string `yaml:"reminders" env:"REMINDER_COLLECTION" default:"reminders"`
+	AuditEvents       string `yaml:"audit_events" env:"AUDIT_COLLECTION" default:"audit_events"`
+	Exports           string `yaml:"exports" env:"EXPORT_COLLECTION" default:"data_exports"`
+	ExportArchives    string `yaml:"export_archives" env:"EXPORT_ARCHIVE_BUCKET" default:"export_archives"`
+	Deletions         string `yaml:"deletions" env:"DELETION_COLLECTION" default:"account_deletions"`
+}
+
+// Names returns the collection names in the form used by the repositories
+func (c CollectionsConfig) Names() repository.Collections {
+	return repository.Collections{
+		Users:             c.Users,
+		Tokens:            c.Tokens,
+		Accounts:          c.Accounts,
+		Transactions:      c.Transactions,
+		PaymentTasks:      c.PaymentTasks,
+		Budgets:           c.Budgets,
+		BudgetAlerts:      c.BudgetAlerts,
+		BalanceSnapshots:  c.BalanceSnapshots,
+		UtilizationAlerts: c.UtilizationAlerts,
+		Reminders:         c.Reminders,
+		AuditEvents:       c.AuditEvents,
+		Exports:           c.Exports,
+		ExportArchives:    c.ExportArchives,
+		Deletions:         c.Deletions,
+	}
+}
+
+type PlaidConfig struct {
+	Env               string   `yaml:"env" env:"PLAID_ENV" default:"sandbox"`
+	ClientID          string   `yaml:"client_id" env:"PLAID_CLIENT_ID" required:"true"`
+	SecretSandbox     string   `yaml:"secret_sandbox" env:"PLAID_SECRET_SANDBOX" secret:"true"`
+	SecretDevelopment string   `yaml:"secret_development" env:"PLAID_SECRET_DEV" secret:"true"`
+	SecretProduction  string   `yaml:"secret_production" env:"PLAID_SECRET_PROD" secret:"true"`
+	Products          []string `yaml:"products" env:"PLAID_PRODUCTS" default:"transactions,liabilities"`
+	CountryCodes      []string `yaml:"country_codes" env:"PLAID_COUNTRY_CODES" default:"US"`
+	RedirectURI       string   `yaml:"redirect_uri" env:"PLAID_REDIRECT_URI"`
+}
+
+// Secret returns the plaid secret of the configured environment
+func (p PlaidConfig) Secret() string {
+	switch p.Env {
+	case "sandbox":
+		return p.SecretSandbox
+	case "development":
+		return p.SecretDevelopment
+	case "production":
+		return p.SecretProduction
+	}
+	return ""
+}
+
+// secretEnv names the environment variable holding the secret of the configured environment
+func (p PlaidConfig) secretEnv() string {
+	return map[string]string{
+		"sandbox":     "PLAID_SECRET_SANDBOX",
+		"development": "PLAID_SECRET_DEV",
+		"production":  "PLAID_SECRET_PROD",
+	}[p.Env]
+}
+
+type TwilioConfig struct {
+	AccountSid  string `yaml:"account_sid" env:"TWILIO_ACCOUNT_SID" required:"true"`
+	AuthToken   string `yaml:"auth_token" env:"TWILIO_AUTH_TOKEN" required:"true" secret:"true"`
+	PhoneNumber string `yaml:"phone_number" env:"TWILIO_PHONE_NUMBER" required:"true"`
+}
+
+// TracingConfig uses the standard OpenTelemetry variable names. The OTLP endpoint and headers are read
+// by the exporter itself from OTEL_EXPORTER_OTLP_*.
+type TracingConfig struct {
+	// Exporter is otlp to export spans, or none
+	Exporter    string  `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" default:"none"`
+	ServiceName string  `yaml:"service_name" env:"OTEL_SERVICE_NAME" default:"zero-railway"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"OTEL_TRACES_SAMPLER_ARG" default:"1"`
+}
+
+// ExportsConfig controls the data exports users request of their own data
+type ExportsConfig struct {
+	// SigningKey signs the download URLs of archives. When unset a random key is used, so URLs only work
+	// on the instance that issued them until it restarts.
+	SigningKey string `yaml:"signing_key" env:"EXPORT_SIGNING_KEY" secret:"true"`
+	// URLTTL is how long a download URL stays valid
+	URLTTL time.Duration `yaml:"url_ttl" env:"EXPORT_URL_TTL" default:"15m"`
+	// Retention is how long an export and its archive are kept
+	Retention time.Duration `yaml:"retention" env:"EXPORT_RETENTION" default:"24h"`
+	// Timeout bounds building an archive
+	Timeout time.Duration `yaml:"timeout" env:"EXPORT_TIMEOUT" default:"5m"`
+}
+
+// IsProduction reports whether the app runs in the production railway environment
+func (c *Config) IsProduction() bool {
+	return c.Environment == "production"
+}
+
+// Load builds the Config and validates it, reporting every missing or invalid setting at once
+func Load() (*Config, error) {
+	if err := LoadENV(); err != nil {
+		return nil, fmt.Errorf("loading .env: %w", err)
+	}
+
+	cfg := &Config{}
+	var problems []string
+	walk(reflect.ValueOf(cfg).Elem(), func(field reflect.Value, tag reflect.StructTag) {
+		if def, ok := tag.Lookup("default"); ok {
+			if err := setField(field, def); err != nil {
+				problems = append(problems, fmt.Sprintf("invalid default for %s: %v", tag.Get("env"), err))
+			}
+		}
+	})
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		if err = yaml.UnmarshalStrict(raw, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+
+	var missing []string
+	walk(reflect.ValueOf(cfg).Elem(), func(field reflect.Value, tag reflect.StructTag) {
+		env := tag.Get("env")
+		if v, ok := os.LookupEnv(env); ok && v != "" {
+			if err := setField(field, v); err != nil {
+				problems = append(problems, fmt.Sprintf("invalid %s: %v", env, err))
+			}
+		}
+		if tag.Get("required") == "true" && field.IsZero() {
+			missing = append(missing, env)
+		}
+	})
+
+	if _, ok := map[string]bool{"sandbox": true, "development": true, "production": true}[cfg.Plaid.Env]; !ok {
+		problems = append(problems, "PLAID_ENV must be one of sandbox, development, production")
+	} else if cfg.Plaid.Secret() == "" {
+		missing = append(missing, cfg.Plaid.secretEnv())
+	}
+	if cfg.Tracing.Exporter != "otlp" && cfg.Tracing.Exporter != "none" {
+		problems = append(problems, "OTEL_TRACES_EXPORTER must be one of otlp, none")
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		problems = append(problems, "OTEL_TRACES_SAMPLER_ARG must be between 0 and 1")
+	}
+	if _, err := logrus.ParseLevel(cfg.LogLevel); err != nil {
+		problems = append(problems, "LOG_LEVEL must be a logrus level, e.g. debug, info or warn")
+	}
+	if cfg.RequestTimeout <= 0 {
+		problems = append(problems, "REQUEST_TIMEOUT must be positive")
+	}
+	for prefix, d := range cfg.RouteTimeouts {
+		if d <= 0 {
+			problems = append(problems, fmt.Sprintf("ROUTE_TIMEOUTS for %s must be positive", prefix))
+		}
+	}
+	if secret := cfg.ClerkWebhookSecret; secret != "" {
+		if _, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_")); err != nil || !strings.HasPrefix(secret, "whsec_") {
+			problems = append(problems, "CLERK_WEBHOOK_SECRET must be the whsec_ secret of the clerk webhook endpoint")
+		}
+	}
+	if cfg.DeletionTimeout <= 0 {
+		problems = append(problems, "DELETION_TIMEOUT must be positive")
+	}
+	if cfg.SnapshotJobTimeout <= 0 {
+		problems = append(problems, "SNAPSHOT_JOB_TIMEOUT must be positive")
+	}
+	if cfg.Exports.URLTTL <= 0 || cfg.Exports.Retention <= 0 || cfg.Exports.Timeout <= 0 {
+		problems = append(problems, "EXPORT_URL_TTL, EXPORT_RETENTION and EXPORT_TIMEOUT must be positive")
+	}
+	if cfg.Mongo.MaxPoolSize != 0 && cfg.Mongo.MinPoolSize > cfg.Mongo.MaxPoolSize {
+		problems = append(problems, "MONGODB_MIN_POOL_SIZE must not exceed MONGODB_MAX_POOL_SIZE")
+	}
+
+	if len(missing) > 0 {
+		problems = append([]string{"missing required config: " + strings.Join(missing, ", ")}, problems...)
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return cfg, nil
+}
+
+// String lists every setting by environment variable, with secrets masked
+func (c Config) String() string {
+	return describe(reflect.ValueOf(c))
+}
+
+// GoString keeps secrets masked when printed with %#v
+func (c Config) GoString() string {
+	return c.String()
+}
+
+// the sections holding secrets mask them too when printed on their own
+
+func (m MongoConfig) String() string  { return describe(reflect.ValueOf(m)) }
+func (r RedisConfig) String() string  { return describe(reflect.ValueOf(r)) }
+func (p PlaidConfig) String() string  { return describe(reflect.ValueOf(p)) }
+func (t TwilioConfig) String() string { return describe(reflect.ValueOf(t)) }
+
+func describe(v reflect.Value) string {
+	var b strings.Builder
+	walk(v, func(field reflect.Value, tag reflect.StructTag) {
+		value := fmt.Sprint(field.Interface())
+		switch v := field.Interface().(type) {
+		case []string:
+			value = strings.Join(v, ",")
+		case map[string]time.Duration:
+			pairs := make([]string, 0, len(v))
+			for k, d := range v {
+				pairs = append(pairs, k+"="+d.String())
+			}
+			sort.Strings(pairs)
+			value = strings.Join(pairs, ",")
+		}
+		if tag.Get("secret") == "true" && value != "" {
+			value = "****"
+		}
+		fmt.Fprintf(&b, "%s=%s\n", tag.Get("env"), value)
+	})
+	return b.String()
+}
+
+// walk calls fn for every field of v tagged with an environment variable, descending into nested structs
+func walk(v reflect.Value, fn func(field reflect.Value, tag reflect.StructTag)) {
+	t := v.Type()
+	for idx := 0; idx < t.NumField(); idx++ {
+		field, sf := v.Field(idx), t.Field(idx)
+		if _, ok := sf.Tag.Lookup("env"); ok {
+			fn(field, sf.Tag)
+		} else if field.Kind() == reflect.Struct {
+			walk(field, fn)
+		}
+	}
+}
+
+func setField(field reflect.Value, raw string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case uint64:
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case []string:
+		var values []string
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	case map[string]time.Duration:
+		values := make(map[string]time.Duration)
+		for _, pair := range strings.Split(raw, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("expected key=duration, got %q", pair)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(v))
+			if err != nil {
+				return err
+			}
+			values[strings.TrimSpace(k)] = d
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported config type %s", field.Type())
+	}
+	return nil
+}
@@ -1,20 +1,20 @@
 package config
 
 import (
-	"log"
+	"errors"
 	"os"
 
 	"github.com/joho/godotenv"
 )
 
-// LoadENV will load the .env file if the GO_ENV environment variable is not set
+// LoadENV will load the .env file if the railway environment is not production. A missing .env file
+// is not an error, the environment may be set by the shell instead.
 func LoadENV() error {
 	goEnv := os.Getenv("RAILWAY_ENVIRONMENT")
-	log.Println("RAILWAY_ENVIRONMENT: ", goEnv)
 	// use local .env file if railway env is local, otherwise use the env vars set in the railway console
 	if goEnv != "production" {
 		err := godotenv.Load()
-		if err != nil {
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
 	}
@@ -0,0 +1,223 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection tracks which migrations have been applied
+const Collection = "migrations"
+
+// Migration is a versioned change to the database. Up and Down must be safe to run again after a
+// partial failure, as the migration is only recorded once Up returns.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+// Record is the document stored in the migrations collection for each applied migration
+type Record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Status reports whether a registered migration has been applied
+type Status struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+// Runner applies and rolls back migrations in version order
+type Runner struct {
+	db         *mongo.Database
+	applied    *mongo.Collection
+	migrations []Migration
+	l          *logrus.Logger
+}
+
+func NewRunner(db *mongo.Database, migrations []Migration, l *logrus.Logger) (*Runner, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	for idx, m := range sorted {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("migration %q must have a positive version", m.Description)
+		}
+		if idx > 0 && sorted[idx-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", m.Version)
+		}
+		if m.Up == nil || m.Down == nil {
+			return nil, fmt.Errorf("migration %d must define both up and down", m.Version)
+		}
+	}
+	return &Runner{db: db, applied: db.Collection(Collection), migrations: sorted, l: l}, nil
+}
+
+func (r *Runner) records(ctx context.Context) (map[int]Record, error) {
+	cursor, err := r.applied.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]Record, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// Status lists every registered migration in version order
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	applied, err := r.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(r.migrations))
+	for idx, m := range r.migrations {
+		record, ok := applied[m.Version]
+		statuses[idx] = Status{Version: m.Version, Description: m.Description, Applied: ok, AppliedAt: record.AppliedAt}
+	}
+	return statuses, nil
+}
+
+// Up applies every pending migration in version order and returns how many were applied
+func (r *Runner) Up(ctx context.Context) (int, error) {
+	applied, err := r.records(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range r.migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		r.l.Infof("[Migrations] applying %d: %s", m.Version, m.Description)
+		if err = m.Up(ctx, r.db); err != nil {
+			return count, fmt.Errorf("applying migration %d: %w", m.Version, err)
+		}
+		record := Record{Version: m.Version, Description: m.Description, AppliedAt: time.Now()}
+		// another instance may have applied it concurrently, which is fine since migrations are idempotent
+		if _, err = r.applied.InsertOne(ctx, record); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return count, fmt.Errorf("recording migration %d: %w", m.Version, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Down rolls back the latest steps applied migrations and returns how many were rolled back
+func (r *Runner) Down(ctx context.Context, steps int) (int, error) {
+	if steps <= 0 {
+		return 0, errors.New("steps must be positive")
+	}
+	applied, err := r.records(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for idx := len(r.migrations) - 1; idx >= 0 && count < steps; idx-- {
+		m := r.migrations[idx]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		r.l.Infof("[Migrations] rolling back %d: %s", m.Version, m.Description)
+		if err = m.Down(ctx, r.db); err != nil {
+			return count, fmt.Errorf("rolling back migration %d: %w", m.Version, err)
+		}
+		if _, err = r.applied.DeleteOne(ctx, bson.M{"_id": m.Version}); err != nil {
+			return count, fmt.Errorf("unrecording migration %d: %w", m.Version, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// createIndexes creates indexes on a collection, keeping the driver's default names so that
+// indexes created before migrations existed are recognised
+func createIndexes(ctx context.Context, coll *mongo.Collection, indexes []mongo.IndexModel) error {
+	_, err := coll.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+// dropIndexes drops the indexes created by createIndexes, ignoring those that no longer exist
+func dropIndexes(ctx context.Context, coll *mongo.Collection, indexes []mongo.IndexModel) error {
+	for _, index := range indexes {
+		if _, err := coll.Indexes().DropOne(ctx, indexName(index.Keys.(bson.D))); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexName mirrors the name the server gives an index created without one, e.g. user_id_1_date_-1
+func indexName(keys bson.D) string {
+	name := ""
+	for idx, key := range keys {
+		if idx > 0 {
+			name += "_"
+		}
+		name += fmt.Sprintf("%s_%v", key.Key, key.Value)
+	}
+	return name
+}
+
+// setValidator applies a JSON schema validator to a collection, creating the collection if needed.
+// Validation is moderate so existing documents that don't match can still be updated.
+func setValidator(ctx context.Context, db *mongo.Database, name string, schema bson.M) error {
+	cmd := bson.D{
+		{Key: "collMod", Value: name},
+		{Key: "validator", Value: bson.M{"$jsonSchema": schema}},
+		{Key: "validationLevel", Value: "moderate"},
+		{Key: "validationAction", Value: "error"},
+	}
+	err := db.RunCommand(ctx, cmd).Err()
+	if isNotFound(err) {
+		opts := options.CreateCollection().
+			SetValidator(bson.M{"$jsonSchema": schema}).
+			SetValidationLevel("moderate").
+			SetValidationAction("error")
+		return db.CreateCollection(ctx, name, opts)
+	}
+	return err
+}
+
+// clearValidator removes the validator of a collection
+func clearValidator(ctx context.Context, db *mongo.Database, name string) error {
+	cmd := bson.D{
+		{Key: "collMod", Value: name},
+		{Key: "validator", Value: bson.M{}},
+		{Key: "validationLevel", Value: "off"},
+	}
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// isNotFound reports whether err is the server's NamespaceNotFound or IndexNotFound error
+func isNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == 26 || cmdErr.Code == 27
+	}
+	return false
+}
@@ -0,0 +1,259 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/jalexanderII/zero-railway/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// All returns the migrations of the app, in version order, for the given collection names
+func All(names repository.Collections) []Migration {
+	return []Migration{
+		indexMigration(1, "create indexes used by repository queries", queryIndexes(names)),
+		indexMigration(2, "create unique and lookup indexes for users, tokens, accounts, payment tasks and budgets", lookupIndexes(names)),
+		validatorMigration(3, "add JSON schema validators", schemas(names)),
+		indexMigration(4, "create indexes for the audit trail", auditIndexes(names)),
+		indexMigration(5, "create indexes for listing the audit trail by user and action", auditSubjectIndexes(names)),
+		indexMigration(6, "expire data exports and index them by user", exportIndexes(names)),
+		indexMigration(7, "keep a single deletion per user and find unfinished deletions", deletionIndexes(names)),
+		indexMigration(8, "alert each utilization threshold once per account per day", utilizationAlertIndexes(names)),
+		indexMigration(9, "find export archives by user and expiry", exportArchiveIndexes(names)),
+	}
+}
+
+// queryIndexes are the indexes previously created by the repositories on startup
+func queryIndexes(names repository.Collections) map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		names.Transactions: {
+			{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "plaid_transaction_id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "date", Value: -1}, {Key: "plaid_transaction_id", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "amount", Value: -1}, {Key: "plaid_transaction_id", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "plaid_account_id", Value: 1}, {Key: "date", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "primary_category", Value: 1}, {Key: "detailed_category", Value: 1}, {Key: "date", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "merchant_name", Value: 1}, {Key: "date", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "in_plan", Value: 1}}},
+			{Keys: bson.D{{Key: "payment_plan_id", Value: 1}}},
+		},
+		// alerts each budget threshold only once per period
+		names.BudgetAlerts: {
+			{
+				Keys:    bson.D{{Key: "budget_id", Value: 1}, {Key: "period", Value: 1}, {Key: "threshold", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		// keeps a single snapshot per account per day
+		names.BalanceSnapshots: {
+			{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "account_id", Value: 1}, {Key: "date", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+		// sends each reminder only once per due date
+		names.Reminders: {
+			{
+				Keys:    bson.D{{Key: "account_id", Value: 1}, {Key: "due_date", Value: 1}, {Key: "kind", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+	}
+}
+
+// lookupIndexes back the lookups by clerk id, email, token owner and user id
+func lookupIndexes(names repository.Collections) map[string][]mongo.IndexModel {
+	// users created before signing in through clerk have no clerk id yet
+	nonEmpty := func(field string) bson.M {
+		return bson.M{field: bson.M{"$type": "string", "$gt": ""}}
+	}
+	return map[string][]mongo.IndexModel{
+		names.Users: {
+			{
+				Keys:    bson.D{{Key: "clerk_id", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(nonEmpty("clerk_id")),
+			},
+			{
+				Keys:    bson.D{{Key: "email", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(nonEmpty("email")),
+			},
+		},
+		names.Tokens: {
+			{Keys: bson.D{{Key: "user._id", Value: 1}}},
+			{Keys: bson.D{{Key: "value", Value: 1}}},
+		},
+		names.Accounts: {
+			{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "plaid_account_id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{Keys: bson.D{{Key: "type", Value: 1}, {Key: "next_payment_due_date", Value: 1}}},
+		},
+		names.PaymentTasks: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		},
+		names.Budgets: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		},
+	}
+}
+
+// auditIndexes back listing the audit trail by time, actor and target
+func auditIndexes(names repository.Collections) map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		names.AuditEvents: {
+			{Keys: bson.D{{Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "target", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+	}
+}
+
+// auditSubjectIndexes back listing the audit trail of a user, and of an action, for compliance exports
+func auditSubjectIndexes(names repository.Collections) map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		names.AuditEvents: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "action", Value: 1}, {Key: "_id", Value: -1}}},
+		},
+	}
+}
+
+// exportIndexes remove data exports once they expire
+func exportIndexes(names repository.Collections) map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		names.Exports: {
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		},
+	}
+}
+
+func deletionIndexes(names repository.Collections) map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		names.Deletions: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "clerk_id", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}}},
+		},
+	}
+}
+
+func utilizationAlertIndexes(names repository.Collections) map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		names.UtilizationAlerts: {
+			{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "account_id", Value: 1}, {Key: "threshold", Value: 1}, {Key: "date", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+	}
+}
+
+// exportArchiveIndexes index the files of the GridFS bucket of export archives, the bucket creates the
+// indexes it needs itself on the first upload
+func exportArchiveIndexes(names repository.Collections) map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		names.ExportArchives + ".files": {
+			{Keys: bson.D{{Key: "metadata.user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "metadata.expires_at", Value: 1}}},
+		},
+	}
+}
+
+func schemas(names repository.Collections) map[string]bson.M {
+	return map[string]bson.M{
+		names.Users: {
+			"bsonType": "object",
+			"required": bson.A{"email"},
+			"properties": bson.M{
+				"email":                    bson.M{"bsonType": "string"},
+				"username":                 bson.M{"bsonType": "string"},
+				"clerk_id":                 bson.M{"bsonType": "string"},
+				"phone_number":             bson.M{"bsonType": "string"},
+				"notification_preferences": bson.M{"bsonType": bson.A{"object", "null"}},
+			},
+		},
+		names.Accounts: {
+			"bsonType": "object",
+			"required": bson.A{"user_id", "plaid_account_id"},
+			"properties": bson.M{
+				"user_id":          bson.M{"bsonType": "objectId"},
+				"plaid_account_id": bson.M{"bsonType": "string"},
+				"type":             bson.M{"bsonType": "string"},
+				"current_balance":  bson.M{"bsonType": "number"},
+				"credit_limit":     bson.M{"bsonType": "number"},
+			},
+		},
+		names.Transactions: {
+			"bsonType": "object",
+			"required": bson.A{"user_id", "plaid_transaction_id", "amount", "date"},
+			"properties": bson.M{
+				"user_id":              bson.M{"bsonType": "objectId"},
+				"plaid_transaction_id": bson.M{"bsonType": "string"},
+				"amount":               bson.M{"bsonType": "number"},
+				"date":                 bson.M{"bsonType": "long"},
+				"in_plan":              bson.M{"bsonType": "bool"},
+			},
+		},
+		names.Budgets: {
+			"bsonType": "object",
+			"required": bson.A{"user_id", "name", "limit"},
+			"properties": bson.M{
+				"user_id":    bson.M{"bsonType": "objectId"},
+				"name":       bson.M{"bsonType": "string"},
+				"limit":      bson.M{"bsonType": "number", "minimum": 0},
+				"thresholds": bson.M{"bsonType": "array", "items": bson.M{"bsonType": "number"}},
+			},
+		},
+	}
+}
+
+func indexMigration(version int, description string, indexes map[string][]mongo.IndexModel) Migration {
+	return Migration{
+		Version:     version,
+		Description: description,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for name, models := range indexes {
+				if err := createIndexes(ctx, db.Collection(name), models); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for name, models := range indexes {
+				if err := dropIndexes(ctx, db.Collection(name), models); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func validatorMigration(version int, description string, schemas map[string]bson.M) Migration {
+	return Migration{
+		Version:     version,
+		Description: description,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for name, schema := range schemas {
+				if err := setValidator(ctx, db, name, schema); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for name := range schemas {
+				if err := clearValidator(ctx, db, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -3,76 +3,150 @@ package database
 import (
 	"context"
 	"errors"
-	"os"
+	"fmt"
 	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
 const Performance = 100
 
-var (
-	// Used to create a singleton object of MongoDB client.
-	// Initialized and exposed through  GetMongoClient()
-	mongoClient *mongo.Client
-	// Used during creation of singleton client object in GetMongoClient()
-	clientInstanceError error
-	// Used to execute client creation procedure only once
-	mongoOnce sync.Once
-	dbName    string
-	ctx       context.Context
-)
+var ErrNotConnected = errors.New("database: store is not connected")
 
-func GetCollection(name string) *mongo.Collection {
-	return mongoClient.Database(dbName).Collection(name)
+// Config holds the settings used to connect a Store
+type Config struct {
+	URI      string
+	Database string
+	// MaxPoolSize and MinPoolSize bound the number of connections kept per server, 0 keeps the driver default
+	MaxPoolSize uint64
+	MinPoolSize uint64
+	// ConnectTimeout bounds the initial connect and ping
+	ConnectTimeout time.Duration
+	// ServerSelectionTimeout bounds how long an operation waits for a suitable server
+	ServerSelectionTimeout time.Duration
+	// PingTimeout bounds the readiness ping
+	PingTimeout time.Duration
 }
 
-func StartMongoDB() error {
-	uri := os.Getenv("MONGODB_URI")
-	if uri == "" {
-		return errors.New("you must set your 'MONGODB_URI' environmental variable. " +
-			"See\n\t https://www.mongodb.com/docs/drivers/go/current/usage-examples/#environment-variable")
+func (cfg Config) validate() error {
+	if cfg.URI == "" {
+		return errors.New("database: mongo uri is required")
+	}
+	if cfg.Database == "" {
+		return errors.New("database: database name is required")
+	}
+	if cfg.MaxPoolSize != 0 && cfg.MinPoolSize > cfg.MaxPoolSize {
+		return errors.New("database: min pool size must not exceed the max pool size")
 	}
+	return nil
+}
 
-	database := os.Getenv("DATABASE")
-	if database == "" {
-		return errors.New("you must set your 'DATABASE' environmental variable")
+// Store owns a single MongoDB client and the database it serves. Each Store is independent, so several
+// can be connected at once, e.g. to isolated test databases.
+type Store struct {
+	cfg Config
+
+	mu     sync.RWMutex
+	client *mongo.Client
+}
+
+func NewStore(cfg Config) (*Store, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
 	}
-	dbName = database
+	return &Store{cfg: cfg}, nil
+}
 
-	// Perform connection creation operation only once.
-	mongoOnce.Do(func() {
-		// Set client options
-		clientOptions := options.Client().ApplyURI(uri)
+// Connect opens the client and pings the primary, the client is discarded if the ping fails
+func (s *Store) Connect(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return nil
+	}
+
+	// every command creates a client span, child of the span in the operation's context
+	clientOptions := options.Client().ApplyURI(s.cfg.URI).SetMonitor(otelmongo.NewMonitor())
+	if s.cfg.MaxPoolSize != 0 {
+		clientOptions.SetMaxPoolSize(s.cfg.MaxPoolSize)
+	}
+	if s.cfg.MinPoolSize != 0 {
+		clientOptions.SetMinPoolSize(s.cfg.MinPoolSize)
+	}
+	if s.cfg.ConnectTimeout != 0 {
+		clientOptions.SetConnectTimeout(s.cfg.ConnectTimeout)
+	}
+	if s.cfg.ServerSelectionTimeout != 0 {
+		clientOptions.SetServerSelectionTimeout(s.cfg.ServerSelectionTimeout)
+	}
+
+	if s.cfg.ConnectTimeout != 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = NewDBContext(10 * time.Second)
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.ConnectTimeout)
 		defer cancel()
-		// Connect to MongoDB
-		client, err := mongo.Connect(ctx, clientOptions)
-		if err != nil {
-			clientInstanceError = err
-		}
-		// Check the connection
-		err = client.Ping(ctx, nil)
-		if err != nil {
-			clientInstanceError = err
-		}
-		mongoClient = client
-	})
-
-	if clientInstanceError != nil {
-		panic(clientInstanceError)
 	}
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return fmt.Errorf("connecting to mongo: %w", err)
+	}
+	if err = client.Ping(ctx, readpref.Primary()); err != nil {
+		_ = client.Disconnect(context.Background())
+		return fmt.Errorf("pinging mongo: %w", err)
+	}
+	s.client = client
 	return nil
 }
 
-func CloseMongoDB() {
-	err := mongoClient.Disconnect(ctx)
-	if err != nil {
-		panic(err)
+// Ping checks the primary is reachable within the configured ping timeout
+func (s *Store) Ping(ctx context.Context) error {
+	s.mu.RLock()
+	client := s.client
+	s.mu.RUnlock()
+	if client == nil {
+		return ErrNotConnected
 	}
+
+	if s.cfg.PingTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.PingTimeout)
+		defer cancel()
+	}
+	return client.Ping(ctx, readpref.Primary())
+}
+
+// Ready reports whether the store can serve requests, for use by readiness checks
+func (s *Store) Ready(ctx context.Context) error {
+	return s.Ping(ctx)
+}
+
+// Close disconnects the client, closing a store that is not connected is a no-op
+func (s *Store) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Disconnect(ctx)
+	s.client = nil
+	return err
+}
+
+// Database returns the configured database, the store must be connected
+func (s *Store) Database() *mongo.Database {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.client == nil {
+		panic(ErrNotConnected)
+	}
+	return s.client.Database(s.cfg.Database)
+}
+
+func (s *Store) Collection(name string) *mongo.Collection {
+	return s.Database().Collection(name)
 }
 
 // NewDBContext returns a new Context according to app performance
@@ -0,0 +1,47 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jalexanderII/zero-railway/models"
+)
+
+// TestResponsesAreNotShared checks a response served to one user is never served to another one
+// requesting the same path
+func TestResponsesAreNotShared(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		first        string
+		firstStatus  int
+		second       string
+		secondStatus int
+	}{
+		{name: "user by id", path: "/api/user/{alice}", first: "alice", firstStatus: http.StatusOK, second: "bob", secondStatus: http.StatusNotFound},
+		{name: "accounts by user id", path: "/api/core/accounts/user_id/{alice}", first: "alice", firstStatus: http.StatusOK, second: "bob", secondStatus: http.StatusNotFound},
+		{name: "account by id", path: "/api/core/accounts/acc_id/alice-card/{alice}", first: "alice", firstStatus: http.StatusOK, second: "bob", secondStatus: http.StatusNotFound},
+		{name: "admin route", path: "/admin/users/{alice}", first: "admin", firstStatus: http.StatusOK, second: "alice", secondStatus: http.StatusForbidden},
+		{name: "admin route anonymously", path: "/admin/items", first: "admin", firstStatus: http.StatusOK, secondStatus: http.StatusUnauthorized},
+		{name: "signed in user", path: "/api/core/users", first: "alice", firstStatus: http.StatusOK, secondStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, ids := newSeededServer(t)
+			path := ids.Replace(tt.path)
+			ts.expect(tt.firstStatus, http.MethodGet, path, tt.first, nil)
+			ts.expect(tt.secondStatus, http.MethodGet, path, tt.second, nil)
+		})
+	}
+}
+
+func TestSignedInUserIsTheCallers(t *testing.T) {
+	ts, _ := newSeededServer(t)
+	for _, clerkId := range []string{"alice", "bob"} {
+		var user models.User
+		ts.expect(http.StatusOK, http.MethodGet, "/api/core/users", clerkId, nil).decode(t, &user)
+		if user.ClerkId != clerkId {
+			t.Fatalf("got user %q, want %q", user.ClerkId, clerkId)
+		}
+	}
+}
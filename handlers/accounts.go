@@ -1,8 +1,11 @@
 package handlers
 
 import (
-	"github.com/go-redis/cache/v8"
+	"context"
 	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
 	"github.com/jalexanderII/zero-railway/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -13,15 +16,15 @@ import (
 // @Produce json
 // @Success 200 {object} []models.Account
 // @Router /accounts [get]
-func GetUsersAccountsByEmail(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func GetUsersAccountsByEmail(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
 		}
-		accounts, err := GetUserAccounts(h, user.GetID(), rcache)
+		accounts, err := GetUserAccounts(c.UserContext(), h, user.GetID(), rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting users accounts", err.Error())
+			return apierror.Wrap(err, "failed getting users accounts")
 		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "user accounts", accounts)
 	}
@@ -34,15 +37,15 @@ func GetUsersAccountsByEmail(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx)
 // @Produce json
 // @Success 200 {object} []models.Account
 // @Router /accounts/:user_id [get]
-func GetUsersAccountsByUserID(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func GetUsersAccountsByUserID(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		userId, err := primitive.ObjectIDFromHex(c.Params("user_id"))
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusBadRequest, "error", "invalid user id", err.Error())
+			return apierror.Validation("invalid user id", err.Error())
 		}
-		accounts, err := GetUserAccounts(h, &userId, rcache)
+		accounts, err := GetUserAccounts(c.UserContext(), h, &userId, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting users accounts", err.Error())
+			return apierror.Wrap(err, "failed getting users accounts")
 		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "user accounts", accounts)
 	}
@@ -55,19 +58,19 @@ func GetUsersAccountsByUserID(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx
 // @Produce json
 // @Success 200 {object} models.Account
 // @Router /accounts/acc_id/:acc_id/:user_id [get]
-func GetAccount(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func GetAccount(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		userId, err := primitive.ObjectIDFromHex(c.Params("user_id"))
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusBadRequest, "error", "invalid user id", err.Error())
+			return apierror.Validation("invalid user id", err.Error())
 		}
 
 		accId := c.Params("acc_id")
-		Accounts, err := FetchAccountDetails(userId, h.P, rcache)
+		Accounts, err := FetchAccountDetails(c.UserContext(), h, userId, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting users account", err.Error())
+			return apierror.Wrap(err, "failed getting users account")
 		}
-		h.L.Info("accounts fetched", Accounts)
+		logging.Ctx(c).WithField("accounts", len(Accounts)).Debug("accounts fetched")
 
 		for _, acc := range Accounts {
 			if acc.ID == accId {
@@ -75,20 +78,20 @@ func GetAccount(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
 			}
 		}
 
-		return FiberJsonResponse(c, fiber.StatusNotFound, "error", "account not found", err.Error())
+		return apierror.NotFound("account not found")
 	}
 }
 
-func GetUserAccounts(h *Handler, userId *primitive.ObjectID, rcache *cache.Cache) ([]*models.Account, error) {
-	Accounts, err := FetchAccountDetails(*userId, h.P, rcache)
+func GetUserAccounts(ctx context.Context, h *Handler, userId *primitive.ObjectID, rcache *caching.Store) ([]*models.Account, error) {
+	Accounts, err := FetchAccountDetails(ctx, h, *userId, rcache)
 	if err != nil {
 		return nil, err
 	}
 	return Accounts, nil
 }
 
-func GetDebitAccountBalance(h *Handler, userId *primitive.ObjectID, rcache *cache.Cache) *models.GetDebitAccountBalanceResponse {
-	Accounts, err := FetchAccountDetails(*userId, h.P, rcache)
+func GetDebitAccountBalance(ctx context.Context, h *Handler, userId *primitive.ObjectID, rcache *caching.Store) *models.GetDebitAccountBalanceResponse {
+	Accounts, err := FetchAccountDetails(ctx, h, *userId, rcache)
 	if err != nil {
 		return nil
 	}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// itemHealthConcurrency bounds the plaid calls made at once for the Item health overview
+const itemHealthConcurrency = 5
+
+// AuditAdminActions records every request to the admin API in the audit trail, named after its route
+func AuditAdminActions(h *Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = apierror.Status(err)
+		}
+		// once the request is handled the route is the one of the handler rather than this middleware
+		route := c.Route()
+		action := route.Name
+		if action == "" {
+			action = fmt.Sprintf("admin %s %s", route.Method, route.Path)
+		}
+		event := models.AuditEvent{Action: action, Status: status}
+		if id := c.Params("id"); id != "" && strings.HasPrefix(route.Path, "/admin/deletions/") {
+			event.Target = "deletion:" + id
+		} else if id != "" {
+			event.Target = "user:" + id
+			event.UserId, _ = primitive.ObjectIDFromHex(id)
+		}
+		if query := string(c.Request().URI().QueryString()); query != "" {
+			event.Metadata = map[string]any{"query": query}
+		}
+		h.RecordAudit(c, event)
+		return err
+	}
+}
+
+// adminUser returns the user named by the id path parameter
+func adminUser(c *fiber.Ctx, h *Handler) (*models.User, error) {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, apierror.Validation("invalid user id", err.Error())
+	}
+	user, err := h.Users.GetByID(c.UserContext(), id)
+	if err != nil {
+		return nil, apierror.Wrap(err, "user not found")
+	}
+	return user, nil
+}
+
+// @Summary Find a user.
+// @Description find a user by email or clerk id.
+// @Tags admin
+// @Param email query string false "User email"
+// @Param clerk_id query string false "Clerk id"
+// @Produce json
+// @Success 200 {object} models.User
+// @Router /admin/users [get]
+func AdminFindUser(h *Handler) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		var user *models.User
+		var err error
+		switch {
+		case c.Query("email") != "":
+			user, err = h.Users.GetByEmail(c.UserContext(), c.Query("email"))
+		case c.Query("clerk_id") != "":
+			user, err = h.Users.GetByClerkId(c.UserContext(), c.Query("clerk_id"))
+		default:
+			return apierror.Validation("invalid user lookup", "one of email, clerk_id is required")
+		}
+		if err != nil {
+			return apierror.Wrap(err, "user not found")
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "user", user)
+	}
+}
+
+// @Summary Get a user.
+// @Description fetch any user by id.
+// @Tags admin
+// @Param id path string true "User ID"
+// @Produce json
+// @Success 200 {object} models.User
+// @Router /admin/users/:id [get]
+func AdminGetUser(h *Handler) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := adminUser(c, h)
+		if err != nil {
+			return err
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "user", user)
+	}
+}
+
+// @Summary Clear a user's cache.
+// @Description drop the cached user and account details of a user, they are fetched again on next use.
+// @Tags admin
+// @Param id path string true "User ID"
+// @Produce json
+// @Router /admin/users/:id/cache [delete]
+func AdminClearCache(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := adminUser(c, h)
+		if err != nil {
+			return err
+		}
+		if err = rcache.Users.Invalidate(c.UserContext(), user.Email, user.ClerkId); err != nil {
+			return apierror.Wrap(err, "failed clearing cache")
+		}
+		if err = rcache.AccountDetails.Invalidate(c.UserContext(), user.ID); err != nil {
+			return apierror.Wrap(err, "failed clearing cache")
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "cache cleared", nil)
+	}
+}
+
+// @Summary Resync a user's accounts.
+// @Description fetch the accounts and transactions of every Item of a user from plaid, bypassing the cache.
+// @Tags admin
+// @Param id path string true "User ID"
+// @Produce json
+// @Success 200 {object} models.AccountDetailsResponse
+// @Router /admin/users/:id/resync [post]
+func AdminResync(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := adminUser(c, h)
+		if err != nil {
+			return err
+		}
+		details, err := FetchDataAndCache(c.UserContext(), h, user.ID, rcache, true)
+		if err != nil {
+			return apierror.Wrap(err, "failed resyncing accounts")
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "accounts resynced", details)
+	}
+}
+
+// @Summary Get the health of linked Items.
+// @Description report the state plaid holds for every linked Item, or the Items of a single user.
+// @Tags admin
+// @Param user_id query string false "Only report the Items of this user"
+// @Param unhealthy query bool false "Only report Items in an error state"
+// @Produce json
+// @Success 200 {object} []models.ItemHealth
+// @Router /admin/items [get]
+func AdminItemHealth(h *Handler) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		var tokens []models.Token
+		var err error
+		if userId := c.Query("user_id"); userId != "" {
+			var id primitive.ObjectID
+			if id, err = primitive.ObjectIDFromHex(userId); err != nil {
+				return apierror.Validation("invalid user id", err.Error())
+			}
+			tokens, err = h.Tokens.ListByUser(c.UserContext(), id)
+		} else {
+			tokens, err = h.Tokens.List(c.UserContext())
+		}
+		if err != nil {
+			return apierror.Wrap(err, "failed listing linked items")
+		}
+		unhealthyOnly, err := strconv.ParseBool(c.Query("unhealthy", "false"))
+		if err != nil {
+			return apierror.Validation("invalid unhealthy filter", err.Error())
+		}
+
+		healths := itemHealths(c.UserContext(), h, tokens)
+		response := make([]*models.ItemHealth, 0, len(healths))
+		for _, health := range healths {
+			if !unhealthyOnly || !health.Healthy {
+				response = append(response, health)
+			}
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "item health", response)
+	}
+}
+
+// itemHealths asks plaid for the state of every Item, an Item that could not be checked is reported
+// unhealthy with the reason
+func itemHealths(ctx context.Context, h *Handler, tokens []models.Token) []*models.ItemHealth {
+	healths := make([]*models.ItemHealth, len(tokens))
+	sem := make(chan struct{}, itemHealthConcurrency)
+	var wg sync.WaitGroup
+	for idx := range tokens {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			token := &tokens[idx]
+			health, err := h.P.GetItemHealth(ctx, token)
+			if err != nil {
+				logging.FromContext(ctx).WithError(err).WithField("item_id", token.ItemId).Error("[Admin] Error checking item health")
+				health = &models.ItemHealth{TokenId: token.ID, ItemId: token.ItemId, Institution: token.Institution, InstitutionID: token.InstitutionID, Purpose: token.Purpose}
+				if token.User != nil {
+					health.UserId = token.User.ID
+				}
+				health.ErrorMessage = apierror.Wrap(err, "the item could not be checked").Message
+			}
+			healths[idx] = health
+		}(idx)
+	}
+	wg.Wait()
+	return healths
+}
+
+type CleanupResponse struct {
+	DryRun bool `json:"dry_run"`
+	// UserIds are the users whose deletion started, or that would be deleted on a dry run
+	UserIds []string `json:"user_ids"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// @Summary Delete users who never set up a phone number.
+// @Description delete the accounts of users left with an undefined phone number by the sign up flow, reporting them only on a dry run.
+// @Tags admin
+// @Param dry_run query bool false "Only report the users to delete, defaults to true"
+// @Produce json
+// @Success 200 {object} CleanupResponse
+// @Router /admin/jobs/cleanup_users [post]
+func AdminCleanupUsers(d *AccountDeleter) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		dryRun, err := strconv.ParseBool(c.Query("dry_run", "true"))
+		if err != nil {
+			return apierror.Validation("invalid dry_run flag", err.Error())
+		}
+
+		results, err := d.H.Users.List(c.UserContext())
+		if err != nil {
+			logging.Ctx(c).WithError(err).Error("[DB] Error getting all users")
+			return apierror.Wrap(err, "failed listing users")
+		}
+
+		response := CleanupResponse{DryRun: dryRun, UserIds: make([]string, 0)}
+		for idx, user := range results {
+			if user.PhoneNumber != "+1undefined" {
+				continue
+			}
+			if !dryRun {
+				if _, err = d.start(c, &results[idx], "admin"); err != nil {
+					logging.Ctx(c).WithError(err).WithField("user_id", user.ID.Hex()).Error("[DB] Error starting account deletion")
+					response.Failed = append(response.Failed, user.ID.Hex())
+					continue
+				}
+			}
+			response.UserIds = append(response.UserIds, user.ID.Hex())
+		}
+
+		msg := "user deletions started"
+		if dryRun {
+			msg = "users that would be deleted"
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", msg, response)
+	}
+}
@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+)
+
+type CategorySpendResponse struct {
+	StartDate  string                 `json:"start_date"`
+	EndDate    string                 `json:"end_date"`
+	Total      float64                `json:"total"`
+	Categories []models.CategorySpend `json:"categories"`
+}
+
+type MerchantSpendResponse struct {
+	StartDate string                 `json:"start_date"`
+	EndDate   string                 `json:"end_date"`
+	Total     float64                `json:"total"`
+	Merchants []models.MerchantSpend `json:"merchants"`
+}
+
+type MonthlyCategorySpend struct {
+	Category     string   `json:"category"`
+	Total        float64  `json:"total"`
+	Delta        float64  `json:"delta"`
+	DeltaPercent *float64 `json:"delta_percent"`
+}
+
+type MonthSpend struct {
+	Month        string                 `json:"month"`
+	Total        float64                `json:"total"`
+	Delta        float64                `json:"delta"`
+	DeltaPercent *float64               `json:"delta_percent"`
+	Categories   []MonthlyCategorySpend `json:"categories"`
+}
+
+type RecurringCharge struct {
+	Merchant         string  `json:"merchant"`
+	Frequency        string  `json:"frequency"`
+	IntervalDays     float64 `json:"interval_days"`
+	AverageAmount    float64 `json:"average_amount"`
+	Occurrences      int     `json:"occurrences"`
+	LastDate         int64   `json:"last_date"`
+	NextExpectedDate int64   `json:"next_expected_date"`
+}
+
+// @Summary Get spend by category.
+// @Description aggregate the user's spend by personal finance category over a period.
+// @Tags analytics
+// @Param period query string false "Period: 7d, 30d, 90d, 365d, mtd or ytd (default 30d)"
+// @Param start_date query string false "Start date (YYYY-MM-DD), overrides period"
+// @Param end_date query string false "End date (YYYY-MM-DD), overrides period"
+// @Param level query string false "Category level: primary or detailed (default primary)"
+// @Produce json
+// @Success 200 {object} CategorySpendResponse
+// @Router /analytics/categories [get]
+func GetSpendByCategory(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		start, end, err := analyticsPeriod(c)
+		if err != nil {
+			return apierror.Validation("invalid analytics period", err.Error())
+		}
+
+		detailed := false
+		switch c.Query("level", "primary") {
+		case "primary":
+		case "detailed":
+			detailed = true
+		default:
+			return apierror.Validation("invalid category level", "level must be one of primary, detailed")
+		}
+
+		filter := repository.SpendFilter{UserId: *user.GetID(), Start: start, End: end}
+		items, err := h.Transactions.SpendByCategory(c.UserContext(), filter, detailed)
+		if err != nil {
+			return apierror.Wrap(err, "failed aggregating spend by category")
+		}
+
+		total := 0.0
+		for _, item := range items {
+			total += item.Total
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "spend by category",
+			CategorySpendResponse{StartDate: formatDate(start), EndDate: formatDate(end), Total: total, Categories: items})
+	}
+}
+
+// @Summary Get spend by merchant.
+// @Description aggregate the user's spend by merchant over a period, ordered by total spend or by number of charges.
+// @Tags analytics
+// @Param period query string false "Period: 7d, 30d, 90d, 365d, mtd or ytd (default 30d)"
+// @Param start_date query string false "Start date (YYYY-MM-DD), overrides period"
+// @Param end_date query string false "End date (YYYY-MM-DD), overrides period"
+// @Param by query string false "Order by spend or count (default spend)"
+// @Param limit query int false "Only return the top N merchants"
+// @Produce json
+// @Success 200 {object} MerchantSpendResponse
+// @Router /analytics/merchants [get]
+func GetSpendByMerchant(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		start, end, err := analyticsPeriod(c)
+		if err != nil {
+			return apierror.Validation("invalid analytics period", err.Error())
+		}
+
+		byCount := false
+		switch c.Query("by", "spend") {
+		case "spend":
+		case "count":
+			byCount = true
+		default:
+			return apierror.Validation("invalid merchant ordering", "by must be one of spend, count")
+		}
+
+		limit := 0
+		if q := c.Query("limit"); q != "" {
+			if limit, err = strconv.Atoi(q); err != nil || limit <= 0 {
+				return apierror.Validation("invalid limit", "limit must be a positive integer")
+			}
+		}
+
+		filter := repository.SpendFilter{UserId: *user.GetID(), Start: start, End: end}
+		items, err := h.Transactions.SpendByMerchant(c.UserContext(), filter, byCount, limit)
+		if err != nil {
+			return apierror.Wrap(err, "failed aggregating spend by merchant")
+		}
+
+		total := 0.0
+		for _, item := range items {
+			total += item.Total
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "spend by merchant",
+			MerchantSpendResponse{StartDate: formatDate(start), EndDate: formatDate(end), Total: total, Merchants: items})
+	}
+}
+
+// @Summary Get month over month spend.
+// @Description fetch the user's total and per category spend for each of the last months with the change from the previous month.
+// @Tags analytics
+// @Param months query int false "Number of months, including the current one (default 6, max 24)"
+// @Produce json
+// @Success 200 {object} []MonthSpend
+// @Router /analytics/trends [get]
+func GetMonthlySpendTrends(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		months, err := strconv.Atoi(c.Query("months", "6"))
+		if err != nil || months <= 0 || months > 24 {
+			return apierror.Validation("invalid months", "months must be between 1 and 24")
+		}
+
+		now := time.Now().UTC()
+		firstMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -months, 0)
+
+		filter := repository.SpendFilter{UserId: *user.GetID(), Start: firstMonth, End: now}
+		rows, err := h.Transactions.MonthlySpendByCategory(c.UserContext(), filter)
+		if err != nil {
+			return apierror.Wrap(err, "failed aggregating monthly spend")
+		}
+
+		byMonth := make(map[string]map[string]float64)
+		for _, row := range rows {
+			if _, ok := byMonth[row.Month]; !ok {
+				byMonth[row.Month] = make(map[string]float64)
+			}
+			byMonth[row.Month][row.Category] += row.Total
+		}
+
+		// the extra leading month is only fetched to compute the first delta
+		response := make([]MonthSpend, 0, months)
+		previous := byMonth[firstMonth.Format("2006-01")]
+		for i := 1; i <= months; i++ {
+			month := firstMonth.AddDate(0, i, 0).Format("2006-01")
+			current := byMonth[month]
+			response = append(response, monthOverMonth(month, current, previous))
+			previous = current
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "monthly spend trends", response)
+	}
+}
+
+// @Summary Get recurring charges.
+// @Description detect charges that repeat at a regular interval for a similar amount, like subscriptions and bills.
+// @Tags analytics
+// @Param days query int false "Days of history to scan (default 180, max 730)"
+// @Produce json
+// @Success 200 {object} []RecurringCharge
+// @Router /analytics/recurring [get]
+func GetRecurringCharges(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		days, err := strconv.Atoi(c.Query("days", "180"))
+		if err != nil || days <= 0 || days > 730 {
+			return apierror.Validation("invalid days", "days must be between 1 and 730")
+		}
+
+		end := time.Now().UTC()
+		start := end.AddDate(0, 0, -days)
+		filter := repository.SpendFilter{UserId: *user.GetID(), Start: start, End: end}
+		series, err := h.Transactions.MerchantCharges(c.UserContext(), filter, 3)
+		if err != nil {
+			return apierror.Wrap(err, "failed aggregating recurring charges")
+		}
+
+		response := make([]RecurringCharge, 0)
+		for _, s := range series {
+			if charge, ok := recurring(s); ok {
+				response = append(response, charge)
+			}
+		}
+		sort.Slice(response, func(i, j int) bool { return response[i].AverageAmount > response[j].AverageAmount })
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "recurring charges", response)
+	}
+}
+
+var recurringFrequencies = []struct {
+	Name      string
+	Days      float64
+	Tolerance float64
+}{
+	{"weekly", 7, 2},
+	{"biweekly", 14, 3},
+	{"monthly", 30.4, 5},
+	{"quarterly", 91.3, 10},
+	{"yearly", 365, 20},
+}
+
+// recurring reports whether the series repeats at a known frequency with amounts within 20% of
+// the median charge
+func recurring(s models.ChargeSeries) (RecurringCharge, bool) {
+	const day = float64(24 * time.Hour / time.Millisecond)
+	if len(s.Dates) < 3 || len(s.Dates) != len(s.Amounts) {
+		return RecurringCharge{}, false
+	}
+
+	intervals := make([]float64, 0, len(s.Dates)-1)
+	for i := 1; i < len(s.Dates); i++ {
+		// multiple charges on the same day are not a recurrence
+		if gap := float64(s.Dates[i]-s.Dates[i-1]) / day; gap >= 1 {
+			intervals = append(intervals, gap)
+		}
+	}
+	if len(intervals) < 2 {
+		return RecurringCharge{}, false
+	}
+	interval := median(intervals)
+
+	frequency := ""
+	for _, f := range recurringFrequencies {
+		if math.Abs(interval-f.Days) <= f.Tolerance {
+			frequency = f.Name
+			break
+		}
+	}
+	if frequency == "" {
+		return RecurringCharge{}, false
+	}
+
+	typical := median(s.Amounts)
+	total := 0.0
+	for _, amount := range s.Amounts {
+		if math.Abs(amount-typical) > 0.2*typical {
+			return RecurringCharge{}, false
+		}
+		total += amount
+	}
+
+	last := s.Dates[len(s.Dates)-1]
+	return RecurringCharge{
+		Merchant:         s.Merchant,
+		Frequency:        frequency,
+		IntervalDays:     math.Round(interval*10) / 10,
+		AverageAmount:    math.Round(total/float64(len(s.Amounts))*100) / 100,
+		Occurrences:      len(s.Amounts),
+		LastDate:         last,
+		NextExpectedDate: last + int64(interval*day),
+	}, true
+}
+
+func monthOverMonth(month string, current, previous map[string]float64) MonthSpend {
+	total, previousTotal := 0.0, 0.0
+	for _, v := range current {
+		total += v
+	}
+	for _, v := range previous {
+		previousTotal += v
+	}
+
+	categories := make([]MonthlyCategorySpend, 0, len(current))
+	for category, v := range current {
+		categories = append(categories, MonthlyCategorySpend{
+			Category:     category,
+			Total:        v,
+			Delta:        v - previous[category],
+			DeltaPercent: percentChange(previous[category], v),
+		})
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Total > categories[j].Total })
+
+	return MonthSpend{
+		Month:        month,
+		Total:        total,
+		Delta:        total - previousTotal,
+		DeltaPercent: percentChange(previousTotal, total),
+		Categories:   categories,
+	}
+}
+
+// percentChange returns nil when there is nothing to compare against
+func percentChange(from, to float64) *float64 {
+	if from == 0 {
+		return nil
+	}
+	pct := math.Round((to-from)/from*10000) / 100
+	return &pct
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// analyticsPeriod resolves the start and end of the requested period, either from explicit
+// start_date and end_date or from a named period ending now
+func analyticsPeriod(c *fiber.Ctx) (time.Time, time.Time, error) {
+	const dateLayout = "2006-01-02"
+	now := time.Now().UTC()
+
+	if c.Query("start_date") != "" || c.Query("end_date") != "" {
+		start, err := time.Parse(dateLayout, c.Query("start_date"))
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("start_date must be formatted as YYYY-MM-DD")
+		}
+		end := now
+		if c.Query("end_date") != "" {
+			if end, err = time.Parse(dateLayout, c.Query("end_date")); err != nil {
+				return time.Time{}, time.Time{}, errors.New("end_date must be formatted as YYYY-MM-DD")
+			}
+		}
+		if end.Before(start) {
+			return time.Time{}, time.Time{}, errors.New("end_date must not be before start_date")
+		}
+		return start, end, nil
+	}
+
+	period := c.Query("period", "30d")
+	switch {
+	case period == "mtd":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), now, nil
+	case period == "ytd":
+		return time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC), now, nil
+	case strings.HasSuffix(period, "d"):
+		days, err := strconv.Atoi(strings.TrimSuffix(period, "d"))
+		if err == nil && days > 0 && days <= 730 {
+			return now.AddDate(0, 0, -days), now, nil
+		}
+	}
+	return time.Time{}, time.Time{}, errors.New("period must be one of mtd, ytd or a number of days like 30d")
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,33 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jalexanderII/zero-railway/handlers"
+)
+
+// TestSpendFollowsThePeriod checks requests to the same path over different periods are answered
+// for their own period
+func TestSpendFollowsThePeriod(t *testing.T) {
+	ts, _ := newSeededServer(t)
+	ts.expect(http.StatusOK, http.MethodGet, "/api/plaid/accounts", "alice", nil)
+
+	tests := []struct {
+		path  string
+		total float64
+	}{
+		{path: "/api/core/analytics/categories?period=7d", total: 42.5},
+		{path: "/api/core/analytics/categories?period=90d", total: 162.5},
+		{path: "/api/core/analytics/categories?period=7d", total: 42.5},
+		{path: "/api/core/analytics/merchants?period=7d", total: 42.5},
+		{path: "/api/core/analytics/merchants?period=90d", total: 162.5},
+	}
+	for _, tt := range tests {
+		var res handlers.CategorySpendResponse
+		ts.expect(http.StatusOK, http.MethodGet, tt.path, "alice", nil).decode(t, &res)
+		if res.Total != tt.total {
+			t.Fatalf("%s: got total %v, want %v", tt.path, res.Total, tt.total)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/auth"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// redacted replaces the values of sensitive fields in audit events
+const redacted = "[REDACTED]"
+
+// sensitiveAuditFields are the json fields whose values are never stored in the audit trail, only
+// whether they changed
+var sensitiveAuditFields = map[string]bool{
+	"email":        true,
+	"phone_number": true,
+	"phoneNumber":  true,
+	"access_token": true,
+	"public_token": true,
+	"link_token":   true,
+}
+
+// RecordAudit appends event to the audit trail, completing it with the actor, request id and ip of the
+// request. The action has already happened, so failing to record it is logged rather than returned.
+func (h *Handler) RecordAudit(c *fiber.Ctx, event models.AuditEvent) {
+	if principal := auth.Principal(c); principal != nil {
+		event.ActorId = principal.ID
+	}
+	event.RequestId, _ = c.Locals(logging.RequestIDKey).(string)
+	event.IP = c.IP()
+
+	if err := h.Audit.Append(c.UserContext(), &event); err != nil {
+		logging.Ctx(c).WithError(err).WithField("action", event.Action).Error("[Audit] Error recording audit event")
+	}
+}
+
+// auditChanges returns the json fields that differ between before and after, either of which may be
+// nil, with the values of sensitive fields redacted
+func auditChanges(before, after any) (map[string]any, map[string]any) {
+	b, a := auditFields(before), auditFields(after)
+	changedBefore, changedAfter := make(map[string]any), make(map[string]any)
+	for key, value := range b {
+		if other, ok := a[key]; !ok || !reflect.DeepEqual(value, other) {
+			changedBefore[key] = redact(key, value)
+		}
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || !reflect.DeepEqual(value, other) {
+			changedAfter[key] = redact(key, value)
+		}
+	}
+	if len(changedBefore) == 0 {
+		changedBefore = nil
+	}
+	if len(changedAfter) == 0 {
+		changedAfter = nil
+	}
+	return changedBefore, changedAfter
+}
+
+// auditFields flattens v to its top level json fields
+func auditFields(v any) map[string]any {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil()) {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]any
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+func redact(key string, value any) any {
+	if sensitiveAuditFields[key] && value != nil && value != "" {
+		return redacted
+	}
+	return value
+}
+
+type AuditQueryResponse struct {
+	Events     []models.AuditEvent `json:"events"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// auditQueryFilter builds the repository filter for the query parameters of the audit endpoints
+func auditQueryFilter(c *fiber.Ctx) (repository.AuditFilter, error) {
+	filter := repository.AuditFilter{Action: c.Query("action"), Target: c.Query("target")}
+	for param, id := range map[string]*primitive.ObjectID{"actor_id": &filter.ActorId, "user_id": &filter.UserId} {
+		if v := c.Query(param); v != "" {
+			parsed, err := primitive.ObjectIDFromHex(v)
+			if err != nil {
+				return filter, apierror.Validation("invalid audit query", param+" must be an id")
+			}
+			*id = parsed
+		}
+	}
+	for param, bound := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, apierror.Validation("invalid audit query", param+" must be an RFC 3339 time")
+			}
+			*bound = &t
+		}
+	}
+	return filter, nil
+}
+
+// @Summary Query the audit trail.
+// @Description list audit events newest first, filtered by actor, user, action, target and time.
+// @Tags admin
+// @Param actor_id query string false "User who took the action"
+// @Param user_id query string false "User whose data was acted on"
+// @Param action query string false "Action, e.g. user.phone_number.updated"
+// @Param target query string false "Target, e.g. payment_plan:<id>"
+// @Param since query string false "Earliest time (RFC 3339)"
+// @Param until query string false "Latest time, exclusive (RFC 3339)"
+// @Param limit query int false "Page size"
+// @Param cursor query string false "Cursor returned by the previous page"
+// @Produce json
+// @Success 200 {object} AuditQueryResponse
+// @Router /admin/audit [get]
+func QueryAuditEvents(h *Handler) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		filter, err := auditQueryFilter(c)
+		if err != nil {
+			return err
+		}
+		limit, err := strconv.Atoi(c.Query("limit", strconv.Itoa(defaultAuditPageSize)))
+		if err != nil || limit <= 0 || limit > maxAuditPageSize {
+			return apierror.Validation("invalid audit query", "limit must be between 1 and 1000")
+		}
+
+		query := repository.AuditQuery{Filter: filter, Limit: limit + 1}
+		if cursor := c.Query("cursor"); cursor != "" {
+			if query.Before, err = primitive.ObjectIDFromHex(cursor); err != nil {
+				return apierror.Validation("invalid audit query", "malformed cursor")
+			}
+		}
+
+		events, err := h.Audit.List(c.UserContext(), query)
+		if err != nil {
+			return apierror.Wrap(err, "failed querying audit events")
+		}
+		response := AuditQueryResponse{Events: events}
+		if len(events) > limit {
+			response.Events = events[:limit]
+			response.NextCursor = events[limit-1].ID.Hex()
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "audit events", response)
+	}
+}
+
+// @Summary Export the audit trail.
+// @Description download every matching audit event oldest first, one JSON object per line.
+// @Tags admin
+// @Param actor_id query string false "User who took the action"
+// @Param user_id query string false "User whose data was acted on"
+// @Param action query string false "Action"
+// @Param target query string false "Target"
+// @Param since query string false "Earliest time (RFC 3339)"
+// @Param until query string false "Latest time, exclusive (RFC 3339)"
+// @Produce application/x-ndjson
+// @Router /admin/audit/export [get]
+func ExportAuditEvents(h *Handler) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		filter, err := auditQueryFilter(c)
+		if err != nil {
+			return err
+		}
+
+		w := bufio.NewWriter(c)
+		encoder := json.NewEncoder(w)
+		if err = h.Audit.Each(c.UserContext(), filter, func(event *models.AuditEvent) error {
+			return encoder.Encode(event)
+		}); err != nil {
+			c.Response().ResetBody()
+			return apierror.Wrap(err, "failed exporting audit events")
+		}
+		if err = w.Flush(); err != nil {
+			return apierror.Wrap(err, "failed exporting audit events")
+		}
+
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit-`+time.Now().UTC().Format("20060102T150405Z")+`.ndjson"`)
+		c.Status(fiber.StatusOK)
+		return nil
+	}
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	client "github.com/jalexanderII/zero-railway/app/clients"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"github.com/jalexanderII/zero-railway/validation"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BudgetAlerter evaluates a user's spend against their budgets and texts them as thresholds are crossed
+type BudgetAlerter struct {
+	H *Handler
+	T *client.TwilioClient
+}
+
+func NewBudgetAlerter(h *Handler, tc *client.TwilioClient) *BudgetAlerter {
+	return &BudgetAlerter{H: h, T: tc}
+}
+
+// Evaluate checks every budget of the user for the current period and sends one SMS per budget for
+// the highest threshold newly crossed. The crossed thresholds are only recorded once the SMS is sent,
+// so a failed send alerts again on the next evaluation.
+func (b *BudgetAlerter) Evaluate(ctx context.Context, userId primitive.ObjectID) error {
+	budgets, err := GetUserBudgets(ctx, b.H, userId)
+	if err != nil {
+		return err
+	}
+	if len(budgets) == 0 {
+		return nil
+	}
+
+	user, err := b.H.GetUserByID(ctx, userId.Hex())
+	if err != nil {
+		return err
+	}
+	if !user.GetNotificationPreferences().SendBudgetAlerts() {
+		return nil
+	}
+	if user.PhoneNumber == "" {
+		logging.FromContext(ctx).WithField("user_id", userId.Hex()).Info("[Budget] user has no phone number, skipping alerts")
+		return nil
+	}
+
+	period, start, end := budgetPeriod(time.Now())
+	alerted, err := b.alerted(ctx, userId, period)
+	if err != nil {
+		return err
+	}
+	for _, budget := range budgets {
+		spend, err := BudgetSpend(ctx, b.H, &budget, start, end)
+		if err != nil {
+			return err
+		}
+		crossed := crossedThresholds(&budget, spend, alerted[budget.ID])
+		if len(crossed) == 0 {
+			continue
+		}
+		if _, err = b.T.SendSMS(ctx, user.PhoneNumber, budgetAlertMessage(&budget, crossed[len(crossed)-1], spend)); err != nil {
+			return err
+		}
+		for _, threshold := range crossed {
+			if _, err = b.H.Budgets.RecordAlert(ctx, &models.BudgetAlert{
+				BudgetId:  budget.ID,
+				UserId:    budget.UserId,
+				Period:    period,
+				Threshold: threshold,
+				Spend:     spend,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// alerted returns the thresholds of every budget of the user that already alerted this period
+func (b *BudgetAlerter) alerted(ctx context.Context, userId primitive.ObjectID, period string) (map[primitive.ObjectID]map[int]bool, error) {
+	alerts, err := b.H.Budgets.ListAlertsByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	alerted := make(map[primitive.ObjectID]map[int]bool)
+	for _, alert := range alerts {
+		if alert.Period != period {
+			continue
+		}
+		if alerted[alert.BudgetId] == nil {
+			alerted[alert.BudgetId] = make(map[int]bool)
+		}
+		alerted[alert.BudgetId][alert.Threshold] = true
+	}
+	return alerted, nil
+}
+
+// crossedThresholds returns the thresholds of the budget the spend has reached that have not alerted
+// yet, in ascending order
+func crossedThresholds(budget *models.Budget, spend float64, alerted map[int]bool) []int {
+	percent := spend / budget.Limit * 100
+	var crossed []int
+	for _, threshold := range budget.Thresholds {
+		if percent >= float64(threshold) && !alerted[threshold] {
+			crossed = append(crossed, threshold)
+		}
+	}
+	sort.Ints(crossed)
+	return crossed
+}
+
+func budgetAlertMessage(budget *models.Budget, threshold int, spend float64) string {
+	if threshold >= 100 {
+		return fmt.Sprintf("You have gone over your %s budget: $%.2f spent of $%.2f this month", budget.Name, spend, budget.Limit)
+	}
+	return fmt.Sprintf("You have used %d%% of your %s budget: $%.2f spent of $%.2f this month", threshold, budget.Name, spend, budget.Limit)
+}
+
+// @Summary Get budgets for a single user.
+// @Description fetch all budgets for the user with their spend for the current month.
+// @Tags budgets
+// @Produce json
+// @Success 200 {object} []models.BudgetStatus
+// @Router /budgets [get]
+func GetUsersBudgets(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		budgets, err := GetUserBudgets(c.UserContext(), h, *user.GetID())
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's budgets")
+		}
+
+		period, start, end := budgetPeriod(time.Now())
+		statuses := make([]models.BudgetStatus, len(budgets))
+		for idx, budget := range budgets {
+			spend, err := BudgetSpend(c.UserContext(), h, &budget, start, end)
+			if err != nil {
+				return apierror.Wrap(err, "failed computing budget spend")
+			}
+			statuses[idx] = models.BudgetStatus{Budget: budget, Period: period, Spend: spend, Percent: spend / budget.Limit * 100}
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "user budgets", statuses)
+	}
+}
+
+// @Summary Create a budget.
+// @Description create a monthly budget for a category and/or a card.
+// @Tags budgets
+// @Accept json
+// @Param budget body models.BudgetRequest true "Budget to create"
+// @Produce json
+// @Success 200 {object} models.Budget
+// @Router /budgets [post]
+func CreateBudget(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		input := new(models.BudgetRequest)
+		if err = validation.ParseBody(c, input); err != nil {
+			return err
+		}
+		thresholds := normalizeBudgetRequest(input)
+		if err = checkBudgetAccount(c.UserContext(), h, *user.GetID(), rcache, input.AccountId); err != nil {
+			return err
+		}
+
+		budget := models.Budget{
+			UserId:     *user.GetID(),
+			Name:       input.Name,
+			Category:   input.Category,
+			AccountId:  input.AccountId,
+			Limit:      input.Limit,
+			Thresholds: thresholds,
+		}
+		if err = h.Budgets.Create(c.UserContext(), &budget); err != nil {
+			return apierror.Wrap(err, "failed to create budget")
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "budget created", budget)
+	}
+}
+
+// @Summary Update a budget.
+// @Description update a single budget by id.
+// @Tags budgets
+// @Accept json
+// @Param id path string true "Budget ID"
+// @Param budget body models.BudgetRequest true "Updated budget"
+// @Produce json
+// @Success 200 {object} UpdateResponse
+// @Router /budgets/:id [put]
+func UpdateBudget(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		budgetId, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return apierror.Validation("invalid budget id", err.Error())
+		}
+		input := new(models.BudgetRequest)
+		if err = validation.ParseBody(c, input); err != nil {
+			return err
+		}
+		thresholds := normalizeBudgetRequest(input)
+		if err = checkBudgetAccount(c.UserContext(), h, *user.GetID(), rcache, input.AccountId); err != nil {
+			return err
+		}
+
+		modified, err := h.Budgets.Update(c.UserContext(), &models.Budget{
+			ID:         budgetId,
+			UserId:     *user.GetID(),
+			Name:       input.Name,
+			Category:   input.Category,
+			AccountId:  input.AccountId,
+			Limit:      input.Limit,
+			Thresholds: thresholds,
+		})
+		if errors.Is(err, repository.ErrNotFound) {
+			return apierror.NotFound("budget not found")
+		}
+		if err != nil {
+			return apierror.Wrap(err, "failed to update budget")
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "updated budget", UpdateResponse{modified})
+	}
+}
+
+// @Summary Delete a budget.
+// @Description delete a single budget by id.
+// @Tags budgets
+// @Param id path string true "Budget ID"
+// @Produce json
+// @Success 200 {object} int64
+// @Router /budgets/:id [delete]
+func DeleteBudget(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		budgetId, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return apierror.Validation("invalid budget id", err.Error())
+		}
+
+		err = h.Budgets.Delete(c.UserContext(), *user.GetID(), budgetId)
+		if errors.Is(err, repository.ErrNotFound) {
+			return apierror.NotFound("budget not found")
+		}
+		if err != nil {
+			return apierror.Wrap(err, "failed to delete budget")
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "budget deleted", 1)
+	}
+}
+
+func GetUserBudgets(ctx context.Context, h *Handler, userId primitive.ObjectID) ([]models.Budget, error) {
+	budgets, err := h.Budgets.ListByUser(ctx, userId)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("[BudgetDb] Error getting user budgets")
+		return nil, err
+	}
+	return budgets, nil
+}
+
+// BudgetSpend sums the spend counted against the budget between start and end
+func BudgetSpend(ctx context.Context, h *Handler, budget *models.Budget, start, end time.Time) (float64, error) {
+	return h.Transactions.TotalSpend(ctx, repository.SpendFilter{
+		UserId:    budget.UserId,
+		Start:     start,
+		End:       end,
+		Category:  budget.Category,
+		AccountId: budget.AccountId,
+	})
+}
+
+// budgetPeriod returns the key, start and end of the calendar month budgets are evaluated over
+func budgetPeriod(now time.Time) (string, time.Time, time.Time) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0).Add(-time.Millisecond)
+	return start.Format("2006-01"), start, end
+}
+
+// normalizeBudgetRequest tidies up the validated request and returns its thresholds sorted without
+// duplicates, defaulting them if unset
+func normalizeBudgetRequest(input *models.BudgetRequest) []int {
+	input.Name = strings.TrimSpace(input.Name)
+	input.Category = strings.ToUpper(strings.TrimSpace(input.Category))
+	input.AccountId = strings.TrimSpace(input.AccountId)
+	if len(input.Thresholds) == 0 {
+		return append([]int(nil), models.DefaultBudgetThresholds...)
+	}
+	thresholds := append([]int(nil), input.Thresholds...)
+	sort.Ints(thresholds)
+	return thresholds
+}
+
+// checkBudgetAccount rejects a budget on an account the user does not hold
+func checkBudgetAccount(ctx context.Context, h *Handler, userId primitive.ObjectID, rcache *caching.Store, accountId string) error {
+	if accountId == "" {
+		return nil
+	}
+	accounts, _, err := userHoldings(ctx, h, userId, rcache)
+	if err != nil {
+		return apierror.Wrap(err, "failed getting user's accounts")
+	}
+	if !accounts[accountId] {
+		return validation.Fields([]validation.FieldError{notOwned("account_id", "is not one of your accounts")})
+	}
+	return nil
+}
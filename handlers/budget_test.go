@@ -0,0 +1,85 @@
+package handlers_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/handlers"
+	"github.com/jalexanderII/zero-railway/models"
+)
+
+// newBudgetAlerter returns an alerter for a user with a dining budget of $50 they spent $42.50 of
+func newBudgetAlerter(t *testing.T, prefs *models.NotificationPreferences) (*testServer, *models.User, *handlers.BudgetAlerter) {
+	t.Helper()
+	ctx := context.Background()
+	ts := newTestServer(t)
+	user := ts.addUser("alice")
+	if prefs != nil {
+		if _, err := ts.repos.Users.UpdateNotificationPreferences(ctx, user.ID, prefs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	budget := &models.Budget{UserId: user.ID, Name: "Dining", Category: "FOOD_AND_DRINK", Limit: 50, Thresholds: []int{50, 80, 100}}
+	if err := ts.repos.Budgets.Create(ctx, budget); err != nil {
+		t.Fatal(err)
+	}
+	transactions := []*models.Transaction{{ID: "t1", UserId: user.ID, PlaidTransactionId: "t1", Amount: 42.5, PrimaryCategory: "FOOD_AND_DRINK", Date: time.Now().UnixMilli()}}
+	if err := ts.repos.Transactions.Upsert(ctx, transactions); err != nil {
+		t.Fatal(err)
+	}
+	return ts, user, handlers.NewBudgetAlerter(handlers.NewHandler(ts.repos, nil), ts.twilio)
+}
+
+func TestBudgetAlertsAreRecordedOnceSent(t *testing.T) {
+	ctx := context.Background()
+	ts, user, alerter := newBudgetAlerter(t, nil)
+
+	ts.sms.fail = true
+	if err := alerter.Evaluate(ctx, user.ID); err == nil {
+		t.Fatal("got no error when the SMS failed")
+	}
+	if alerts, _ := ts.repos.Budgets.ListAlertsByUser(ctx, user.ID); len(alerts) != 0 {
+		t.Fatalf("got %d alerts recorded for a failed SMS, want none", len(alerts))
+	}
+
+	ts.sms.fail = false
+	for run := 0; run < 2; run++ {
+		if err := alerter.Evaluate(ctx, user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := ts.sms.messages()
+	if len(sent) != 1 || !strings.Contains(sent[0].Body, "80%") {
+		t.Fatalf("got texts %+v, want a single alert for 80%%", sent)
+	}
+	if alerts, _ := ts.repos.Budgets.ListAlertsByUser(ctx, user.ID); len(alerts) != 2 {
+		t.Fatalf("got %d alerts recorded, want 50%% and 80%%", len(alerts))
+	}
+}
+
+func TestBudgetAlertsFollowPreferences(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name  string
+		prefs *models.NotificationPreferences
+		sent  int
+	}{
+		{name: "default preferences", sent: 1},
+		{name: "preferences saved before budget alerts", prefs: &models.NotificationPreferences{DueDateReminders: true}, sent: 1},
+		{name: "budget alerts on", prefs: &models.NotificationPreferences{BudgetAlerts: &on}, sent: 1},
+		{name: "budget alerts off", prefs: &models.NotificationPreferences{BudgetAlerts: &off}, sent: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, user, alerter := newBudgetAlerter(t, tt.prefs)
+			if err := alerter.Evaluate(context.Background(), user.ID); err != nil {
+				t.Fatal(err)
+			}
+			if sent := ts.sms.messages(); len(sent) != tt.sent {
+				t.Fatalf("got %d texts, want %d", len(sent), tt.sent)
+			}
+		})
+	}
+}
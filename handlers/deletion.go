@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"github.com/jalexanderII/zero-railway/tracing"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AccountDeleter closes user accounts, removing their Items at plaid, their payment plans at planning
+// and everything stored about them. Audit events are kept.
+type AccountDeleter struct {
+	H           *Handler
+	planningURL string
+	rcache      *caching.Store
+	// timeout bounds an attempt, a deletion left running for longer is considered abandoned
+	timeout time.Duration
+}
+
+func NewAccountDeleter(h *Handler, planningURL string, rcache *caching.Store, timeout time.Duration) *AccountDeleter {
+	return &AccountDeleter{H: h, planningURL: planningURL, rcache: rcache, timeout: timeout}
+}
+
+// deletionStep removes part of a user's data and returns how much it removed. Steps must be safe to
+// run again after failing part way.
+type deletionStep struct {
+	Name string
+	Run  func(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error)
+}
+
+// deletionSteps run in order. The Items go first so plaid stops sharing data even if a later step
+// fails, the user goes last so a failed deletion can still be found by the user's id.
+var deletionSteps = []deletionStep{
+	{Name: "plaid_items", Run: removeItems},
+	{Name: "payment_plans", Run: deletePaymentPlans},
+	{Name: "accounts", Run: func(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+		return d.H.Accounts.DeleteByUser(ctx, deletion.UserId)
+	}},
+	{Name: "transactions", Run: func(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+		return d.H.Transactions.DeleteByUser(ctx, deletion.UserId)
+	}},
+	{Name: "payment_tasks", Run: func(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+		return d.H.PaymentTasks.DeleteByUser(ctx, deletion.UserId)
+	}},
+	{Name: "budgets", Run: func(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+		return d.H.Budgets.DeleteByUser(ctx, deletion.UserId)
+	}},
+	{Name: "balance_snapshots", Run: func(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+		return d.H.Snapshots.DeleteByUser(ctx, deletion.UserId)
+	}},
+	{Name: "due_date_reminders", Run: func(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+		return d.H.Reminders.DeleteByUser(ctx, deletion.UserId)
+	}},
+	{Name: "data_exports", Run: func(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+		return d.H.Exports.DeleteByUser(ctx, deletion.UserId)
+	}},
+	{Name: "user", Run: func(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+		if err := d.H.Users.Delete(ctx, deletion.UserId); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		return 1, nil
+	}},
+	// after the user, so a request made meanwhile can't cache them again
+	{Name: "cache", Run: func(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+		if err := d.rcache.Users.Invalidate(ctx, deletion.Email, deletion.ClerkId); err != nil {
+			return 0, err
+		}
+		return 0, d.rcache.AccountDetails.Invalidate(ctx, deletion.UserId)
+	}},
+}
+
+// removeItems removes every Item of the user at plaid, deleting each token once its Item is gone
+func removeItems(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+	tokens, err := d.H.Tokens.ListByUser(ctx, deletion.UserId)
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	for idx := range tokens {
+		if err = d.H.P.RemoveItem(ctx, &tokens[idx]); err != nil {
+			return removed, err
+		}
+		if err = d.H.Tokens.Delete(ctx, tokens[idx].ID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// deletePaymentPlans asks planning to delete every payment plan of the user
+func deletePaymentPlans(ctx context.Context, d *AccountDeleter, deletion *models.AccountDeletion) (int64, error) {
+	plans, err := planningGetUserPaymentPlans(ctx, d.H, fmt.Sprintf("%s/payment_plans/%s", d.planningURL, deletion.UserId.Hex()))
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	for _, plan := range plans.PaymentPlans {
+		res, err := planningDeletePaymentPlan(ctx, d.H, fmt.Sprintf("%s/paymentplan/%s", d.planningURL, plan.PaymentPlanId))
+		if err != nil {
+			return removed, err
+		}
+		if res.Status != models.DELETE_STATUS_SUCCESS {
+			return removed, apierror.UpstreamPlanning(fmt.Errorf("delete payment plan %s status %v", plan.PaymentPlanId, res.Status))
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Request starts the deletion of the user's account, or returns the deletion already started
+func (d *AccountDeleter) Request(ctx context.Context, user *models.User, source string) (*models.AccountDeletion, error) {
+	now := time.Now()
+	return d.H.Deletions.Start(ctx, &models.AccountDeletion{
+		UserId:      user.ID,
+		ClerkId:     user.ClerkId,
+		Email:       user.Email,
+		Source:      source,
+		Status:      models.DELETION_STATUS_PENDING,
+		Steps:       []string{},
+		RequestedAt: now,
+		UpdatedAt:   now,
+	})
+}
+
+// Go runs the deletion in the background, outliving the request that started it
+func (d *AccountDeleter) Go(ctx context.Context, id primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(logging.WithEntry(tracing.Detach(ctx), logging.FromContext(ctx)), d.timeout)
+	go func() {
+		defer cancel()
+		_, _ = d.Run(ctx, id)
+	}()
+}
+
+// Run runs the steps of the deletion not done yet. A deletion that is completed or being run elsewhere is
+// returned as is.
+func (d *AccountDeleter) Run(ctx context.Context, id primitive.ObjectID) (*models.AccountDeletion, error) {
+	log := logging.FromContext(ctx).WithField("deletion_id", id.Hex())
+	deletion, err := d.H.Deletions.Claim(ctx, id, time.Now().Add(-d.timeout))
+	if errors.Is(err, repository.ErrNotFound) {
+		return d.H.Deletions.Get(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log = log.WithField("user_id", deletion.UserId.Hex())
+
+	for _, step := range deletionSteps {
+		if deletion.Done(step.Name) {
+			continue
+		}
+		removed, err := step.Run(ctx, d, deletion)
+		if err != nil {
+			log.WithError(err).WithField("step", step.Name).Error("[Deletion] Error deleting account")
+			// the failure is recorded even if the attempt timed out
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if failErr := d.H.Deletions.Fail(ctx, id, step.Name, err.Error()); failErr != nil {
+				log.WithError(failErr).Error("[Deletion] Error marking account deletion failed")
+			}
+			return nil, err
+		}
+		if err = d.H.Deletions.StepDone(ctx, id, step.Name); err != nil {
+			return nil, err
+		}
+		deletion.Steps = append(deletion.Steps, step.Name)
+		log.WithField("step", step.Name).WithField("removed", removed).Info("account deletion step done")
+	}
+
+	if err = d.H.Deletions.Complete(ctx, id); err != nil {
+		return nil, err
+	}
+	event := models.AuditEvent{
+		Action:   "user.deleted",
+		UserId:   deletion.UserId,
+		Target:   "user:" + deletion.UserId.Hex(),
+		Metadata: map[string]any{"source": deletion.Source, "attempts": deletion.Attempts},
+	}
+	if err = d.H.Audit.Append(ctx, &event); err != nil {
+		log.WithError(err).WithField("action", event.Action).Error("[Audit] Error recording audit event")
+	}
+	log.Info("account deleted")
+	return d.H.Deletions.Get(ctx, id)
+}
+
+// start requests the deletion of the user's account on behalf of source and runs it in the background
+func (d *AccountDeleter) start(c *fiber.Ctx, user *models.User, source string) (*models.AccountDeletion, error) {
+	deletion, err := d.Request(c.UserContext(), user, source)
+	if err != nil {
+		return nil, apierror.Wrap(err, "failed to start account deletion")
+	}
+	d.H.RecordAudit(c, models.AuditEvent{
+		Action:   "user.deletion.requested",
+		UserId:   user.ID,
+		Target:   "user:" + user.ID.Hex(),
+		Metadata: map[string]any{"source": source, "deletion_id": deletion.ID.Hex()},
+	})
+	if deletion.Status != models.DELETION_STATUS_COMPLETED {
+		d.Go(c.UserContext(), deletion.ID)
+	}
+	return deletion, nil
+}
+
+// @Summary Close the user's account.
+// @Description delete the user's account and everything stored about them, in the background.
+// @Tags users
+// @Produce json
+// @Success 202 {object} models.AccountDeletion
+// @Router /users [delete]
+func CloseAccount(d *AccountDeleter, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		deletion, err := d.start(c, user, "user")
+		if err != nil {
+			return err
+		}
+		return FiberJsonResponse(c, fiber.StatusAccepted, "success", "account deletion started", deletion)
+	}
+}
+
+// @Summary Handle a clerk webhook.
+// @Description create users on clerk sign ups and delete their accounts on user.deleted, which requires a verified webhook.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Router /clerk [post]
+func ClerkWebhook(h *Handler, d *AccountDeleter, rcache *caching.Store, verified bool) func(c *fiber.Ctx) error {
+	createUser := CreateUserClerkWebhook(h, rcache)
+	return func(c *fiber.Ctx) error {
+		var event struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(c.Body(), &event); err != nil {
+			return apierror.Validation("invalid clerk event", err.Error())
+		}
+		if event.Type != "user.deleted" {
+			return createUser(c)
+		}
+		if !verified {
+			logging.Ctx(c).Warn("[Clerk] refusing user.deleted, CLERK_WEBHOOK_SECRET is not set")
+			return apierror.Forbidden("unverified webhooks can't delete users")
+		}
+		return deleteClerkUser(c, h, d)
+	}
+}
+
+// deleteClerkUser deletes the account of the user clerk reports deleted. Users already deleted, or never
+// created, are acknowledged so clerk does not retry.
+func deleteClerkUser(c *fiber.Ctx, h *Handler, d *AccountDeleter) error {
+	event := new(models.ClerkUserDeleted)
+	if err := json.Unmarshal(c.Body(), event); err != nil || event.Data.Id == "" {
+		return apierror.Validation("invalid clerk user.deleted event", "data.id is required")
+	}
+	user, err := h.Users.GetByClerkId(c.UserContext(), event.Data.Id)
+	if errors.Is(err, repository.ErrNotFound) {
+		deletion, err := h.Deletions.GetByClerkId(c.UserContext(), event.Data.Id)
+		if errors.Is(err, repository.ErrNotFound) {
+			return FiberJsonResponse(c, fiber.StatusOK, "success", "no user to delete", nil)
+		}
+		if err != nil {
+			return apierror.Wrap(err, "failed getting account deletion")
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "account deletion already started", deletion)
+	}
+	if err != nil {
+		return apierror.Wrap(err, "failed getting user")
+	}
+	deletion, err := d.start(c, user, "clerk")
+	if err != nil {
+		return err
+	}
+	return FiberJsonResponse(c, fiber.StatusAccepted, "success", "account deletion started", deletion)
+}
+
+// @Summary Delete a user's account.
+// @Description delete the account of a user and everything stored about them, in the background.
+// @Tags admin
+// @Param id path string true "User ID"
+// @Produce json
+// @Success 202 {object} models.AccountDeletion
+// @Router /admin/users/:id [delete]
+func AdminDeleteUser(d *AccountDeleter) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := adminUser(c, d.H)
+		if err != nil {
+			return err
+		}
+		deletion, err := d.start(c, user, "admin")
+		if err != nil {
+			return err
+		}
+		return FiberJsonResponse(c, fiber.StatusAccepted, "success", "account deletion started", deletion)
+	}
+}
+
+// adminDeletion returns the deletion named by the id path parameter
+func adminDeletion(c *fiber.Ctx, h *Handler) (*models.AccountDeletion, error) {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, apierror.Validation("invalid deletion id", err.Error())
+	}
+	deletion, err := h.Deletions.Get(c.UserContext(), id)
+	if err != nil {
+		return nil, apierror.Wrap(err, "account deletion not found")
+	}
+	return deletion, nil
+}
+
+// @Summary Get an account deletion.
+// @Description fetch the progress of an account deletion, or the tombstone of a deleted user.
+// @Tags admin
+// @Param id path string true "Deletion ID"
+// @Produce json
+// @Success 200 {object} models.AccountDeletion
+// @Router /admin/deletions/:id [get]
+func AdminGetDeletion(d *AccountDeleter) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		deletion, err := adminDeletion(c, d.H)
+		if err != nil {
+			return err
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "account deletion", deletion)
+	}
+}
+
+// @Summary Resume an account deletion.
+// @Description run the remaining steps of a failed account deletion in the background.
+// @Tags admin
+// @Param id path string true "Deletion ID"
+// @Produce json
+// @Success 202 {object} models.AccountDeletion
+// @Router /admin/deletions/:id/resume [post]
+func AdminResumeDeletion(d *AccountDeleter) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		deletion, err := adminDeletion(c, d.H)
+		if err != nil {
+			return err
+		}
+		if deletion.Status == models.DELETION_STATUS_COMPLETED {
+			return FiberJsonResponse(c, fiber.StatusOK, "success", "account deletion already completed", deletion)
+		}
+		d.Go(c.UserContext(), deletion.ID)
+		return FiberJsonResponse(c, fiber.StatusAccepted, "success", "account deletion resumed", deletion)
+	}
+}
+
+// @Summary Resume every unfinished account deletion.
+// @Description run the remaining steps of every failed or abandoned account deletion in the background.
+// @Tags admin
+// @Produce json
+// @Success 202 {object} []string
+// @Router /admin/jobs/resume_deletions [post]
+func AdminResumeDeletions(d *AccountDeleter) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		deletions, err := d.H.Deletions.ListUnfinished(c.UserContext(), time.Now().Add(-d.timeout))
+		if err != nil {
+			return apierror.Wrap(err, "failed listing unfinished account deletions")
+		}
+		ids := make([]string, len(deletions))
+		for idx, deletion := range deletions {
+			d.Go(c.UserContext(), deletion.ID)
+			ids[idx] = deletion.ID.Hex()
+		}
+		return FiberJsonResponse(c, fiber.StatusAccepted, "success", "account deletions resumed", ids)
+	}
+}
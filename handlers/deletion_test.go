@@ -0,0 +1,107 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	client "github.com/jalexanderII/zero-railway/app/clients"
+	"github.com/jalexanderII/zero-railway/handlers"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"github.com/sirupsen/logrus"
+)
+
+func TestAccountDeletionResumes(t *testing.T) {
+	ctx := context.Background()
+	ts, _ := newSeededServer(t)
+	// stores alice's accounts and transactions
+	ts.expect(http.StatusOK, http.MethodGet, "/api/plaid/accounts", "alice", nil)
+	alice, err := ts.repos.Users.GetByClerkId(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.planning.add(&models.PaymentPlan{PaymentPlanId: "alice-plan", UserId: alice.ID.Hex()})
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	plaidClient := &client.PlaidClient{Name: "ZeroFintech", Client: ts.plaid, L: l}
+	deleter := handlers.NewAccountDeleter(handlers.NewHandler(ts.repos, plaidClient), ts.planning.URL, ts.cache, time.Minute)
+
+	deletion, err := deleter.Request(ctx, alice, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// plaid fails: nothing is done
+	ts.plaid.mu.Lock()
+	ts.plaid.removeErr = errors.New("plaid error, code: INTERNAL_SERVER_ERROR, message: try again")
+	ts.plaid.mu.Unlock()
+	if _, err = deleter.Run(ctx, deletion.ID); err == nil {
+		t.Fatal("got no error when plaid failed")
+	}
+	deletion = getDeletion(t, ts, deletion)
+	if deletion.Status != models.DELETION_STATUS_FAILED || deletion.FailedStep != "plaid_items" || len(deletion.Steps) != 0 {
+		t.Fatalf("got deletion %+v, want it failed at plaid_items", deletion)
+	}
+	if tokens, _ := ts.repos.Tokens.ListByUser(ctx, alice.ID); len(tokens) != 1 {
+		t.Fatalf("got %d tokens after plaid failed, want alice's token kept", len(tokens))
+	}
+
+	// plaid recovers and planning fails: the Items are removed
+	ts.plaid.mu.Lock()
+	ts.plaid.removeErr = nil
+	ts.plaid.mu.Unlock()
+	ts.planning.mu.Lock()
+	ts.planning.failDelete = true
+	ts.planning.mu.Unlock()
+	if _, err = deleter.Run(ctx, deletion.ID); err == nil {
+		t.Fatal("got no error when planning failed")
+	}
+	deletion = getDeletion(t, ts, deletion)
+	if deletion.FailedStep != "payment_plans" || len(deletion.Steps) != 1 || deletion.Steps[0] != "plaid_items" {
+		t.Fatalf("got deletion %+v, want plaid_items done and payment_plans failed", deletion)
+	}
+
+	// a step done is not run again, even though plaid would fail it now
+	ts.plaid.mu.Lock()
+	ts.plaid.removeErr = errors.New("plaid error, code: INTERNAL_SERVER_ERROR, message: try again")
+	ts.plaid.mu.Unlock()
+	ts.planning.mu.Lock()
+	ts.planning.failDelete = false
+	ts.planning.mu.Unlock()
+	if deletion, err = deleter.Run(ctx, deletion.ID); err != nil {
+		t.Fatal(err)
+	}
+	if deletion.Status != models.DELETION_STATUS_COMPLETED || deletion.Attempts != 3 || deletion.CompletedAt == nil {
+		t.Fatalf("got deletion %+v, want it completed on the third attempt", deletion)
+	}
+	if len(ts.plaid.removed) != 1 || ts.plaid.removed[0] != "access-alice" {
+		t.Fatalf("got Items %v removed, want alice's once", ts.plaid.removed)
+	}
+
+	// the tombstone keeps who was deleted but not their email
+	if deletion.UserId != alice.ID || deletion.ClerkId != "alice" || deletion.Email != "" {
+		t.Fatalf("got tombstone %+v, want alice's ids without her email", deletion)
+	}
+	if tombstone, err := ts.repos.Deletions.GetByClerkId(ctx, "alice"); err != nil || tombstone.ID != deletion.ID {
+		t.Fatalf("got tombstone %+v and error %v by clerk id, want the deletion", tombstone, err)
+	}
+	if _, err = ts.repos.Users.GetByID(ctx, alice.ID); err == nil {
+		t.Fatal("got alice after her deletion completed")
+	}
+	if transactions, _ := ts.repos.Transactions.Query(ctx, repository.TransactionQuery{Filter: repository.TransactionFilter{UserId: alice.ID}}); len(transactions) != 0 {
+		t.Fatalf("got %d of alice's transactions after her deletion", len(transactions))
+	}
+}
+
+func getDeletion(t *testing.T, ts *testServer, deletion *models.AccountDeletion) *models.AccountDeletion {
+	t.Helper()
+	deletion, err := ts.repos.Deletions.Get(context.Background(), deletion.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deletion
+}
@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/auth"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"github.com/jalexanderII/zero-railway/tracing"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DataExporter builds archives of everything stored about a user, in the background, and hands them
+// out through signed download URLs
+type DataExporter struct {
+	H         *Handler
+	key       []byte
+	urlTTL    time.Duration
+	retention time.Duration
+	timeout   time.Duration
+}
+
+// NewDataExporter signs download URLs with signingKey, or with a random key when it is empty
+func NewDataExporter(h *Handler, signingKey string, urlTTL, retention, timeout time.Duration) *DataExporter {
+	key := []byte(signingKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		_, _ = rand.Read(key)
+	}
+	return &DataExporter{H: h, key: key, urlTTL: urlTTL, retention: retention, timeout: timeout}
+}
+
+// exportedItem is a linked Item without its access token
+type exportedItem struct {
+	ItemId        string         `json:"item_id"`
+	Institution   string         `json:"institution"`
+	InstitutionID string         `json:"institution_id"`
+	Purpose       models.Purpose `json:"purpose"`
+}
+
+// exportedPaymentPlan is a payment plan as known locally, through the transactions it covers
+type exportedPaymentPlan struct {
+	PaymentPlanId  string   `json:"payment_plan_id"`
+	TransactionIds []string `json:"transaction_ids"`
+}
+
+// exportDataset is a file of the archive, written both as JSON and CSV
+type exportDataset struct {
+	Name string
+	Rows any
+}
+
+// @Summary Request an export of the user's data.
+// @Description start building an archive of everything stored about the user, poll its status for the download URL.
+// @Tags users
+// @Produce json
+// @Success 202 {object} models.DataExport
+// @Router /users/export [post]
+func (e *DataExporter) RequestExport(rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+
+		now := time.Now()
+		export := &models.DataExport{UserId: user.ID, Status: models.EXPORT_STATUS_PENDING, CreatedAt: now, ExpiresAt: now.Add(e.retention)}
+		if err = e.H.Exports.Create(c.UserContext(), export); err != nil {
+			return apierror.Wrap(err, "failed to create data export")
+		}
+		e.H.RecordAudit(c, models.AuditEvent{Action: "user.data_export.requested", UserId: user.ID, Target: "data_export:" + export.ID.Hex()})
+
+		// the export outlives the request, keeping its trace and logger
+		ctx, cancel := context.WithTimeout(logging.WithEntry(tracing.Detach(c.UserContext()), logging.FromContext(c.UserContext())), e.timeout)
+		go func() {
+			defer cancel()
+			e.run(ctx, export.ID, user)
+		}()
+		return FiberJsonResponse(c, fiber.StatusAccepted, "success", "data export started", export)
+	}
+}
+
+// @Summary Get the status of a data export.
+// @Description fetch a data export of the user, once completed it carries a short lived download URL.
+// @Tags users
+// @Param id path string true "Export ID"
+// @Produce json
+// @Success 200 {object} models.DataExport
+// @Router /users/export/:id [get]
+func (e *DataExporter) GetExport() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		id, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return apierror.Validation("invalid export id", err.Error())
+		}
+		export, err := e.H.Exports.Get(c.UserContext(), id)
+		if err != nil {
+			return apierror.Wrap(err, "data export not found")
+		}
+		if err = auth.ActAs(c, export.UserId); err != nil {
+			return apierror.NotFound("data export not found")
+		}
+
+		if export.Status == models.EXPORT_STATUS_COMPLETED {
+			expires := time.Now().Add(e.urlTTL)
+			if expires.After(export.ExpiresAt) {
+				expires = export.ExpiresAt
+			}
+			export.DownloadURL = fmt.Sprintf("%s/exports/%s/download?expires=%d&signature=%s",
+				c.BaseURL(), export.ID.Hex(), expires.Unix(), e.sign(export.ID, expires.Unix()))
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "data export", export)
+	}
+}
+
+// @Summary Download a data export.
+// @Description download the archive of a data export through the signed URL returned by its status.
+// @Tags users
+// @Param id path string true "Export ID"
+// @Param expires query int true "Expiry of the URL"
+// @Param signature query string true "Signature of the URL"
+// @Produce application/zip
+// @Router /exports/:id/download [get]
+func (e *DataExporter) DownloadExport() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		id, err := primitive.ObjectIDFromHex(c.Params("id"))
+		if err != nil {
+			return apierror.Validation("invalid export id", err.Error())
+		}
+		expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+		if err != nil || time.Now().Unix() > expires || !hmac.Equal([]byte(c.Query("signature")), []byte(e.sign(id, expires))) {
+			return apierror.Forbidden("the download link is invalid or has expired, request a new one")
+		}
+
+		export, err := e.H.Exports.Get(c.UserContext(), id)
+		if err != nil {
+			return apierror.Wrap(err, "data export not found")
+		}
+		archive, err := e.H.Exports.Archive(c.UserContext(), id)
+		if err != nil {
+			return apierror.Wrap(err, "data export not found")
+		}
+		e.H.RecordAudit(c, models.AuditEvent{Action: "user.data_export.downloaded", UserId: export.UserId, Target: "data_export:" + id.Hex()})
+
+		// the archive holds everything stored about the user, no cache may keep a copy
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Set(fiber.HeaderContentType, "application/zip")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="zero-export-%s.zip"`, export.CreatedAt.UTC().Format("20060102")))
+		return c.Status(fiber.StatusOK).Send(archive)
+	}
+}
+
+// @Summary Purge expired data export archives.
+// @Description delete the archives of the data exports past their retention.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} int64
+// @Router /admin/jobs/purge_exports [post]
+func (e *DataExporter) AdminPurgeExports() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		purged, err := e.H.Exports.PurgeExpired(c.UserContext(), time.Now())
+		if err != nil {
+			logging.Ctx(c).WithError(err).Error("[Export] Error purging expired data exports")
+			return apierror.Wrap(err, "failed purging expired data exports")
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "expired data exports purged", purged)
+	}
+}
+
+// sign returns the signature of the download URL of an export valid until expires
+func (e *DataExporter) sign(id primitive.ObjectID, expires int64) string {
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write([]byte(id.Hex() + ":" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// run builds the archive of an export and records the outcome
+func (e *DataExporter) run(ctx context.Context, id primitive.ObjectID, user *models.User) {
+	log := logging.FromContext(ctx).WithField("export_id", id.Hex())
+	if err := e.H.Exports.SetStatus(ctx, id, models.EXPORT_STATUS_RUNNING, ""); err != nil {
+		log.WithError(err).Error("[Export] Error marking data export running")
+	}
+
+	archive, err := e.archive(ctx, user)
+	if err == nil {
+		err = e.H.Exports.Complete(ctx, id, archive)
+	}
+	if err != nil {
+		log.WithError(err).Error("[Export] Error building data export")
+		// the failure is recorded even if the export timed out
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err = e.H.Exports.SetStatus(ctx, id, models.EXPORT_STATUS_FAILED, "the export could not be built, request a new one"); err != nil {
+			log.WithError(err).Error("[Export] Error marking data export failed")
+		}
+		return
+	}
+	log.WithField("size", len(archive)).Info("data export completed")
+}
+
+// archive zips every dataset stored about the user as JSON and CSV files
+func (e *DataExporter) archive(ctx context.Context, user *models.User) ([]byte, error) {
+	datasets, err := e.datasets(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, dataset := range datasets {
+		rows, err := exportRows(dataset.Rows)
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := zw.Create(dataset.Name + ".json")
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(dataset.Rows); err != nil {
+			return nil, err
+		}
+
+		if w, err = zw.Create(dataset.Name + ".csv"); err != nil {
+			return nil, err
+		}
+		if err = writeExportCSV(w, rows); err != nil {
+			return nil, err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// datasets collects everything stored about the user, leaving out the secrets of their Items
+func (e *DataExporter) datasets(ctx context.Context, user *models.User) ([]exportDataset, error) {
+	h := e.H
+	tokens, err := h.Tokens.ListByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]exportedItem, len(tokens))
+	for idx, token := range tokens {
+		items[idx] = exportedItem{ItemId: token.ItemId, Institution: token.Institution, InstitutionID: token.InstitutionID, Purpose: token.Purpose}
+	}
+
+	accounts, err := h.Accounts.ListByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := h.Transactions.Query(ctx, repository.TransactionQuery{Filter: repository.TransactionFilter{UserId: user.ID}, SortField: "date"})
+	if err != nil {
+		return nil, err
+	}
+	paymentTasks, err := h.PaymentTasks.ListByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	inPlan, err := h.Transactions.InPlan(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	budgets, err := h.Budgets.ListByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	budgetAlerts, err := h.Budgets.ListAlertsByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	reminders, err := h.Reminders.ListByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	var notifications []models.AuditEvent
+	if err = h.Audit.Each(ctx, repository.AuditFilter{UserId: user.ID}, func(event *models.AuditEvent) error {
+		if strings.HasPrefix(event.Action, "notification.") {
+			notifications = append(notifications, *event)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return []exportDataset{
+		{Name: "profile", Rows: []*models.User{user}},
+		{Name: "linked_items", Rows: items},
+		{Name: "accounts", Rows: accounts},
+		{Name: "transactions", Rows: transactions},
+		{Name: "payment_tasks", Rows: paymentTasks},
+		{Name: "payment_plans", Rows: exportPaymentPlans(inPlan)},
+		{Name: "budgets", Rows: budgets},
+		{Name: "budget_alerts", Rows: budgetAlerts},
+		{Name: "due_date_reminders", Rows: reminders},
+		{Name: "payment_notifications", Rows: notifications},
+	}, nil
+}
+
+// exportPaymentPlans groups the in plan transactions of a user by payment plan
+func exportPaymentPlans(inPlan map[string]string) []exportedPaymentPlan {
+	byPlan := make(map[string][]string)
+	for transactionId, planId := range inPlan {
+		byPlan[planId] = append(byPlan[planId], transactionId)
+	}
+	plans := make([]exportedPaymentPlan, 0, len(byPlan))
+	for planId, transactionIds := range byPlan {
+		sort.Strings(transactionIds)
+		plans = append(plans, exportedPaymentPlan{PaymentPlanId: planId, TransactionIds: transactionIds})
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].PaymentPlanId < plans[j].PaymentPlanId })
+	return plans
+}
+
+// exportRows flattens a slice of records to their top level json fields
+func exportRows(records any) ([]map[string]any, error) {
+	raw, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]any
+	if err = json.Unmarshal(raw, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// writeExportCSV writes rows with a column per field, nested values are written as JSON
+func writeExportCSV(w interface{ Write([]byte) (int, error) }, rows []map[string]any) error {
+	columnSet := make(map[string]bool)
+	for _, row := range rows {
+		for column := range row {
+			columnSet[column] = true
+		}
+	}
+	columns := make([]string, 0, len(columnSet))
+	for column := range columnSet {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for idx, column := range columns {
+			switch value := row[column].(type) {
+			case nil:
+				record[idx] = ""
+			case string:
+				record[idx] = value
+			default:
+				raw, err := json.Marshal(value)
+				if err != nil {
+					return err
+				}
+				record[idx] = string(raw)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
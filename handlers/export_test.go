@@ -0,0 +1,36 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+)
+
+func TestDownloadExportIsNotCached(t *testing.T) {
+	ts, _ := newSeededServer(t)
+	var export models.DataExport
+	ts.expect(http.StatusAccepted, http.MethodPost, "/api/core/users/export", "alice", nil).decode(t, &export)
+
+	for poll := 0; export.Status != models.EXPORT_STATUS_COMPLETED; poll++ {
+		if poll == 10 || export.Status == models.EXPORT_STATUS_FAILED {
+			t.Fatalf("got export %+v, want it completed", export)
+		}
+		time.Sleep(20 * time.Millisecond)
+		ts.expect(http.StatusOK, http.MethodGet, "/api/core/users/export/"+export.ID.Hex(), "alice", nil).decode(t, &export)
+	}
+
+	link, err := url.Parse(export.DownloadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := ts.expect(http.StatusOK, http.MethodGet, link.RequestURI(), "", nil)
+	if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+		t.Fatalf("got Cache-Control %q, want no-store", got)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/zip" {
+		t.Fatalf("got Content-Type %q, want application/zip", got)
+	}
+}
@@ -1,8 +1,17 @@
 package handlers
 
 import (
-	"github.com/go-redis/cache/v8"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/models"
 )
 
 // @Summary Show the status of server.
@@ -16,21 +25,143 @@ func HandleHealthCheck(c *fiber.Ctx) error {
 	return c.SendString("OK")
 }
 
-func ClearCache(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
+// DependencyCheck checks a single dependency of the service. A critical dependency being down makes the
+// service unavailable, any other only degrades the listed features.
+type DependencyCheck struct {
+	Name     string
+	Critical bool
+	Features []string
+	Check    func(ctx context.Context) error
+}
+
+// HealthChecker runs the dependency checks of the readiness endpoint, each bounded by Timeout
+type HealthChecker struct {
+	Checks  []DependencyCheck
+	Timeout time.Duration
+}
+
+func NewHealthChecker(timeout time.Duration, checks ...DependencyCheck) *HealthChecker {
+	return &HealthChecker{Checks: checks, Timeout: timeout}
+}
+
+// Run checks every dependency concurrently
+func (hc *HealthChecker) Run(ctx context.Context) models.ReadinessResponse {
+	results := make([]models.DependencyHealth, len(hc.Checks))
+	var wg sync.WaitGroup
+	for idx, check := range hc.Checks {
+		wg.Add(1)
+		go func(idx int, check DependencyCheck) {
+			defer wg.Done()
+			results[idx] = hc.run(ctx, check)
+		}(idx, check)
+	}
+	wg.Wait()
+
+	response := models.ReadinessResponse{Status: models.HealthStatusOK, Dependencies: results}
+	for _, result := range results {
+		if result.Status == models.DependencyStatusUp {
+			continue
+		}
+		response.AffectedFeatures = append(response.AffectedFeatures, result.Features...)
+		if result.Critical {
+			response.Status = models.HealthStatusUnavailable
+		} else if response.Status == models.HealthStatusOK {
+			response.Status = models.HealthStatusDegraded
+		}
+	}
+	return response
+}
+
+func (hc *HealthChecker) run(ctx context.Context, check DependencyCheck) models.DependencyHealth {
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	// the check may not honour the context, so give up on it once the timeout is reached
+	done := make(chan error, 1)
+	go func() { done <- check.Check(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", hc.Timeout)
+	}
+
+	result := models.DependencyHealth{
+		Name:      check.Name,
+		Status:    models.DependencyStatusUp,
+		Critical:  check.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Features:  check.Features,
+	}
+	if err != nil {
+		result.Status = models.DependencyStatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// @Summary Liveness probe.
+// @Description reports that the process is up, without checking any dependency.
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /health/live [get]
+func HandleLiveness(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": models.HealthStatusOK})
+}
+
+// @Summary Readiness probe.
+// @Description checks Mongo, Redis, the planning service and the Plaid and Twilio configuration.
+// @Description Responds 503 when a critical dependency is down, and lists the affected features when degraded.
+// @Tags health
+// @Produce json
+// @Success 200 {object} models.ReadinessResponse
+// @Failure 503 {object} models.ReadinessResponse
+// @Router /health/ready [get]
+func HandleReadiness(hc *HealthChecker) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
-		email := c.Params("email")
+		response := hc.Run(c.UserContext())
+		status := fiber.StatusOK
+		if response.Status == models.HealthStatusUnavailable {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(response)
+	}
+}
 
-		user, err := h.GetUserByEmail(email, rcache)
+// HTTPCheck reports a service down when it can't be reached or answers with a server error
+func HTTPCheck(client *http.Client, url string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting users account", err.Error())
+			return err
 		}
-
-		err = rcache.Delete(c.Context(), user.GetID().Hex())
+		resp, err := client.Do(req)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed clearing cache", err.Error())
+			return err
 		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("responded %s", resp.Status)
+		}
+		return nil
+	}
+}
 
-		return FiberJsonResponse(c, fiber.StatusOK, "success", "cache cleared", nil)
-
+// ConfiguredCheck reports a dependency down when any of its settings is empty
+func ConfiguredCheck(settings map[string]string) func(ctx context.Context) error {
+	return func(context.Context) error {
+		var missing []string
+		for name, value := range settings {
+			if value == "" {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			return errors.New("not configured: " + strings.Join(missing, ", "))
+		}
+		return nil
 	}
 }
@@ -2,17 +2,21 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/go-redis/cache/v8"
 	"github.com/sirupsen/logrus"
+	"net/http"
 	"sync"
 	"time"
 
+	"github.com/jalexanderII/zero-railway/apierror"
 	client "github.com/jalexanderII/zero-railway/app/clients"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
 	"github.com/jalexanderII/zero-railway/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -28,23 +32,23 @@ type SendSMSResponse struct {
 // @Accept */*
 // @Produce json
 // @Success 200 {object} []models.SendSMSResponse
-// @Router /notify [get]
-func NotifyUsersUpcomingPaymentActions(tc *client.TwilioClient, h *Handler, planningUrl string, rcache *cache.Cache) func(c *fiber.Ctx) error {
+// @Router /admin/jobs/payment_notifications [post]
+func NotifyUsersUpcomingPaymentActions(tc *client.TwilioClient, h *Handler, planningUrl string, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
-		err := cleanUpStalePaymentPlans(h, planningUrl)
+		err := cleanUpStalePaymentPlans(c.UserContext(), h, planningUrl)
 		if err != nil {
-			h.L.Error("[Planning] error cleaning up old payment plans ", err.Error())
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "error cleaning up old payment plans", err.Error())
+			logging.Ctx(c).WithError(err).Error("[Planning] error cleaning up old payment plans")
+			return apierror.Wrap(err, "error cleaning up old payment plans")
 		}
 
 		url := fmt.Sprintf("%s/paymentactions", planningUrl)
 		paymentActionsRequest := &models.GetAllUpcomingPaymentActionsRequest{
 			Date: time.Now().UTC().Format("2006-01-02T15:04:05Z07:00"),
 		}
-		upcomingPaymentActionsAllUsers, err := planningGetAllUpcomingPaymentActions(h, url, paymentActionsRequest)
+		upcomingPaymentActionsAllUsers, err := planningGetAllUpcomingPaymentActions(c.UserContext(), h, url, paymentActionsRequest)
 		if err != nil {
-			h.L.Error("error listing upcoming PaymentActions", err.Error())
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "error listing upcoming PaymentActions", err.Error())
+			logging.Ctx(c).WithError(err).Error("error listing upcoming PaymentActions")
+			return apierror.Wrap(err, "error listing upcoming PaymentActions")
 		}
 
 		// Use wait group to wait for all goroutines to finish
@@ -71,11 +75,18 @@ func NotifyUsersUpcomingPaymentActions(tc *client.TwilioClient, h *Handler, plan
 
 		errorChan := make(chan error, len(userIds))
 
+		// the goroutines stop fetching accounts once the request's deadline has passed
+		ctx := c.UserContext()
+
 		for userId, accLiab := range userAccLiabilities {
 			wg.Add(1) // increment wait group counter
 
 			go func(userId string, accLiab map[string]float64) {
 				defer wg.Done() // decrement wait group counter when done
+				if err := ctx.Err(); err != nil {
+					notifyError(logging.FromContext(ctx).WithField("user_id", userId), errorChan, "request cancelled before notifying user", err.Error())
+					return
+				}
 
 				totalLiab := 0.0
 				for _, liab := range accLiab {
@@ -83,15 +94,15 @@ func NotifyUsersUpcomingPaymentActions(tc *client.TwilioClient, h *Handler, plan
 				}
 
 				id, _ := primitive.ObjectIDFromHex(userId)
-				userAccs, err := GetUserAccounts(h, &id, rcache)
+				userAccs, err := GetUserAccounts(ctx, h, &id, rcache)
 				if err != nil {
-					notifyError(h.L, errorChan, "error listing accounts", err.Error())
+					notifyError(logging.FromContext(ctx).WithField("user_id", userId), errorChan, "error listing accounts", err.Error())
 					return // using return instead of FiberJsonResponse because this is a goroutine, not the main function
 				}
 
-				totalDebit := GetDebitAccountBalance(h, &id, rcache)
-				if err != nil {
-					notifyError(h.L, errorChan, "error getting debit balance", err.Error())
+				totalDebit := GetDebitAccountBalance(ctx, h, &id, rcache)
+				if totalDebit == nil {
+					notifyError(logging.FromContext(ctx).WithField("user_id", userId), errorChan, "error getting debit balance", "no debit account balance for user "+userId)
 					return // using return instead of FiberJsonResponse because this is a goroutine, not the main function
 				}
 
@@ -134,18 +145,23 @@ func NotifyUsersUpcomingPaymentActions(tc *client.TwilioClient, h *Handler, plan
 		// Check for any errors received from goroutines
 		for err := range errorChan {
 			if err != nil {
-				return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "error processing user notifications", err.Error())
+				return apierror.Wrap(err, "error processing user notifications")
 			}
 		}
 
 		// send notifications to the appropriate user using data from the map
 		for userId, message := range notifications {
-			user, _ := h.GetUserByID(userId)
-			resp, err := tc.SendSMS(user.PhoneNumber, message)
+			user, err := h.GetUserByID(c.UserContext(), userId)
+			if err != nil {
+				logging.Ctx(c).WithError(err).WithField("user_id", userId).Error("error getting user to notify")
+				return apierror.Wrap(err, "error getting user to notify")
+			}
+			resp, err := tc.SendSMS(c.UserContext(), user.PhoneNumber, message)
 			if err != nil {
-				h.L.Error("error sending SMS", err.Error())
-				return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "error sending SMS", resp)
+				logging.Ctx(c).WithError(err).WithField("user_id", userId).Error("error sending SMS")
+				return apierror.Wrap(err, "error sending SMS")
 			}
+			h.RecordAudit(c, models.AuditEvent{Action: "notification.payment_actions.sent", UserId: user.ID, Target: "user:" + userId, Metadata: map[string]any{"successful": resp.Successful}})
 			resps = append(resps, *resp)
 		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "successfully notified users", resps)
@@ -153,22 +169,23 @@ func NotifyUsersUpcomingPaymentActions(tc *client.TwilioClient, h *Handler, plan
 }
 
 // notifyError - log error
-func notifyError(L *logrus.Logger, errChan chan error, msg string, err string) {
-	L.Error(msg, err)
+func notifyError(log *logrus.Entry, errChan chan error, msg string, err string) {
+	log.WithField("error", err).Error(msg)
 	errChan <- errors.New(err)
 	return
 }
 
-func planningGetAllUpcomingPaymentActions(h *Handler, url string, req *models.GetAllUpcomingPaymentActionsRequest) (*models.GetAllUpcomingPaymentActionsResponse, error) {
+func planningGetAllUpcomingPaymentActions(ctx context.Context, h *Handler, url string, req *models.GetAllUpcomingPaymentActionsRequest) (*models.GetAllUpcomingPaymentActionsResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := h.H.Post(url, "application/json", bytes.NewBuffer(body))
+	resp, err := planningRequest(ctx, h, http.MethodPost, url, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	var result models.GetAllUpcomingPaymentActionsResponse
 
@@ -179,11 +196,11 @@ func planningGetAllUpcomingPaymentActions(h *Handler, url string, req *models.Ge
 	return &result, nil
 }
 
-func cleanUpStalePaymentPlans(h *Handler, planningUrl string) error {
+func cleanUpStalePaymentPlans(ctx context.Context, h *Handler, planningUrl string) error {
 	url := fmt.Sprintf("%s/cleanup", planningUrl)
-	_, err := h.H.Post(url, "application/json", nil)
+	resp, err := planningRequest(ctx, h, http.MethodPost, url, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	return resp.Body.Close()
 }
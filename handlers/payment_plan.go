@@ -2,16 +2,19 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/go-redis/cache/v8"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/auth"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
 	"github.com/jalexanderII/zero-railway/models"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/jalexanderII/zero-railway/validation"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -24,28 +27,43 @@ import (
 // @Produce json
 // @Success 200 {object} []models.PaymentPlan
 // @Router /paymentplan [post]
-func CreatePaymentPlan(h *Handler, planningUrl string, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func CreatePaymentPlan(h *Handler, planningUrl string, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
 		}
 
 		currentDate := time.Now().Format("01.02.2006")
 		var input models.GetPaymentPlanRequest
-		if err = c.BodyParser(&input); err != nil {
-			return FiberJsonResponse(c, fiber.StatusBadRequest, "error", "error parsing request ", err.Error())
+		if err = validation.ParseBody(c, &input); err != nil {
+			return err
 		}
 		input.UserId = user.GetID().Hex()
+		if err = checkPaymentPlanRequestOwnership(c.UserContext(), h, *user.GetID(), rcache, &input); err != nil {
+			return err
+		}
+
+		var transactionIds []string
+		for _, info := range input.AccountInfo {
+			transactionIds = append(transactionIds, info.TransactionIds...)
+		}
+		conflicts, err := FindTransactionsInOtherPlans(c.UserContext(), h, *user.GetID(), transactionIds, "")
+		if err != nil {
+			return apierror.Wrap(err, "error checking transactions already in plan")
+		}
+		if len(conflicts) > 0 {
+			return apierror.Conflict("transactions already covered by a payment plan", conflicts)
+		}
 
 		metaData := models.MetaData{
 			PreferredPlanType:         input.MetaData.PreferredPlanType,
 			PreferredTimelineInMonths: input.MetaData.PreferredTimelineInMonths,
 			PreferredPaymentFreq:      input.MetaData.PreferredPaymentFreq,
 		}
-		paymentPlanResponse, err := GetPaymentPlan(h, &models.GetPaymentPlanRequest{AccountInfo: input.AccountInfo, UserId: input.UserId, MetaData: metaData, SavePlan: input.SavePlan}, planningUrl)
+		paymentPlanResponse, err := GetPaymentPlan(c.UserContext(), h, &models.GetPaymentPlanRequest{AccountInfo: input.AccountInfo, UserId: input.UserId, MetaData: metaData, SavePlan: input.SavePlan}, planningUrl)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "error getting payment plan ", err.Error())
+			return apierror.Wrap(err, "error getting payment plan ")
 		}
 
 		responsePaymentPlans := make([]models.PaymentPlan, len(paymentPlanResponse.PaymentPlans))
@@ -68,26 +86,59 @@ func CreatePaymentPlan(h *Handler, planningUrl string, rcache *cache.Cache) func
 // @Produce json
 // @Success 200 {object} []models.PaymentPlan
 // @Router /paymentplan/accept [post]
-func AcceptPaymentPlan(h *Handler, planningUrl string, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func AcceptPaymentPlan(h *Handler, planningUrl string, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+
 		currentDate := time.Now().Format("01.02.2006")
 
 		acceptPaymentPlan := new(models.AcceptPaymentPlanRequest)
-		if err := c.BodyParser(acceptPaymentPlan); err != nil {
-			return FiberJsonResponse(c, fiber.StatusBadRequest, "error", "request body malformed", err.Error())
+		if err = validation.ParseBody(c, acceptPaymentPlan); err != nil {
+			return err
 		}
+		// the plan is accepted for the caller, whatever user it names
+		acceptPaymentPlan.PaymentPlan.UserId = user.GetID().Hex()
 
-		h.L.Infof("AcceptPaymentPlan %v", acceptPaymentPlan.PaymentPlan)
+		plan := acceptPaymentPlan.PaymentPlan
+		if err = checkPaymentPlanOwnership(c.UserContext(), h, *user.GetID(), rcache, &plan); err != nil {
+			return err
+		}
+		conflicts, err := FindTransactionsInOtherPlans(c.UserContext(), h, *user.GetID(), plan.Transactions, plan.PaymentPlanId)
+		if err != nil {
+			return apierror.Wrap(err, "error checking transactions already in plan")
+		}
+		if len(conflicts) > 0 {
+			return apierror.Conflict("transactions already covered by a payment plan", conflicts)
+		}
+
+		// claim the transactions before planning accepts the plan, so a concurrent accept covering any of
+		// them fails rather than both plans being accepted
+		if err = MarkTransactionsInPlan(c.UserContext(), h, *user.GetID(), plan.PaymentPlanId, plan.Transactions); err != nil {
+			return apierror.Wrap(err, "transactions already covered by a payment plan")
+		}
+
+		logging.Ctx(c).WithField("payment_plan_id", acceptPaymentPlan.PaymentPlan.PaymentPlanId).Info("accepting payment plan")
 		// send payment tasks to planning to get payment plans
 		url := fmt.Sprintf("%s/paymentplan/accept", planningUrl)
-		res, err := planningAcceptPaymentPlan(h, url, acceptPaymentPlan)
+		res, err := planningAcceptPaymentPlan(c.UserContext(), h, url, acceptPaymentPlan)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "error accepting payment plan ", err.Error())
+			releaseClaimedTransactions(c.UserContext(), h, *user.GetID(), plan.PaymentPlanId)
+			return apierror.Wrap(err, "error accepting payment plan ")
+		}
+		if err = markAcceptedPlans(c.UserContext(), h, *user.GetID(), plan.PaymentPlanId, res.PaymentPlans); err != nil {
+			// the plans can't be accepted without their transactions being covered, undo them at planning
+			abandonAcceptedPlans(c.UserContext(), h, planningUrl, *user.GetID(), plan.PaymentPlanId, res.PaymentPlans)
+			return apierror.Wrap(err, "error marking transactions as in plan")
 		}
 
 		responsePaymentPlans := make([]models.PaymentPlan, len(res.PaymentPlans))
 		for idx, paymentPlan := range res.PaymentPlans {
 			pp := CreateResponsePaymentPlan(paymentPlan)
+			_, after := auditChanges(nil, pp)
+			h.RecordAudit(c, models.AuditEvent{Action: "payment_plan.accepted", UserId: user.ID, Target: "payment_plan:" + pp.PaymentPlanId, After: after})
 			name := fmt.Sprintf("Plan_%v_%v_%v", idx+1, pp.UserId[len(pp.UserId)-4:], currentDate)
 			pp.Name = name
 			responsePaymentPlans[idx] = pp
@@ -97,6 +148,47 @@ func AcceptPaymentPlan(h *Handler, planningUrl string, rcache *cache.Cache) func
 	}
 }
 
+// markAcceptedPlans covers the transactions of the plans planning accepted. Transactions were claimed
+// under the id of the accepted plan, planning returning plans under other ids moves them over.
+func markAcceptedPlans(ctx context.Context, h *Handler, userId primitive.ObjectID, claimedPlanId string, plans []*models.PaymentPlan) error {
+	kept := false
+	for _, plan := range plans {
+		kept = kept || plan.PaymentPlanId == claimedPlanId
+	}
+	if !kept {
+		if err := ReleasePlanTransactions(ctx, h, userId, claimedPlanId); err != nil {
+			return err
+		}
+	}
+	for _, plan := range plans {
+		if err := MarkTransactionsInPlan(ctx, h, userId, plan.PaymentPlanId, plan.Transactions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abandonAcceptedPlans deletes the plans planning accepted and releases their transactions along with
+// the ones claimed for them. It is best effort, failures are logged.
+func abandonAcceptedPlans(ctx context.Context, h *Handler, planningUrl string, userId primitive.ObjectID, claimedPlanId string, plans []*models.PaymentPlan) {
+	for _, plan := range plans {
+		log := logging.FromContext(ctx).WithField("payment_plan_id", plan.PaymentPlanId)
+		res, err := planningDeletePaymentPlan(ctx, h, fmt.Sprintf("%s/paymentplan/%s", planningUrl, plan.PaymentPlanId))
+		if err != nil || res.Status != models.DELETE_STATUS_SUCCESS {
+			log.WithError(err).Error("[Planning] Error deleting payment plan whose transactions could not be marked")
+		}
+		releaseClaimedTransactions(ctx, h, userId, plan.PaymentPlanId)
+	}
+	releaseClaimedTransactions(ctx, h, userId, claimedPlanId)
+}
+
+// releaseClaimedTransactions releases the transactions claimed for a plan that was not accepted
+func releaseClaimedTransactions(ctx context.Context, h *Handler, userId primitive.ObjectID, paymentPlanId string) {
+	if err := ReleasePlanTransactions(ctx, h, userId, paymentPlanId); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("payment_plan_id", paymentPlanId).Error("[TrxnDb] Error releasing transactions of payment plan not accepted")
+	}
+}
+
 // @Summary Get payment plans for a single user.
 // @Description fetch all payment plans for the user by email.
 // @Tags paymentplan
@@ -104,18 +196,19 @@ func AcceptPaymentPlan(h *Handler, planningUrl string, rcache *cache.Cache) func
 // @Produce json
 // @Success 200 {object} []models.PaymentPlan
 // @Router /paymentplan [get]
-func GetPaymentPlans(h *Handler, planningUrl string, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func GetPaymentPlans(h *Handler, planningUrl string, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
 		}
 
 		url := fmt.Sprintf("%s/payment_plans/%s", planningUrl, user.GetID().Hex())
-		res, err := planningGetUserPaymentPlans(h, url)
+		res, err := planningGetUserPaymentPlans(c.UserContext(), h, url)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "user payment plans not found", err.Error())
+			return apierror.Wrap(err, "user payment plans not found")
 		}
+		releaseCancelledPlans(c.UserContext(), h, *user.GetID(), res.PaymentPlans)
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "user payment plans", res.PaymentPlans)
 	}
 }
@@ -132,19 +225,48 @@ func DeletePaymentPlan(h *Handler, planningUrl string) func(c *fiber.Ctx) error
 		// get the id from the request params
 		id := c.Params("id")
 		url := fmt.Sprintf("%s/paymentplan/%s", planningUrl, id)
-		res, err := planningDeletePaymentPlan(h, url)
+		res, err := planningDeletePaymentPlan(c.UserContext(), h, url)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "planning error failed to delete payment plan", err.Error())
+			return apierror.Wrap(err, "planning error failed to delete payment plan")
 		}
 		if res.Status != models.DELETE_STATUS_SUCCESS {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "delete payment plan status failed", res.Status)
+			return apierror.UpstreamPlanning(fmt.Errorf("delete payment plan status %v", res.Status))
+		}
+		// the plan is the principal's, unless an admin deletes it for its owner
+		owner := auth.Principal(c).ID
+		if res.PaymentPlan != nil {
+			if userId, err := primitive.ObjectIDFromHex(res.PaymentPlan.UserId); err == nil {
+				owner = userId
+			}
 		}
+		if err = ReleasePlanTransactions(c.UserContext(), h, owner, id); err != nil {
+			return apierror.Wrap(err, "failed releasing payment plan transactions")
+		}
+		h.RecordAudit(c, models.AuditEvent{Action: "payment_plan.deleted", UserId: owner, Target: "payment_plan:" + id})
 
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "payment plan deleted", res)
 	}
 }
 
-func GetPaymentPlan(h *Handler, in *models.GetPaymentPlanRequest, planningUrl string) (*models.PaymentPlanResponse, error) {
+// PaymentPlanOwner is the auth.Owner of paths naming a payment plan by id, they belong to the user the
+// planning service lists the plan for
+func PaymentPlanOwner(h *Handler, planningUrl string) auth.Owner {
+	return func(c *fiber.Ctx, principal *models.User, paymentPlanId string) (bool, error) {
+		url := fmt.Sprintf("%s/payment_plans/%s", planningUrl, principal.ID.Hex())
+		res, err := planningGetUserPaymentPlans(c.UserContext(), h, url)
+		if err != nil {
+			return false, err
+		}
+		for _, plan := range res.PaymentPlans {
+			if plan.PaymentPlanId == paymentPlanId {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+func GetPaymentPlan(ctx context.Context, h *Handler, in *models.GetPaymentPlanRequest, planningUrl string) (*models.PaymentPlanResponse, error) {
 	// create payment task from user inputs
 	paymentTasks := make([]models.PaymentTask, len(in.AccountInfo))
 
@@ -160,58 +282,59 @@ func GetPaymentPlan(h *Handler, in *models.GetPaymentPlanRequest, planningUrl st
 	}
 
 	// save payment tasks to DB
-	listOfIds, err := CreateManyPaymentTask(h, paymentTasks)
+	listOfIds, err := CreateManyPaymentTask(ctx, h, paymentTasks)
 	if err != nil {
-		h.L.Error("[PaymentTask] Error creating PaymentTasks ", "error", err, "paymentTasks", paymentTasks)
+		logging.FromContext(ctx).WithError(err).WithField("payment_tasks", len(paymentTasks)).Error("[PaymentTask] Error creating PaymentTasks")
 		return nil, err
 	}
 
 	for idx, id := range listOfIds {
-		pt, _ := GetPaymentTask(h, id)
+		pt, _ := GetPaymentTask(ctx, h, id)
 		paymentTasks[idx] = *pt
 	}
 
-	h.L.Infof("PaymentTasks %v", paymentTasks)
+	logging.FromContext(ctx).WithField("payment_tasks", len(paymentTasks)).Debug("creating payment plan")
 	// send payment tasks to planning to get payment plans
 	url := fmt.Sprintf("%s/paymentplan", planningUrl)
-	res, err := planningCreatePaymentPlan(h, url, &models.CreatePaymentPlanRequest{PaymentTasks: paymentTasks, MetaData: in.MetaData, SavePlan: in.SavePlan})
+	res, err := planningCreatePaymentPlan(ctx, h, url, &models.CreatePaymentPlanRequest{PaymentTasks: paymentTasks, MetaData: in.MetaData, SavePlan: in.SavePlan})
 	if err != nil {
 		return nil, err
 	}
 	return &models.PaymentPlanResponse{PaymentPlans: res.PaymentPlans}, nil
 }
 
-func planningCreatePaymentPlan(h *Handler, url string, req *models.CreatePaymentPlanRequest) (*models.PaymentPlanResponse, error) {
+func planningCreatePaymentPlan(ctx context.Context, h *Handler, url string, req *models.CreatePaymentPlanRequest) (*models.PaymentPlanResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	result, err := planningPostRequest(h, url, body)
+	result, err := planningPostRequest(ctx, h, url, body)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func planningAcceptPaymentPlan(h *Handler, url string, req *models.AcceptPaymentPlanRequest) (*models.PaymentPlanResponse, error) {
+func planningAcceptPaymentPlan(ctx context.Context, h *Handler, url string, req *models.AcceptPaymentPlanRequest) (*models.PaymentPlanResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	result, err := planningPostRequest(h, url, body)
+	result, err := planningPostRequest(ctx, h, url, body)
 	if err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
-func planningPostRequest(h *Handler, url string, body []byte) (*models.PaymentPlanResponse, error) {
-	resp, err := h.H.Post(url, "application/json", bytes.NewBuffer(body))
+func planningPostRequest(ctx context.Context, h *Handler, url string, body []byte) (*models.PaymentPlanResponse, error) {
+	resp, err := planningRequest(ctx, h, http.MethodPost, url, bytes.NewBuffer(body))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	var result models.PaymentPlanResponse
 
@@ -222,18 +345,12 @@ func planningPostRequest(h *Handler, url string, body []byte) (*models.PaymentPl
 	return &result, nil
 }
 
-func planningDeletePaymentPlan(h *Handler, url string) (*models.DeletePaymentPlanResponse, error) {
-	var body []byte
-
-	r, err := http.NewRequest(http.MethodDelete, url, bytes.NewBuffer(body))
-	if err != nil {
-		panic(err)
-	}
-
-	resp, err := h.H.Do(r)
+func planningDeletePaymentPlan(ctx context.Context, h *Handler, url string) (*models.DeletePaymentPlanResponse, error) {
+	resp, err := planningRequest(ctx, h, http.MethodDelete, url, nil)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	var result models.DeletePaymentPlanResponse
 
@@ -244,43 +361,27 @@ func planningDeletePaymentPlan(h *Handler, url string) (*models.DeletePaymentPla
 	return &result, nil
 }
 
-func CreateManyPaymentTask(h *Handler, in []models.PaymentTask) ([]string, error) {
-	// Map struct slice to interface slice as InsertMany accepts interface slice as parameter
-	insertableList := make([]interface{}, len(in))
-	for i, v := range in {
-		v.ID = primitive.NewObjectID()
-		insertableList[i] = v
-	}
-
-	// Perform InsertMany operation & validate against the error.
-	insertManyResult, err := h.Db.InsertMany(h.C, insertableList)
+func CreateManyPaymentTask(ctx context.Context, h *Handler, in []models.PaymentTask) ([]string, error) {
+	ids, err := h.PaymentTasks.CreateMany(ctx, in)
 	if err != nil {
 		return nil, err
 	}
 
-	resp := make([]string, len(insertManyResult.InsertedIDs))
-	for idx, id := range insertManyResult.InsertedIDs {
-		ido := id.(primitive.ObjectID)
-		resp[idx] = ido.Hex()
+	resp := make([]string, len(ids))
+	for idx, id := range ids {
+		resp[idx] = id.Hex()
 	}
 
 	// Return success without any error.
 	return resp, nil
 }
 
-func GetPaymentTask(h *Handler, ID string) (*models.PaymentTask, error) {
-	var paymentTask models.PaymentTask
+func GetPaymentTask(ctx context.Context, h *Handler, ID string) (*models.PaymentTask, error) {
 	id, err := primitive.ObjectIDFromHex(ID)
 	if err != nil {
 		return nil, err
 	}
-
-	filter := bson.D{{Key: "_id", Value: id}}
-	err = h.Db.FindOne(h.C, filter).Decode(&paymentTask)
-	if err != nil {
-		return nil, err
-	}
-	return &paymentTask, nil
+	return h.PaymentTasks.Get(ctx, id)
 }
 
 // CreateResponsePaymentPlan Takes in a model and returns a serializer
@@ -311,3 +412,72 @@ func CreateResponsePaymentPlan(paymentTaskModel *models.PaymentPlan) models.Paym
 		Transactions:     paymentTaskModel.Transactions,
 	}
 }
+
+// userHoldings indexes the accounts of a user by plaid id, and their transactions by plaid id to the plaid
+// id of the account they were made on
+func userHoldings(ctx context.Context, h *Handler, userId primitive.ObjectID, rcache *caching.Store) (map[string]bool, map[string]string, error) {
+	details, err := FetchDataAndCache(ctx, h, userId, rcache, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	accounts := make(map[string]bool, len(details.Accounts))
+	for _, acc := range details.Accounts {
+		// credit accounts plaid returned no liabilities for are left out of the details as nil
+		if acc.NotNull() {
+			accounts[acc.PlaidAccountId] = true
+		}
+	}
+	transactions := make(map[string]string, len(details.Transactions))
+	for _, trxn := range details.Transactions {
+		transactions[trxn.PlaidTransactionId] = trxn.PlaidAccountId
+	}
+	return accounts, transactions, nil
+}
+
+// checkPaymentPlanRequestOwnership rejects a payment plan request referencing accounts the user does not
+// hold, or transactions that were not made on the account they are listed under
+func checkPaymentPlanRequestOwnership(ctx context.Context, h *Handler, userId primitive.ObjectID, rcache *caching.Store, input *models.GetPaymentPlanRequest) error {
+	accounts, transactions, err := userHoldings(ctx, h, userId, rcache)
+	if err != nil {
+		return apierror.Wrap(err, "failed getting user's accounts")
+	}
+
+	var fields []validation.FieldError
+	for i, info := range input.AccountInfo {
+		if !accounts[info.AccountId] {
+			fields = append(fields, notOwned(fmt.Sprintf("account_info[%d].account_id", i), "is not one of your accounts"))
+			continue
+		}
+		for j, id := range info.TransactionIds {
+			if accId, ok := transactions[id]; !ok || accId != info.AccountId {
+				fields = append(fields, notOwned(fmt.Sprintf("account_info[%d].transaction_ids[%d]", i, j), "is not a transaction of this account"))
+			}
+		}
+	}
+	return validation.Fields(fields)
+}
+
+// checkPaymentPlanOwnership rejects a payment plan whose actions or transactions are not the user's
+func checkPaymentPlanOwnership(ctx context.Context, h *Handler, userId primitive.ObjectID, rcache *caching.Store, plan *models.PaymentPlan) error {
+	accounts, transactions, err := userHoldings(ctx, h, userId, rcache)
+	if err != nil {
+		return apierror.Wrap(err, "failed getting user's accounts")
+	}
+
+	var fields []validation.FieldError
+	for i, action := range plan.PaymentAction {
+		if !accounts[action.AccountId] {
+			fields = append(fields, notOwned(fmt.Sprintf("payment_plan.payment_action[%d].account_id", i), "is not one of your accounts"))
+		}
+	}
+	for i, id := range plan.Transactions {
+		if _, ok := transactions[id]; !ok {
+			fields = append(fields, notOwned(fmt.Sprintf("payment_plan.transactions[%d]", i), "is not one of your transactions"))
+		}
+	}
+	return validation.Fields(fields)
+}
+
+func notOwned(field, message string) validation.FieldError {
+	return validation.FieldError{Field: field, Rule: "owned", Message: message}
+}
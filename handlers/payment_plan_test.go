@@ -0,0 +1,138 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/plaid/plaid-go/plaid"
+)
+
+func TestCreatePaymentPlanOwnership(t *testing.T) {
+	tests := []struct {
+		name   string
+		info   models.AccountInfo
+		status int
+	}{
+		{name: "owned transaction", info: models.AccountInfo{AccountId: "alice-card", TransactionIds: []string{"alice-t1"}, Amount: 42.5}, status: http.StatusOK},
+		{name: "owned account without transactions", info: models.AccountInfo{AccountId: "alice-card", Amount: 100}, status: http.StatusOK},
+		{name: "other user's account", info: models.AccountInfo{AccountId: "bob-card", Amount: 15}, status: http.StatusBadRequest},
+		{name: "other user's transaction", info: models.AccountInfo{AccountId: "alice-card", TransactionIds: []string{"bob-t1"}, Amount: 15}, status: http.StatusBadRequest},
+		{name: "transaction of another account", info: models.AccountInfo{AccountId: "alice-card", TransactionIds: []string{"alice-store-t1"}, Amount: 10}, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, _ := newSeededServer(t)
+			// a card plaid returns no liabilities for is left out of the account details as nil
+			ts.plaid.items["access-alice"].accounts = append(ts.plaid.items["access-alice"].accounts, plaid.AccountBase{AccountId: "alice-store", Name: "Store Card", Type: plaid.ACCOUNTTYPE_CREDIT})
+			ts.plaid.items["access-alice"].purchase("alice-store-t1", "alice-store", 10, 1, "Target", "GENERAL_MERCHANDISE")
+
+			request := models.GetPaymentPlanRequest{AccountInfo: []models.AccountInfo{tt.info}}
+			ts.expect(tt.status, http.MethodPost, "/api/core/paymentplan", "alice", request)
+		})
+	}
+}
+
+func TestAcceptPaymentPlanMarksTransactions(t *testing.T) {
+	ts, _ := newSeededServer(t)
+	request := models.GetPaymentPlanRequest{AccountInfo: []models.AccountInfo{{AccountId: "alice-card", TransactionIds: []string{"alice-t1"}, Amount: 42.5}}}
+	var plans []models.PaymentPlan
+	ts.expect(http.StatusOK, http.MethodPost, "/api/core/paymentplan", "alice", request).decode(t, &plans)
+	if len(plans) != 1 {
+		t.Fatalf("got %d payment plans, want 1", len(plans))
+	}
+
+	ts.expect(http.StatusOK, http.MethodPost, "/api/planning/accept", "alice", models.AcceptPaymentPlanRequest{PaymentPlan: plans[0]})
+
+	var page struct {
+		Transactions []models.Transaction `json:"transactions"`
+	}
+	ts.expect(http.StatusOK, http.MethodGet, "/api/core/transactions/query?in_plan=true", "alice", nil).decode(t, &page)
+	if len(page.Transactions) != 1 || page.Transactions[0].PlaidTransactionId != "alice-t1" {
+		t.Fatalf("got transactions in plan %+v, want alice-t1", page.Transactions)
+	}
+
+	// the transaction can't be planned twice
+	ts.expect(http.StatusConflict, http.MethodPost, "/api/core/paymentplan", "alice", request)
+}
+
+// createPlan asks planning for a plan covering the transactions of alice's card
+func createPlan(t *testing.T, ts *testServer, transactionIds ...string) models.PaymentPlan {
+	t.Helper()
+	request := models.GetPaymentPlanRequest{AccountInfo: []models.AccountInfo{{AccountId: "alice-card", TransactionIds: transactionIds, Amount: 100}}}
+	var plans []models.PaymentPlan
+	ts.expect(http.StatusOK, http.MethodPost, "/api/core/paymentplan", "alice", request).decode(t, &plans)
+	if len(plans) != 1 {
+		t.Fatalf("got %d payment plans, want 1", len(plans))
+	}
+	return plans[0]
+}
+
+// inPlan returns the plaid ids of alice's transactions covered by a plan
+func inPlan(t *testing.T, ts *testServer) []string {
+	t.Helper()
+	var page struct {
+		Transactions []models.Transaction `json:"transactions"`
+	}
+	ts.expect(http.StatusOK, http.MethodGet, "/api/core/transactions/query?in_plan=true&sort=amount&order=asc", "alice", nil).decode(t, &page)
+	ids := make([]string, len(page.Transactions))
+	for idx, trxn := range page.Transactions {
+		ids[idx] = trxn.PlaidTransactionId
+	}
+	return ids
+}
+
+func TestAcceptPaymentPlanReleasesTransactionsWhenPlanningFails(t *testing.T) {
+	ts, _ := newSeededServer(t)
+	plan := createPlan(t, ts, "alice-t1", "alice-t2")
+
+	ts.planning.failAccept = true
+	resp := ts.expect(http.StatusBadGateway, http.MethodPost, "/api/planning/accept", "alice", models.AcceptPaymentPlanRequest{PaymentPlan: plan})
+	var failed struct {
+		Code apierror.Code `json:"code"`
+	}
+	if err := json.Unmarshal(resp.body, &failed); err != nil || failed.Code != apierror.CodePlanningFailed {
+		t.Fatalf("got error %s, want %s", resp.body, apierror.CodePlanningFailed)
+	}
+	if ids := inPlan(t, ts); len(ids) != 0 {
+		t.Fatalf("got transactions %v in plan after a failed accept, want none", ids)
+	}
+
+	ts.planning.failAccept = false
+	ts.expect(http.StatusOK, http.MethodPost, "/api/planning/accept", "alice", models.AcceptPaymentPlanRequest{PaymentPlan: plan})
+	if ids := inPlan(t, ts); len(ids) != 2 {
+		t.Fatalf("got transactions %v in plan, want alice-t1 and alice-t2", ids)
+	}
+}
+
+func TestAcceptPaymentPlanConflicts(t *testing.T) {
+	ts, _ := newSeededServer(t)
+	first := createPlan(t, ts, "alice-t1")
+	second := createPlan(t, ts, "alice-t1", "alice-t2")
+
+	ts.expect(http.StatusOK, http.MethodPost, "/api/planning/accept", "alice", models.AcceptPaymentPlanRequest{PaymentPlan: first})
+	ts.expect(http.StatusConflict, http.MethodPost, "/api/planning/accept", "alice", models.AcceptPaymentPlanRequest{PaymentPlan: second})
+	// accepting the same plan again is not a conflict
+	ts.expect(http.StatusOK, http.MethodPost, "/api/planning/accept", "alice", models.AcceptPaymentPlanRequest{PaymentPlan: first})
+
+	if ids := inPlan(t, ts); len(ids) != 1 || ids[0] != "alice-t1" {
+		t.Fatalf("got transactions %v in plan, want alice-t1 only", ids)
+	}
+}
+
+func TestDeletePaymentPlanReleasesTransactions(t *testing.T) {
+	ts, _ := newSeededServer(t)
+	plan := createPlan(t, ts, "alice-t1")
+	ts.expect(http.StatusOK, http.MethodPost, "/api/planning/accept", "alice", models.AcceptPaymentPlanRequest{PaymentPlan: plan})
+
+	ts.expect(http.StatusNotFound, http.MethodPost, "/api/core/paymentplan/delete/"+plan.PaymentPlanId, "bob", nil)
+	if ids := inPlan(t, ts); len(ids) != 1 {
+		t.Fatalf("got transactions %v in plan after another user's delete, want alice-t1", ids)
+	}
+	ts.expect(http.StatusOK, http.MethodPost, "/api/core/paymentplan/delete/"+plan.PaymentPlanId, "alice", nil)
+	if ids := inPlan(t, ts); len(ids) != 0 {
+		t.Fatalf("got transactions %v in plan after deleting it, want none", ids)
+	}
+}
@@ -1,11 +1,9 @@
 package handlers
 
 import (
-	"github.com/go-redis/cache/v8"
 	"github.com/gofiber/fiber/v2"
-	"github.com/jalexanderII/zero-railway/models"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/caching"
 )
 
 // @Summary Get payment_tasks for a single user.
@@ -15,23 +13,16 @@ import (
 // @Produce json
 // @Success 200 {object} []models.PaymentTask
 // @Router /payment_tasks [get]
-func GetUsersPaymentTasks(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func GetUsersPaymentTasks(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
 		}
 
-		paymentTasks := make([]models.PaymentTask, 0)
-		filter := bson.M{"user_id": user.ID}
-		opts := options.Find().SetSkip(0).SetLimit(1000)
-		cursor, err := h.Db.Find(h.C, filter, opts)
+		paymentTasks, err := h.PaymentTasks.ListByUser(c.UserContext(), user.ID)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusNotFound, "error", "payment tasks for that user not found", err.Error())
-		}
-
-		if err = cursor.All(h.C, &paymentTasks); err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed to unmarshall payment tasks", err)
+			return apierror.Wrap(err, "payment tasks for that user not found")
 		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "user payment tasks", paymentTasks)
 	}
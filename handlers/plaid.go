@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
-	"github.com/go-redis/cache/v8"
+	"github.com/jalexanderII/zero-railway/apierror"
 	client "github.com/jalexanderII/zero-railway/app/clients"
+	"github.com/jalexanderII/zero-railway/auth"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
 	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/validation"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -31,38 +36,43 @@ func Link(c *fiber.Ctx) error {
 	})
 }
 
-func CreateLinkToken(plaidClient *client.PlaidClient) func(c *fiber.Ctx) error {
+func CreateLinkToken(h *Handler) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		type LinkTokenResponse struct {
 			Token string `json:"link_token"`
 		}
 
 		type Input struct {
-			UserId  string `json:"user_id"`
-			Purpose string `json:"purpose"`
+			UserId  string `json:"user_id" validate:"required"`
+			Purpose string `json:"purpose" validate:"required,purpose"`
 		}
 		var input Input
-		if err := c.BodyParser(&input); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(err.Error())
+		if err := validation.ParseBody(c, &input); err != nil {
+			return err
 		}
-		user, err := plaidClient.GetUserByClarkId(input.UserId)
+		user, err := h.Users.GetByClerkId(c.UserContext(), input.UserId)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "Failure to create link token", err.Error())
+			logging.Ctx(c).WithError(err).WithField("clerk_id", input.UserId).Error("failed to get a user")
+			return apierror.Wrap(err, "Failure to create link token")
+		}
+
+		if err = auth.ActAs(c, user.ID); err != nil {
+			return err
 		}
 
-		linkTokenResp, err := plaidClient.LinkTokenCreate(user.Email, input.Purpose)
+		linkTokenResp, err := h.P.LinkTokenCreate(c.UserContext(), user, input.Purpose)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "Failure to create link token", err.Error())
+			return apierror.Wrap(err, "Failure to create link token")
 		}
 
 		CreateCookie(c, fmt.Sprintf("%v_link_token", user.Email), linkTokenResp.Token)
 		CreateCookie(c, user.Email, linkTokenResp.UserId)
 		id, err := primitive.ObjectIDFromHex(linkTokenResp.UserId)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "Failure to get ObjectId from Hex", err.Error())
+			return apierror.Wrap(err, "Failure to get ObjectId from Hex")
 		}
 
-		plaidClient.SetLinkToken(&models.Token{
+		h.P.SetLinkToken(&models.Token{
 			User:  &models.User{ID: id, Email: user.Email},
 			Value: linkTokenResp.Token,
 		})
@@ -72,9 +82,9 @@ func CreateLinkToken(plaidClient *client.PlaidClient) func(c *fiber.Ctx) error {
 }
 
 type Input struct {
-	UserId      string                  `json:"user_id"`
-	PublicToken string                  `json:"public_token"`
-	Purpose     models.Purpose          `json:"purpose"`
+	UserId      string                  `json:"user_id" validate:"required"`
+	PublicToken string                  `json:"public_token" validate:"required"`
+	Purpose     models.Purpose          `json:"purpose" validate:"required,purpose"`
 	Institution models.PlaidInstitution `json:"institution,omitempty"`
 }
 
@@ -92,49 +102,60 @@ type Response struct {
 // @Produce json
 // @Success 200 {object} Response
 // @Router /exchange [post]
-func ExchangePublicToken(plaidClient *client.PlaidClient, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func ExchangePublicToken(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		var input Input
 		if err := c.BodyParser(&input); err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "Failure to parse input", err.Error())
+			return apierror.Validation("request body malformed", err.Error())
 		}
 		if strings.HasPrefix(input.UserId, "public") {
 			temp := input.UserId
 			input.UserId = input.PublicToken
 			input.PublicToken = temp
-			plaidClient.L.Info("INPUT: ", input)
 		}
-		plaidClient.L.Info("METADATA DATA: ", input.Institution)
+		// validated once the tokens are in place
+		if err := validation.Struct(&input); err != nil {
+			return err
+		}
+		logging.Ctx(c).WithField("institution", input.Institution).Info("exchanging public token")
 
-		user, err := plaidClient.GetUserByClarkId(input.UserId)
+		user, err := h.Users.GetByClerkId(c.UserContext(), input.UserId)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "Failure to get user for token", err.Error())
+			logging.Ctx(c).WithError(err).WithField("clerk_id", input.UserId).Error("failed to get a user")
+			return apierror.Wrap(err, "Failure to get user for token")
 		}
 
-		token, err := plaidClient.ExchangePublicToken(plaidClient.C, input.PublicToken)
+		if err = auth.ActAs(c, user.ID); err != nil {
+			return err
+		}
+
+		token, err := h.P.ExchangePublicToken(c.UserContext(), input.PublicToken)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "Failure to exchange for token", err.Error())
+			return apierror.Wrap(err, "Failure to exchange for token")
 		}
 
-		token.User = user
+		token.User = &models.User{ID: *user.GetID(), Username: user.Username, Email: user.Email}
 		token.Institution = input.Institution.Name
 		token.InstitutionID = input.Institution.InstitutionId
 		token.Purpose = input.Purpose
-		plaidClient.L.Info("TOKEN: ", token)
+		logging.Ctx(c).WithField("item_id", token.ItemId).Info("public token exchanged")
 
-		if err = plaidClient.SaveToken(token); err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "Failure to save token", err.Error())
+		if err = h.Tokens.Create(c.UserContext(), token); err != nil {
+			logging.Ctx(c).WithError(err).Error("Error inserting new Token")
+			return apierror.Wrap(err, "Failure to save token")
 		}
+		h.RecordAudit(c, models.AuditEvent{
+			Action: "plaid.item.linked",
+			UserId: user.ID,
+			Target: "item:" + token.ItemId,
+			After:  map[string]any{"institution": token.Institution, "institution_id": token.InstitutionID, "purpose": string(token.Purpose)},
+		})
 
 		//err = GetandSaveAccountDetails(plaidClient, token, c, rcache)
 		//if err != nil {
-		//	return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "Failure to get and save account details", err.Error())
+		//	return apierror.Wrap(err, "Failure to get and save account details")
 		//}
 
-		err = plaidClient.ClearCache(*user.GetID(), rcache)
-		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "Failure to clear catch after saving new tokens", err.Error())
-		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "Access token created successfully", Response{token.Value, token.ItemId, input})
 	}
 }
@@ -147,34 +168,34 @@ func ExchangePublicToken(plaidClient *client.PlaidClient, rcache *cache.Cache) f
 // @Produce json
 // @Success 200 {object} models.AccountDetailsResponse
 // @Router /accounts [get]
-func GetAccountInfo(plaidClient *client.PlaidClient, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func GetAccountInfo(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
 		}
 
-		AccountDetails, err := FetchDataAndCache(*user.GetID(), plaidClient, rcache, false)
+		AccountDetails, err := FetchDataAndCache(c.UserContext(), h, *user.GetID(), rcache, false)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failure to get account details", "data": err.Error()})
+			return apierror.Wrap(err, "Failure to get account details")
 		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "Fetched all account details from cache", AccountDetails)
 	}
 }
 
-func GetandSaveAccountDetails(plaidClient *client.PlaidClient, token *models.Token, c *fiber.Ctx, rcache *cache.Cache) error {
-	_, err := FetchDataAndCache(token.User.ID, plaidClient, rcache, true)
+func GetandSaveAccountDetails(h *Handler, token *models.Token, c *fiber.Ctx, rcache *caching.Store) error {
+	_, err := FetchDataAndCache(c.UserContext(), h, token.User.ID, rcache, true)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failure to get account details", "data": err.Error()})
+		return apierror.Wrap(err, "Failure to get account details")
 	}
 	return nil
 }
 
-func ArePlaidAccountsLinked(plaidClient *client.PlaidClient, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func ArePlaidAccountsLinked(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
 		}
 
 		type Exist struct {
@@ -182,28 +203,28 @@ func ArePlaidAccountsLinked(plaidClient *client.PlaidClient, rcache *cache.Cache
 			Credit bool `json:"credit"`
 		}
 
-		debitAcc, err := IsDebitAccountLinked(user.GetID(), plaidClient, rcache)
+		debitAcc, err := IsDebitAccountLinked(c.UserContext(), h, user.GetID(), rcache)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Error on fetching user's credit accounts", "data": err.Error()})
+			return apierror.Wrap(err, "Error on fetching user's credit accounts")
 		}
-		creditAcc, err := IsCreditAccountLinked(user.GetID(), plaidClient, rcache)
+		creditAcc, err := IsCreditAccountLinked(c.UserContext(), h, user.GetID(), rcache)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Error on fetching user's credit accounts", "data": err.Error()})
+			return apierror.Wrap(err, "Error on fetching user's credit accounts")
 		}
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": Exist{debitAcc.Status, creditAcc.Status}})
 	}
 }
 
-func IsDebitAccountLinked(userId *primitive.ObjectID, p *client.PlaidClient, rcache *cache.Cache) (*models.IsAccountLinkedResponse, error) {
-	return AccountLinked(userId, p, rcache, "depository")
+func IsDebitAccountLinked(ctx context.Context, h *Handler, userId *primitive.ObjectID, rcache *caching.Store) (*models.IsAccountLinkedResponse, error) {
+	return AccountLinked(ctx, h, userId, rcache, "depository")
 }
 
-func IsCreditAccountLinked(userId *primitive.ObjectID, p *client.PlaidClient, rcache *cache.Cache) (*models.IsAccountLinkedResponse, error) {
-	return AccountLinked(userId, p, rcache, "credit")
+func IsCreditAccountLinked(ctx context.Context, h *Handler, userId *primitive.ObjectID, rcache *caching.Store) (*models.IsAccountLinkedResponse, error) {
+	return AccountLinked(ctx, h, userId, rcache, "credit")
 }
 
-func AccountLinked(userId *primitive.ObjectID, p *client.PlaidClient, rcache *cache.Cache, accType string) (*models.IsAccountLinkedResponse, error) {
-	Accounts, err := FetchAccountDetails(*userId, p, rcache)
+func AccountLinked(ctx context.Context, h *Handler, userId *primitive.ObjectID, rcache *caching.Store, accType string) (*models.IsAccountLinkedResponse, error) {
+	Accounts, err := FetchAccountDetails(ctx, h, *userId, rcache)
 	if err != nil {
 		return nil, err
 	}
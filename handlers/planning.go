@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-
-	"github.com/go-redis/cache/v8"
+	"io"
+	"net/http"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
 	"github.com/jalexanderII/zero-railway/models"
 )
 
@@ -27,15 +32,15 @@ type KPI struct {
 // @Produce json
 // @Success 200 {object} KPI
 // @Router /kpi [get]
-func GetKPIs(h *Handler, planningUrl string, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func GetKPIs(h *Handler, planningUrl string, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
 		}
-		accounts, err := GetUserAccounts(h, user.GetID(), rcache)
+		accounts, err := GetUserAccounts(c.UserContext(), h, user.GetID(), rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusNotFound, "error", "user accounts not found", err.Error())
+			return apierror.Wrap(err, "user accounts not found")
 		}
 
 		totalCredit := 0.0
@@ -46,16 +51,16 @@ func GetKPIs(h *Handler, planningUrl string, rcache *cache.Cache) func(c *fiber.
 		}
 
 		var totalDebit = 0.0
-		debitAccBalance := GetDebitAccountBalance(h, user.GetID(), rcache)
+		debitAccBalance := GetDebitAccountBalance(c.UserContext(), h, user.GetID(), rcache)
 		if debitAccBalance != nil {
 			totalDebit = debitAccBalance.CurrentBalance
 		}
 
 		var totalPlanAmount = 0.0
 		url := fmt.Sprintf("%s/payment_plans/%s", planningUrl, user.GetID().Hex())
-		plans, err := planningGetUserPaymentPlans(h, url)
+		plans, err := planningGetUserPaymentPlans(c.UserContext(), h, url)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "user payment plans for KPI not found", err.Error())
+			return apierror.Wrap(err, "user payment plans for KPI not found")
 		}
 		for _, plan := range plans.PaymentPlans {
 			totalPlanAmount += plan.Amount
@@ -68,18 +73,41 @@ func GetKPIs(h *Handler, planningUrl string, rcache *cache.Cache) func(c *fiber.
 	}
 }
 
-func planningGetUserPaymentPlans(h *Handler, url string) (*ListPaymentPlanResponse, error) {
-	resp, err := h.H.Get(url)
+// planningRequest sends a request to the planning service, it is abandoned once ctx is done. A response
+// that is not 2xx is closed and returned as an upstream error.
+func planningRequest(ctx context.Context, h *Handler, method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := h.H.Do(req)
+	if err != nil {
+		return nil, apierror.UpstreamPlanning(err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, apierror.UpstreamPlanning(fmt.Errorf("%s %s: status %d: %s", method, req.URL.Path, resp.StatusCode, bytes.TrimSpace(msg)))
+	}
+	return resp, nil
+}
+
+func planningGetUserPaymentPlans(ctx context.Context, h *Handler, url string) (*ListPaymentPlanResponse, error) {
+	resp, err := planningRequest(ctx, h, http.MethodGet, url, nil)
 	if err != nil {
-		h.L.Error("Error fetching user payment plans ", "error ", err.Error())
+		logging.FromContext(ctx).WithError(err).Error("Error fetching user payment plans")
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	var result ListPaymentPlanResponse
 
 	err = json.NewDecoder(resp.Body).Decode(&result)
 	if err != nil {
-		h.L.Error("Error decoding user payment plans for KPI ", "error ", err.Error())
+		logging.FromContext(ctx).WithError(err).Error("Error decoding user payment plans for KPI")
 		return nil, err
 	}
 	return &result, nil
@@ -106,16 +134,16 @@ type Series struct {
 // @Produce json
 // @Success 200 {object} Series
 // @Router /waterfall [get]
-func GetWaterfall(h *Handler, planningUrl string, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func GetWaterfall(h *Handler, planningUrl string, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
 		}
 
-		accounts, err := GetUserAccounts(h, user.GetID(), rcache)
+		accounts, err := GetUserAccounts(c.UserContext(), h, user.GetID(), rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting users accounts", err.Error())
+			return apierror.Wrap(err, "failed getting users accounts")
 		}
 		accountIdToName := make(map[string]string, len(accounts))
 		for _, account := range accounts {
@@ -124,9 +152,9 @@ func GetWaterfall(h *Handler, planningUrl string, rcache *cache.Cache) func(c *f
 
 		url := fmt.Sprintf("%s/waterfall/%s", planningUrl, user.GetID().Hex())
 
-		overview, err := planningGetWaterfall(h, url)
+		overview, err := planningGetWaterfall(c.UserContext(), h, url)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "Error fetching user's waterfall", err.Error())
+			return apierror.Wrap(err, "Error fetching user's waterfall")
 		}
 
 		accountSeries := make(map[string]Series)
@@ -154,11 +182,12 @@ func GetWaterfall(h *Handler, planningUrl string, rcache *cache.Cache) func(c *f
 	}
 }
 
-func planningGetWaterfall(h *Handler, url string) (*WaterfallOverviewResponse, error) {
-	resp, err := h.H.Get(url)
+func planningGetWaterfall(ctx context.Context, h *Handler, url string) (*WaterfallOverviewResponse, error) {
+	resp, err := planningRequest(ctx, h, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	var result WaterfallOverviewResponse
 
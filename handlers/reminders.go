@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	client "github.com/jalexanderII/zero-railway/app/clients"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"github.com/jalexanderII/zero-railway/validation"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DueDateReminder texts users ahead of their card statement due dates using the liabilities stored
+// for their accounts, independently of any payment plan
+type DueDateReminder struct {
+	H *Handler
+	T *client.TwilioClient
+}
+
+func NewDueDateReminder(h *Handler, tc *client.TwilioClient) *DueDateReminder {
+	return &DueDateReminder{H: h, T: tc}
+}
+
+// @Summary Remind all users of upcoming statement due dates.
+// @Description Check every stored credit account and remind users of due dates within their configured lead times, escalating for overdue accounts.
+// @Tags notify
+// @Accept */*
+// @Produce json
+// @Success 200 {object} []models.SendSMSResponse
+// @Router /admin/jobs/due_date_reminders [post]
+func NotifyUsersUpcomingDueDates(r *DueDateReminder) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		resps, err := r.Run(c.UserContext(), time.Now())
+		if err != nil {
+			logging.Ctx(c).WithError(err).Error("[Reminder] error sending due date reminders")
+			return apierror.Wrap(err, "error sending due date reminders")
+		}
+		r.H.RecordAudit(c, models.AuditEvent{Action: "notification.due_date_reminders.sent", Metadata: map[string]any{"sent": len(resps)}})
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "successfully reminded users", resps)
+	}
+}
+
+// Run sends every reminder due as of now, batching each user's accounts into a single SMS
+func (r *DueDateReminder) Run(ctx context.Context, now time.Time) ([]models.SendSMSResponse, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	accounts, err := r.H.Accounts.ListWithDueDates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userAccounts := make(map[primitive.ObjectID][]models.Account)
+	for _, acc := range accounts {
+		userAccounts[acc.UserId] = append(userAccounts[acc.UserId], acc)
+	}
+
+	resps := make([]models.SendSMSResponse, 0)
+	for userId, accs := range userAccounts {
+		user, err := r.H.GetUserByID(ctx, userId.Hex())
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if user.PhoneNumber == "" {
+			continue
+		}
+
+		lines, reminders, err := r.userReminders(ctx, user, accs, today)
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		resp, err := r.T.SendSMS(ctx, user.PhoneNumber, strings.Join(lines, "\n"))
+		resps = append(resps, *resp)
+		if err != nil {
+			// left unrecorded so the next run reminds again
+			logging.FromContext(ctx).WithError(err).WithField("user_id", userId.Hex()).Error("[Reminder] error sending SMS")
+			continue
+		}
+		for _, reminder := range reminders {
+			if _, err = r.H.Reminders.Record(ctx, reminder); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resps, nil
+}
+
+// userReminders returns a line for every account of the user that needs a reminder today, along with
+// the reminders to record once they are sent
+func (r *DueDateReminder) userReminders(ctx context.Context, user *models.User, accounts []models.Account, today time.Time) ([]string, []*models.DueDateReminder, error) {
+	prefs := user.GetNotificationPreferences()
+	leadTimes := append([]int(nil), prefs.ReminderDays...)
+	sort.Ints(leadTimes)
+
+	var lines []string
+	var reminders []*models.DueDateReminder
+	for _, acc := range accounts {
+		if acc.IsOverdue {
+			if !prefs.OverdueAlerts {
+				continue
+			}
+			reminder := dueDateReminder(user.ID, &acc, models.ReminderKindOverdue)
+			sent, err := r.H.Reminders.Sent(ctx, reminder)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !sent {
+				reminders = append(reminders, reminder)
+				lines = append(lines, fmt.Sprintf("URGENT: your %s payment is overdue. Pay at least the $%.2f minimum as soon as possible to avoid further fees.",
+					accountName(&acc), acc.MinimumPaymentAmount))
+			}
+			continue
+		}
+
+		if !prefs.DueDateReminders {
+			continue
+		}
+		due, err := time.Parse("2006-01-02", acc.NextPaymentDueDate)
+		if err != nil {
+			continue
+		}
+		daysLeft := int(due.Sub(today).Hours() / 24)
+		if daysLeft < 0 {
+			continue
+		}
+		// remind with the closest lead time that has been reached, so a missed run still reminds
+		for _, lead := range leadTimes {
+			if daysLeft > lead {
+				continue
+			}
+			reminder := dueDateReminder(user.ID, &acc, fmt.Sprintf("%dd", lead))
+			sent, err := r.H.Reminders.Sent(ctx, reminder)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !sent {
+				reminders = append(reminders, reminder)
+				lines = append(lines, dueDateMessage(&acc, due, daysLeft))
+			}
+			break
+		}
+	}
+	return lines, reminders, nil
+}
+
+func dueDateReminder(userId primitive.ObjectID, acc *models.Account, kind string) *models.DueDateReminder {
+	return &models.DueDateReminder{
+		UserId:    userId,
+		AccountId: acc.PlaidAccountId,
+		DueDate:   acc.NextPaymentDueDate,
+		Kind:      kind,
+	}
+}
+
+func dueDateMessage(acc *models.Account, due time.Time, daysLeft int) string {
+	when := fmt.Sprintf("in %d days", daysLeft)
+	switch daysLeft {
+	case 0:
+		when = "today"
+	case 1:
+		when = "tomorrow"
+	}
+	return fmt.Sprintf("Your %s payment is due %s (%s). Minimum payment: $%.2f, statement balance: $%.2f.",
+		accountName(acc), when, due.Format("Jan 2"), acc.MinimumPaymentAmount, acc.LastStatementBalance)
+}
+
+// @Summary Update a users notification preferences.
+// @Description update which due date reminders the user receives.
+// @Tags users
+// @Accept json
+// @Param input body models.NotificationPreferences true "Notification preferences"
+// @Produce json
+// @Success 200 {object} UpdateResponse
+// @Router /users/notifications [put]
+func UpdateNotificationPreferences(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		prefs := new(models.NotificationPreferences)
+		if err = validation.ParseBody(c, prefs); err != nil {
+			return err
+		}
+
+		modified, err := h.Users.UpdateNotificationPreferences(c.UserContext(), *user.GetID(), prefs)
+		if err != nil {
+			return apierror.Wrap(err, "failed to update notification preferences")
+		}
+		before, after := auditChanges(user.GetNotificationPreferences(), prefs)
+		h.RecordAudit(c, models.AuditEvent{Action: "user.notification_preferences.updated", UserId: user.ID, Target: "user:" + user.ID.Hex(), Before: before, After: after})
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "updated notification preferences", UpdateResponse{modified})
+	}
+}
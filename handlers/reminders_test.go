@@ -0,0 +1,50 @@
+package handlers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/handlers"
+	"github.com/jalexanderII/zero-railway/models"
+)
+
+func TestDueDateRemindersAreRecordedOnceSent(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestServer(t)
+	user := ts.addUser("alice")
+	now := time.Now().UTC()
+	accounts := []*models.Account{
+		{UserId: user.ID, PlaidAccountId: "card", Name: "Card", Type: "credit", NextPaymentDueDate: now.AddDate(0, 0, 2).Format("2006-01-02"), MinimumPaymentAmount: 25},
+		{UserId: user.ID, PlaidAccountId: "late-card", Name: "Late Card", Type: "credit", IsOverdue: true, MinimumPaymentAmount: 40},
+	}
+	if err := ts.repos.Accounts.Upsert(ctx, accounts); err != nil {
+		t.Fatal(err)
+	}
+	reminder := handlers.NewDueDateReminder(handlers.NewHandler(ts.repos, nil), ts.twilio)
+
+	ts.sms.fail = true
+	resps, err := reminder.Run(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resps) != 1 || resps[0].Successful {
+		t.Fatalf("got responses %+v, want one failed SMS", resps)
+	}
+	if recorded, _ := ts.repos.Reminders.ListByUser(ctx, user.ID); len(recorded) != 0 {
+		t.Fatalf("got %d reminders recorded for a failed SMS, want none", len(recorded))
+	}
+
+	ts.sms.fail = false
+	for run := 0; run < 2; run++ {
+		if _, err = reminder.Run(ctx, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent := ts.sms.messages(); len(sent) != 1 {
+		t.Fatalf("got %d texts, want the reminders sent once", len(sent))
+	}
+	if recorded, _ := ts.repos.Reminders.ListByUser(ctx, user.ID); len(recorded) != 2 {
+		t.Fatalf("got %d reminders recorded, want the due date and overdue ones", len(recorded))
+	}
+}
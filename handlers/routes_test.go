@@ -0,0 +1,153 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jalexanderII/zero-railway/models"
+)
+
+// newSeededServer starts a server with two users holding a credit card each, and an admin
+func newSeededServer(t *testing.T) (*testServer, *strings.Replacer) {
+	ts := newTestServer(t)
+	alice := ts.addUser("alice")
+	bob := ts.addUser("bob")
+	admin := ts.addUser("admin", models.RoleAdmin)
+	ts.link(alice, "access-alice", new(fakeItem).
+		card("alice-card", "Alice Card", 500, 2000).
+		purchase("alice-t1", "alice-card", 42.5, 3, "Blue Bottle", "FOOD_AND_DRINK").
+		purchase("alice-t2", "alice-card", 120, 20, "Shell", "TRANSPORTATION"))
+	ts.link(bob, "access-bob", new(fakeItem).
+		card("bob-card", "Bob Card", 100, 1000).
+		purchase("bob-t1", "bob-card", 15, 2, "Netflix", "ENTERTAINMENT"))
+	return ts, strings.NewReplacer("{alice}", alice.ID.Hex(), "{bob}", bob.ID.Hex(), "{admin}", admin.ID.Hex())
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		clerk  string
+		body   any
+		status int
+	}{
+		{name: "root", method: http.MethodGet, path: "/", status: http.StatusOK},
+		{name: "liveness", method: http.MethodGet, path: "/health/live", status: http.StatusOK},
+		{name: "readiness", method: http.MethodGet, path: "/health/ready", status: http.StatusOK},
+
+		{name: "get signed in user", method: http.MethodGet, path: "/api/core/users", clerk: "alice", status: http.StatusOK},
+		{name: "get user anonymously", method: http.MethodGet, path: "/api/core/users", status: http.StatusUnauthorized},
+		{name: "get user of unknown clerk id", method: http.MethodGet, path: "/api/core/users", clerk: "mallory", status: http.StatusNotFound},
+		{name: "get own user by id", method: http.MethodGet, path: "/api/user/{alice}", clerk: "alice", status: http.StatusOK},
+		{name: "get other user by id", method: http.MethodGet, path: "/api/user/{alice}", clerk: "bob", status: http.StatusNotFound},
+		{name: "admin gets any user by id", method: http.MethodGet, path: "/api/user/{alice}", clerk: "admin", status: http.StatusOK},
+		{name: "update phone number", method: http.MethodPut, path: "/api/core/users", clerk: "alice", body: map[string]string{"phoneNumber": "5555550123"}, status: http.StatusOK},
+		{name: "update invalid phone number", method: http.MethodPut, path: "/api/core/users", clerk: "alice", body: map[string]string{"phoneNumber": "555"}, status: http.StatusBadRequest},
+		{name: "update notification preferences", method: http.MethodPut, path: "/api/core/users/notifications", clerk: "alice", body: map[string]any{"due_date_reminders": true, "reminder_days": []int{3}}, status: http.StatusOK},
+
+		{name: "list accounts", method: http.MethodGet, path: "/api/core/accounts/", clerk: "alice", status: http.StatusOK},
+		{name: "list own accounts by user id", method: http.MethodGet, path: "/api/core/accounts/user_id/{alice}", clerk: "alice", status: http.StatusOK},
+		{name: "list other user's accounts", method: http.MethodGet, path: "/api/core/accounts/user_id/{alice}", clerk: "bob", status: http.StatusNotFound},
+		{name: "get own account", method: http.MethodGet, path: "/api/core/accounts/acc_id/alice-card/{alice}", clerk: "alice", status: http.StatusOK},
+		{name: "get missing account", method: http.MethodGet, path: "/api/core/accounts/acc_id/bob-card/{alice}", clerk: "alice", status: http.StatusNotFound},
+		{name: "get other user's account", method: http.MethodGet, path: "/api/core/accounts/acc_id/alice-card/{alice}", clerk: "bob", status: http.StatusNotFound},
+
+		{name: "list transactions", method: http.MethodGet, path: "/api/core/transactions/", clerk: "alice", status: http.StatusOK},
+		{name: "query transactions", method: http.MethodGet, path: "/api/core/transactions/query?sort=amount&limit=1", clerk: "alice", status: http.StatusOK},
+		{name: "query transactions by invalid field", method: http.MethodGet, path: "/api/core/transactions/query?sort=name", clerk: "alice", status: http.StatusBadRequest},
+
+		{name: "spend by category", method: http.MethodGet, path: "/api/core/analytics/categories?period=90d", clerk: "alice", status: http.StatusOK},
+		{name: "spend by category over invalid period", method: http.MethodGet, path: "/api/core/analytics/categories?period=soon", clerk: "alice", status: http.StatusBadRequest},
+		{name: "spend by merchant", method: http.MethodGet, path: "/api/core/analytics/merchants", clerk: "alice", status: http.StatusOK},
+		{name: "monthly spend trends", method: http.MethodGet, path: "/api/core/analytics/trends", clerk: "alice", status: http.StatusOK},
+		{name: "recurring charges", method: http.MethodGet, path: "/api/core/analytics/recurring", clerk: "alice", status: http.StatusOK},
+
+		{name: "list budgets", method: http.MethodGet, path: "/api/core/budgets/", clerk: "alice", status: http.StatusOK},
+		{name: "create budget", method: http.MethodPost, path: "/api/core/budgets/", clerk: "alice", body: map[string]any{"name": "Dining", "category": "FOOD_AND_DRINK", "limit": 200}, status: http.StatusOK},
+		{name: "create budget on own card", method: http.MethodPost, path: "/api/core/budgets/", clerk: "alice", body: map[string]any{"name": "Card", "account_id": "alice-card", "limit": 200}, status: http.StatusOK},
+		{name: "create budget on other user's card", method: http.MethodPost, path: "/api/core/budgets/", clerk: "alice", body: map[string]any{"name": "Card", "account_id": "bob-card", "limit": 200}, status: http.StatusBadRequest},
+		{name: "create budget with blank name", method: http.MethodPost, path: "/api/core/budgets/", clerk: "alice", body: map[string]any{"name": "  ", "category": "FOOD_AND_DRINK", "limit": 200}, status: http.StatusBadRequest},
+		{name: "create budget with duplicate thresholds", method: http.MethodPost, path: "/api/core/budgets/", clerk: "alice", body: map[string]any{"name": "Dining", "category": "FOOD_AND_DRINK", "limit": 200, "thresholds": []int{50, 50}}, status: http.StatusBadRequest},
+		{name: "update budget on other user's card", method: http.MethodPut, path: "/api/core/budgets/{alice}", clerk: "alice", body: map[string]any{"name": "Card", "account_id": "bob-card", "limit": 200}, status: http.StatusBadRequest},
+		{name: "create budget without limit", method: http.MethodPost, path: "/api/core/budgets/", clerk: "alice", body: map[string]any{"name": "Dining", "category": "FOOD_AND_DRINK"}, status: http.StatusBadRequest},
+		{name: "delete missing budget", method: http.MethodDelete, path: "/api/core/budgets/{alice}", clerk: "alice", status: http.StatusNotFound},
+
+		{name: "current utilization", method: http.MethodGet, path: "/api/core/utilization/", clerk: "alice", status: http.StatusOK},
+		{name: "utilization history", method: http.MethodGet, path: "/api/core/utilization/history?days=30", clerk: "alice", status: http.StatusOK},
+		{name: "list payment tasks", method: http.MethodGet, path: "/api/core/payment_tasks/", clerk: "alice", status: http.StatusOK},
+
+		{name: "kpis", method: http.MethodGet, path: "/api/core/kpi", clerk: "alice", status: http.StatusOK},
+		{name: "list payment plans", method: http.MethodGet, path: "/api/core/paymentplan", clerk: "alice", status: http.StatusOK},
+		{name: "waterfall", method: http.MethodGet, path: "/api/planning/waterfall", clerk: "alice", status: http.StatusOK},
+		{name: "delete other user's payment plan", method: http.MethodPost, path: "/api/core/paymentplan/delete/{bob}", clerk: "alice", status: http.StatusNotFound},
+
+		{name: "plaid accounts linked", method: http.MethodGet, path: "/api/plaid/linked", clerk: "alice", status: http.StatusOK},
+		{name: "plaid account details", method: http.MethodGet, path: "/api/plaid/accounts", clerk: "alice", status: http.StatusOK},
+
+		{name: "request export", method: http.MethodPost, path: "/api/core/users/export", clerk: "alice", status: http.StatusAccepted},
+		{name: "download export with bad signature", method: http.MethodGet, path: "/exports/{alice}/download?expires=9999999999&signature=forged", status: http.StatusForbidden},
+
+		{name: "admin finds user", method: http.MethodGet, path: "/admin/users?clerk_id=alice", clerk: "admin", status: http.StatusOK},
+		{name: "user can't find users", method: http.MethodGet, path: "/admin/users?clerk_id=alice", clerk: "alice", status: http.StatusForbidden},
+		{name: "admin gets user", method: http.MethodGet, path: "/admin/users/{bob}", clerk: "admin", status: http.StatusOK},
+		{name: "admin lists item health", method: http.MethodGet, path: "/admin/items", clerk: "admin", status: http.StatusOK},
+		{name: "admin queries audit events", method: http.MethodGet, path: "/admin/audit", clerk: "admin", status: http.StatusOK},
+		{name: "admin purges expired exports", method: http.MethodPost, path: "/admin/jobs/purge_exports", clerk: "admin", status: http.StatusOK},
+		{name: "anonymous admin request", method: http.MethodGet, path: "/admin/items", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, ids := newSeededServer(t)
+			ts.expect(tt.status, tt.method, ids.Replace(tt.path), tt.clerk, tt.body)
+		})
+	}
+}
+
+func TestAccountDetailsAreTheCallers(t *testing.T) {
+	ts, _ := newSeededServer(t)
+	var details models.AccountDetailsResponse
+	ts.expect(http.StatusOK, http.MethodGet, "/api/plaid/accounts", "alice", nil).decode(t, &details)
+
+	if len(details.Accounts) != 1 || details.Accounts[0].PlaidAccountId != "alice-card" {
+		t.Fatalf("got accounts %+v, want alice-card only", details.Accounts)
+	}
+	if len(details.Transactions) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(details.Transactions))
+	}
+	if len(details.Items) != 1 || details.Items[0].Status != models.ITEM_SYNC_OK {
+		t.Fatalf("got items %+v, want one synced Item", details.Items)
+	}
+}
+
+func TestMetricsRequireToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "no token", status: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "token without scheme", authorization: "test-metrics-token", status: http.StatusUnauthorized},
+		{name: "token", authorization: "Bearer test-metrics-token", status: http.StatusOK},
+	}
+	ts := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			resp, err := ts.app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
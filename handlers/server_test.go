@@ -0,0 +1,489 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/app"
+	client "github.com/jalexanderII/zero-railway/app/clients"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/config"
+	"github.com/jalexanderII/zero-railway/handlers"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"github.com/jalexanderII/zero-railway/router"
+	"github.com/plaid/plaid-go/plaid"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testServer is the app over in memory repositories and a local cache, with plaid, the planning service
+// and twilio faked. The rate limiter allows 20 requests per app, so a test needing more starts another.
+type testServer struct {
+	t        *testing.T
+	app      *fiber.App
+	cfg      *config.Config
+	repos    *repository.Repositories
+	cache    *caching.Store
+	plaid    *fakePlaid
+	planning *fakePlanning
+	sms      *fakeSMS
+	// twilio sends its texts through sms
+	twilio *client.TwilioClient
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+
+	ts := &testServer{
+		t:        t,
+		repos:    repository.NewMemoryRepositories(),
+		cache:    caching.New(nil, 1000, time.Minute),
+		plaid:    newFakePlaid(),
+		planning: newFakePlanning(t),
+		sms:      &fakeSMS{},
+	}
+	ts.cfg = &config.Config{
+		PlanningURL:        ts.planning.URL,
+		HealthCheckTimeout: time.Second,
+		RequestTimeout:     10 * time.Second,
+		DeletionTimeout:    time.Minute,
+		SnapshotJobTimeout: time.Minute,
+		MetricsToken:       "test-metrics-token",
+		Exports: config.ExportsConfig{
+			SigningKey: "test-signing-key",
+			URLTTL:     15 * time.Minute,
+			Retention:  24 * time.Hour,
+			Timeout:    time.Minute,
+		},
+	}
+
+	ts.twilio = client.NewTwilioClient(config.TwilioConfig{AccountSid: "ACtest", AuthToken: "token", PhoneNumber: "+15555550000"})
+	ts.twilio.HTTP.Transport = ts.sms
+	ts.app = fiber.New(fiber.Config{ErrorHandler: apierror.ErrorHandler})
+	app.FiberMiddleware(ts.app, ts.cfg, l)
+	router.Register(ts.app, ts.cfg, &router.Services{
+		Repos:  ts.repos,
+		Cache:  ts.cache,
+		Plaid:  &client.PlaidClient{Name: "ZeroFintech", Client: ts.plaid, L: l},
+		Twilio: ts.twilio,
+	}, l)
+	return ts
+}
+
+// envelope is the body of every JSON response of the handlers
+type envelope struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+	Error   *struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details"`
+	} `json:"error"`
+}
+
+// response is a response of the app, with its body read
+type response struct {
+	*http.Response
+	body []byte
+}
+
+// decode unmarshals the data of the response's envelope into v
+func (r *response) decode(t *testing.T, v any) {
+	t.Helper()
+	var env envelope
+	if err := json.Unmarshal(r.body, &env); err != nil {
+		t.Fatalf("decoding response %q: %v", r.body, err)
+	}
+	if err := json.Unmarshal(env.Data, v); err != nil {
+		t.Fatalf("decoding response data %q: %v", env.Data, err)
+	}
+}
+
+// do sends a request as the user signed in with clerkId, anonymously when it is empty. A body that is not
+// a string is sent as JSON.
+func (ts *testServer) do(method, path, clerkId string, body any) *response {
+	ts.t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		payload, err := json.Marshal(b)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if clerkId != "" {
+		req.Header.Set("Clerk", clerkId)
+	}
+	resp, err := ts.app.Test(req, -1)
+	if err != nil {
+		ts.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return &response{Response: resp, body: b}
+}
+
+// expect sends a request and fails the test unless it is answered with status
+func (ts *testServer) expect(status int, method, path, clerkId string, body any) *response {
+	ts.t.Helper()
+	resp := ts.do(method, path, clerkId, body)
+	if resp.StatusCode != status {
+		ts.t.Fatalf("%s %s: got status %d, want %d: %s", method, path, resp.StatusCode, status, resp.body)
+	}
+	return resp
+}
+
+// addUser creates a user signed in with clerkId
+func (ts *testServer) addUser(clerkId string, roles ...models.Role) *models.User {
+	ts.t.Helper()
+	user := &models.User{
+		Username:    clerkId,
+		Email:       clerkId + "@example.com",
+		PhoneNumber: "+15555550100",
+		ClerkId:     clerkId,
+		Roles:       roles,
+	}
+	id, err := ts.repos.Users.Create(context.Background(), user)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	user.ID = id
+	return user
+}
+
+// link links the plaid Item to the user under accessToken
+func (ts *testServer) link(user *models.User, accessToken string, item *fakeItem) *models.Token {
+	ts.t.Helper()
+	token := &models.Token{
+		User:        user,
+		Value:       accessToken,
+		ItemId:      "item-" + accessToken,
+		Institution: "Test Bank",
+		Purpose:     models.PURPOSE_CREDIT,
+	}
+	if err := ts.repos.Tokens.Create(context.Background(), token); err != nil {
+		ts.t.Fatal(err)
+	}
+	ts.plaid.set(accessToken, item)
+	return token
+}
+
+// fakeItem is what plaid returns for an Item
+type fakeItem struct {
+	accounts     []plaid.AccountBase
+	liabilities  []plaid.CreditCardLiability
+	transactions []plaid.Transaction
+	// err fails every call made for the Item
+	err error
+}
+
+// card adds a credit card account to the Item
+func (i *fakeItem) card(accountId, name string, balance, limit float32) *fakeItem {
+	i.accounts = append(i.accounts, plaid.AccountBase{
+		AccountId: accountId,
+		Name:      name,
+		Type:      plaid.ACCOUNTTYPE_CREDIT,
+		Balances: plaid.AccountBalance{
+			Current: *plaid.NewNullableFloat32(plaid.PtrFloat32(balance)),
+			Limit:   *plaid.NewNullableFloat32(plaid.PtrFloat32(limit)),
+		},
+	})
+	i.liabilities = append(i.liabilities, plaid.CreditCardLiability{
+		AccountId:              *plaid.NewNullableString(plaid.PtrString(accountId)),
+		LastStatementIssueDate: time.Now().AddDate(0, 0, -10).Format("2006-01-02"),
+		MinimumPaymentAmount:   25,
+		NextPaymentDueDate:     *plaid.NewNullableString(plaid.PtrString(time.Now().AddDate(0, 0, 15).Format("2006-01-02"))),
+	})
+	return i
+}
+
+// purchase adds a transaction made daysAgo on the account to the Item
+func (i *fakeItem) purchase(transactionId, accountId string, amount float32, daysAgo int, merchant, category string) *fakeItem {
+	i.transactions = append(i.transactions, plaid.Transaction{
+		TransactionId: transactionId,
+		AccountId:     accountId,
+		Amount:        amount,
+		Date:          time.Now().AddDate(0, 0, -daysAgo).Format("2006-01-02"),
+		Name:          merchant,
+		MerchantName:  *plaid.NewNullableString(plaid.PtrString(merchant)),
+		PersonalFinanceCategory: *plaid.NewNullablePersonalFinanceCategory(&plaid.PersonalFinanceCategory{
+			Primary:  category,
+			Detailed: category + "_OTHER",
+		}),
+	})
+	return i
+}
+
+// fakePlaid implements client.PlaidAPI over the Items it holds, by access token
+type fakePlaid struct {
+	mu      sync.Mutex
+	items   map[string]*fakeItem
+	removed []string
+	// removeErr fails the removal of Items
+	removeErr error
+}
+
+func newFakePlaid() *fakePlaid {
+	return &fakePlaid{items: make(map[string]*fakeItem)}
+}
+
+func (f *fakePlaid) set(accessToken string, item *fakeItem) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[accessToken] = item
+}
+
+func (f *fakePlaid) item(accessToken string) (*fakeItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.items[accessToken]
+	if !ok {
+		return nil, errors.New("plaid error, code: INVALID_ACCESS_TOKEN, message: unknown access token")
+	}
+	if item.err != nil {
+		return nil, item.err
+	}
+	return item, nil
+}
+
+func (f *fakePlaid) LinkTokenCreate(context.Context, plaid.LinkTokenCreateRequest) (plaid.LinkTokenCreateResponse, error) {
+	return plaid.LinkTokenCreateResponse{LinkToken: "link-sandbox-token"}, nil
+}
+
+func (f *fakePlaid) ItemPublicTokenExchange(_ context.Context, req plaid.ItemPublicTokenExchangeRequest) (plaid.ItemPublicTokenExchangeResponse, error) {
+	return plaid.ItemPublicTokenExchangeResponse{AccessToken: "access-" + req.PublicToken, ItemId: "item-" + req.PublicToken}, nil
+}
+
+func (f *fakePlaid) LiabilitiesGet(_ context.Context, req plaid.LiabilitiesGetRequest) (plaid.LiabilitiesGetResponse, error) {
+	item, err := f.item(req.AccessToken)
+	if err != nil {
+		return plaid.LiabilitiesGetResponse{}, err
+	}
+	return plaid.LiabilitiesGetResponse{
+		Accounts:    item.accounts,
+		Liabilities: plaid.LiabilitiesObject{Credit: item.liabilities},
+	}, nil
+}
+
+func (f *fakePlaid) AccountsGet(_ context.Context, req plaid.AccountsGetRequest) (plaid.AccountsGetResponse, error) {
+	item, err := f.item(req.AccessToken)
+	if err != nil {
+		return plaid.AccountsGetResponse{}, err
+	}
+	return plaid.AccountsGetResponse{Accounts: item.accounts}, nil
+}
+
+func (f *fakePlaid) ItemGet(_ context.Context, req plaid.ItemGetRequest) (plaid.ItemGetResponse, error) {
+	if _, err := f.item(req.AccessToken); err != nil {
+		return plaid.ItemGetResponse{}, err
+	}
+	return plaid.ItemGetResponse{Item: plaid.Item{ItemId: "item-" + req.AccessToken}}, nil
+}
+
+func (f *fakePlaid) ItemRemove(_ context.Context, req plaid.ItemRemoveRequest) (plaid.ItemRemoveResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.removeErr != nil {
+		return plaid.ItemRemoveResponse{}, f.removeErr
+	}
+	f.removed = append(f.removed, req.AccessToken)
+	delete(f.items, req.AccessToken)
+	return plaid.ItemRemoveResponse{}, nil
+}
+
+func (f *fakePlaid) TransactionsGet(_ context.Context, req plaid.TransactionsGetRequest) (plaid.TransactionsGetResponse, error) {
+	item, err := f.item(req.AccessToken)
+	if err != nil {
+		return plaid.TransactionsGetResponse{}, err
+	}
+	return plaid.TransactionsGetResponse{
+		Accounts:          item.accounts,
+		Transactions:      item.transactions,
+		TotalTransactions: int32(len(item.transactions)),
+	}, nil
+}
+
+func (f *fakePlaid) TransferAuthorizationCreate(context.Context, plaid.TransferAuthorizationCreateRequest) (plaid.TransferAuthorizationCreateResponse, error) {
+	return plaid.TransferAuthorizationCreateResponse{}, errors.New("transfers are not faked")
+}
+
+func (f *fakePlaid) TransferCreate(context.Context, plaid.TransferCreateRequest) (plaid.TransferCreateResponse, error) {
+	return plaid.TransferCreateResponse{}, errors.New("transfers are not faked")
+}
+
+// fakePlanning is the planning service, keeping the plans it accepts per user
+type fakePlanning struct {
+	*httptest.Server
+	mu    sync.Mutex
+	plans map[string][]*models.PaymentPlan
+	// failAccept answers accepts with a server error
+	failAccept bool
+	// failDelete answers deletes with a server error
+	failDelete bool
+}
+
+func newFakePlanning(t *testing.T) *fakePlanning {
+	f := &fakePlanning{plans: make(map[string][]*models.PaymentPlan)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/payment_plans/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		writeJSON(w, handlers.ListPaymentPlanResponse{PaymentPlans: f.list(strings.TrimPrefix(r.URL.Path, "/payment_plans/"))})
+	})
+	mux.HandleFunc("/paymentplan", func(w http.ResponseWriter, r *http.Request) {
+		var req models.CreatePaymentPlanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.PaymentTasks) == 0 {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		plan := &models.PaymentPlan{PaymentPlanId: primitive.NewObjectID().Hex(), UserId: req.PaymentTasks[0].UserId.Hex(), Status: models.PaymentStatus_PAYMENT_STATUS_CURRENT}
+		for _, task := range req.PaymentTasks {
+			plan.PaymentTaskId = append(plan.PaymentTaskId, task.ID.Hex())
+			plan.Amount += task.Amount
+			plan.PaymentAction = append(plan.PaymentAction, models.PaymentAction{AccountId: task.AccountId, Amount: task.Amount})
+			plan.Transactions = append(plan.Transactions, task.Transactions...)
+		}
+		writeJSON(w, models.PaymentPlanResponse{PaymentPlans: []*models.PaymentPlan{plan}})
+	})
+	mux.HandleFunc("/paymentplan/accept", func(w http.ResponseWriter, r *http.Request) {
+		var req models.AcceptPaymentPlanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.failAccept {
+			http.Error(w, "planning is down", http.StatusInternalServerError)
+			return
+		}
+		plan := req.PaymentPlan
+		f.plans[plan.UserId] = append(f.plans[plan.UserId], &plan)
+		writeJSON(w, models.PaymentPlanResponse{PaymentPlans: []*models.PaymentPlan{&plan}})
+	})
+	mux.HandleFunc("/paymentplan/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/paymentplan/")
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.failDelete {
+			http.Error(w, "planning is down", http.StatusInternalServerError)
+			return
+		}
+		for userId, plans := range f.plans {
+			for idx, plan := range plans {
+				if plan.PaymentPlanId == id {
+					f.plans[userId] = append(plans[:idx], plans[idx+1:]...)
+					writeJSON(w, models.DeletePaymentPlanResponse{Status: models.DELETE_STATUS_SUCCESS, PaymentPlan: plan})
+					return
+				}
+			}
+		}
+		writeJSON(w, models.DeletePaymentPlanResponse{Status: models.DELETE_STATUS_UNKNOWN})
+	})
+	mux.HandleFunc("/waterfall/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, fiber.Map{"monthly_waterfall": []any{}})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// list returns the plans of the user, f.mu must be held
+func (f *fakePlanning) list(userId string) []models.PaymentPlan {
+	plans := make([]models.PaymentPlan, 0, len(f.plans[userId]))
+	for _, plan := range f.plans[userId] {
+		plans = append(plans, *plan)
+	}
+	return plans
+}
+
+// add stores a plan of the user, as if it had been accepted
+func (f *fakePlanning) add(plan *models.PaymentPlan) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.plans[plan.UserId] = append(f.plans[plan.UserId], plan)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// sms is a text sent through twilio
+type sms struct {
+	To   string
+	Body string
+}
+
+// fakeSMS is the transport of the twilio client, recording the texts sent through it
+type fakeSMS struct {
+	mu   sync.Mutex
+	sent []sms
+	// fail answers every text with an error
+	fail bool
+}
+
+func (f *fakeSMS) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status, payload := http.StatusCreated, fmt.Sprintf(`{"sid":"SM%d","status":"queued"}`, len(f.sent))
+	if f.fail {
+		status, payload = http.StatusBadRequest, `{"code":21211,"message":"invalid 'To' phone number","status":400}`
+	} else {
+		f.sent = append(f.sent, sms{To: form.Get("To"), Body: form.Get("Body")})
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(payload)),
+		Request:    req,
+	}, nil
+}
+
+// messages returns the texts sent so far
+func (f *fakeSMS) messages() []sms {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]sms(nil), f.sent...)
+}
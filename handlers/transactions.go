@@ -1,29 +1,280 @@
 package handlers
 
 import (
-	"github.com/go-redis/cache/v8"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // @Summary Get transactions for a single user.
-// @Description fetch all transactions for the user.
+// @Description fetch all transactions for the user, optionally filtered by whether they are covered by a payment plan.
 // @Tags transactions
 // @Param email path string true "User email"
+// @Param in_plan query bool false "Only return transactions that are (true) or are not (false) in a payment plan"
 // @Produce json
 // @Success 200 {object} []models.Transaction
 // @Router /transactions [get]
-func GetUsersTransactions(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func GetUsersTransactions(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+
+		var inPlanFilter *bool
+		if q := c.Query("in_plan"); q != "" {
+			inPlan, err := strconv.ParseBool(q)
+			if err != nil {
+				return apierror.Validation("invalid in_plan filter", err.Error())
+			}
+			inPlanFilter = &inPlan
+		}
+
+		transactions, err := FetchTransactionDetails(c.UserContext(), h, *user.GetID(), rcache)
+		if err != nil {
+			return apierror.Wrap(err, "transactions for that user not found")
+		}
+
+		inPlan, err := GetInPlanTransactions(c.UserContext(), h, *user.GetID())
+		if err != nil {
+			return apierror.Wrap(err, "failed getting in plan transactions")
+		}
+
+		response := make([]*models.Transaction, 0, len(transactions))
+		for _, trxn := range transactions {
+			// the transactions are shared with every request served from the cache, annotate a copy
+			annotated := *trxn
+			annotated.PaymentPlanId, annotated.InPlan = inPlan[trxn.PlaidTransactionId]
+			if inPlanFilter == nil || *inPlanFilter == annotated.InPlan {
+				response = append(response, &annotated)
+			}
+		}
+
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "user transactions", response)
+	}
+}
+
+const (
+	defaultTransactionPageSize = 50
+	maxTransactionPageSize     = 500
+)
+
+// sortable fields of the transaction query API
+var transactionSortFields = map[string]bool{
+	"date":   true,
+	"amount": true,
+}
+
+type TransactionQueryResponse struct {
+	Transactions []*models.Transaction `json:"transactions"`
+	NextCursor   string                `json:"next_cursor,omitempty"`
+}
+
+// encodeTransactionCursor marks the position of the last transaction of a page, so the next page
+// can resume right after it
+func encodeTransactionCursor(tc repository.TransactionCursor) string {
+	raw, _ := json.Marshal(tc)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeTransactionCursor(cursor string) (*repository.TransactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	var tc repository.TransactionCursor
+	if err = json.Unmarshal(raw, &tc); err != nil || tc.ID == "" {
+		return nil, errors.New("malformed cursor")
+	}
+	return &tc, nil
+}
+
+// @Summary Query transactions for a single user.
+// @Description filter, search, sort and paginate through the user's stored transactions.
+// @Tags transactions
+// @Param start_date query string false "Earliest transaction date (YYYY-MM-DD)"
+// @Param end_date query string false "Latest transaction date (YYYY-MM-DD)"
+// @Param account_id query string false "Plaid account id"
+// @Param primary_category query string false "Personal finance primary category"
+// @Param detailed_category query string false "Personal finance detailed category"
+// @Param merchant query string false "Merchant name"
+// @Param min_amount query number false "Minimum amount"
+// @Param max_amount query number false "Maximum amount"
+// @Param pending query bool false "Pending status"
+// @Param in_plan query bool false "Covered by a payment plan"
+// @Param q query string false "Free text search over name and merchant name"
+// @Param sort query string false "Sort field (date, amount)"
+// @Param order query string false "Sort order (asc, desc)"
+// @Param limit query int false "Page size"
+// @Param cursor query string false "Cursor returned by the previous page"
+// @Produce json
+// @Success 200 {object} TransactionQueryResponse
+// @Router /transactions/query [get]
+func QueryTransactions(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+
+		filter, err := transactionQueryFilter(c, *user.GetID())
+		if err != nil {
+			return apierror.Validation("invalid transaction query", err.Error())
+		}
+
+		sortField := c.Query("sort", "date")
+		if !transactionSortFields[sortField] {
+			return apierror.Validation("invalid transaction query", "sort must be one of date, amount")
+		}
+		descending := true
+		switch c.Query("order", "desc") {
+		case "asc":
+			descending = false
+		case "desc":
+		default:
+			return apierror.Validation("invalid transaction query", "order must be one of asc, desc")
+		}
+
+		limit, err := strconv.Atoi(c.Query("limit", strconv.Itoa(defaultTransactionPageSize)))
+		if err != nil || limit <= 0 || limit > maxTransactionPageSize {
+			return apierror.Validation("invalid transaction query", "limit must be between 1 and 500")
+		}
+
+		query := repository.TransactionQuery{Filter: filter, SortField: sortField, Descending: descending, Limit: limit + 1}
+		if cursor := c.Query("cursor"); cursor != "" {
+			if query.After, err = decodeTransactionCursor(cursor); err != nil {
+				return apierror.Validation("invalid transaction query", err.Error())
+			}
 		}
 
-		transactions, err := FetchTransactionDetails(*user.GetID(), h.P, rcache)
+		transactions, err := h.Transactions.Query(c.UserContext(), query)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusNotFound, "error", "transactions for that user not found", err.Error())
+			return apierror.Wrap(err, "failed querying transactions")
+		}
+
+		response := TransactionQueryResponse{Transactions: transactions}
+		if len(transactions) > limit {
+			response.Transactions = transactions[:limit]
+			last := response.Transactions[limit-1]
+			value := float64(last.Date)
+			if sortField == "amount" {
+				value = last.Amount
+			}
+			response.NextCursor = encodeTransactionCursor(repository.TransactionCursor{Value: value, ID: last.PlaidTransactionId})
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "user transactions", response)
+	}
+}
+
+// transactionQueryFilter builds the repository filter for the query parameters of QueryTransactions
+func transactionQueryFilter(c *fiber.Ctx, userId primitive.ObjectID) (repository.TransactionFilter, error) {
+	const dateLayout = "2006-01-02"
+	filter := repository.TransactionFilter{
+		UserId:           userId,
+		AccountId:        c.Query("account_id"),
+		PrimaryCategory:  c.Query("primary_category"),
+		DetailedCategory: c.Query("detailed_category"),
+		Merchant:         c.Query("merchant"),
+		Search:           c.Query("q"),
+	}
+
+	for param, date := range map[string]**int64{"start_date": &filter.StartDate, "end_date": &filter.EndDate} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(dateLayout, v)
+			if err != nil {
+				return filter, errors.New(param + " must be formatted as YYYY-MM-DD")
+			}
+			millis := t.UnixMilli()
+			*date = &millis
+		}
+	}
+
+	for param, amount := range map[string]**float64{"min_amount": &filter.MinAmount, "max_amount": &filter.MaxAmount} {
+		if v := c.Query(param); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return filter, errors.New(param + " must be a number")
+			}
+			*amount = &f
+		}
+	}
+
+	for param, flag := range map[string]**bool{"pending": &filter.Pending, "in_plan": &filter.InPlan} {
+		if v := c.Query(param); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return filter, errors.New(param + " must be true or false")
+			}
+			*flag = &b
 		}
+	}
+	return filter, nil
+}
+
+// GetInPlanTransactions returns a map of plaid transaction id to the payment plan id covering it,
+// for every transaction of the user that is currently part of a payment plan.
+func GetInPlanTransactions(ctx context.Context, h *Handler, userId primitive.ObjectID) (map[string]string, error) {
+	inPlan, err := h.Transactions.InPlan(ctx, userId)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("[TrxnDb] Error getting in plan transactions")
+		return nil, err
+	}
+	return inPlan, nil
+}
 
-		return FiberJsonResponse(c, fiber.StatusOK, "success", "user transactions", transactions)
+// FindTransactionsInOtherPlans returns the subset of transactionIds that are already covered by a
+// payment plan other than paymentPlanId. An empty paymentPlanId matches every plan.
+func FindTransactionsInOtherPlans(ctx context.Context, h *Handler, userId primitive.ObjectID, transactionIds []string, paymentPlanId string) ([]string, error) {
+	if len(transactionIds) == 0 {
+		return nil, nil
+	}
+
+	inPlan, err := GetInPlanTransactions(ctx, h, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	for _, id := range transactionIds {
+		if planId, ok := inPlan[id]; ok && (paymentPlanId == "" || planId != paymentPlanId) {
+			conflicts = append(conflicts, id)
+		}
+	}
+	return conflicts, nil
+}
+
+// MarkTransactionsInPlan flags every transaction in transactionIds as covered by the given payment plan,
+// failing with repository.ErrConflict without flagging any if another plan covers one of them.
+func MarkTransactionsInPlan(ctx context.Context, h *Handler, userId primitive.ObjectID, paymentPlanId string, transactionIds []string) error {
+	return h.Transactions.MarkInPlan(ctx, userId, paymentPlanId, transactionIds)
+}
+
+// ReleasePlanTransactions clears the in plan flag of every transaction of the user covered by the given
+// payment plan.
+func ReleasePlanTransactions(ctx context.Context, h *Handler, userId primitive.ObjectID, paymentPlanId string) error {
+	return h.Transactions.ReleasePlan(ctx, userId, paymentPlanId)
+}
+
+// releaseCancelledPlans frees up the transactions of any cancelled plan of the user so they can be
+// planned again.
+func releaseCancelledPlans(ctx context.Context, h *Handler, userId primitive.ObjectID, plans []models.PaymentPlan) {
+	for _, plan := range plans {
+		if plan.Status != models.PaymentStatus_PAYMENT_STATUS_CANCELLED {
+			continue
+		}
+		if err := ReleasePlanTransactions(ctx, h, userId, plan.PaymentPlanId); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("payment_plan_id", plan.PaymentPlanId).Error("[TrxnDb] Error releasing transactions of cancelled plan")
+		}
 	}
 }
@@ -0,0 +1,112 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/jalexanderII/zero-railway/handlers"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/plaid/plaid-go/plaid"
+)
+
+func TestQueryTransactionsPages(t *testing.T) {
+	ts, _ := newSeededServer(t)
+	// the query reads the transactions stored by the last fetch from plaid
+	ts.expect(http.StatusOK, http.MethodGet, "/api/plaid/accounts", "alice", nil)
+	var seen []string
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		var res handlers.TransactionQueryResponse
+		path := "/api/core/transactions/query?limit=1&cursor=" + url.QueryEscape(cursor)
+		ts.expect(http.StatusOK, http.MethodGet, path, "alice", nil).decode(t, &res)
+		for _, trxn := range res.Transactions {
+			seen = append(seen, trxn.PlaidTransactionId)
+		}
+		if cursor = res.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(seen) != 2 || seen[0] != "alice-t1" || seen[1] != "alice-t2" {
+		t.Fatalf("got pages %v, want alice-t1 then alice-t2", seen)
+	}
+}
+
+func TestPendingTransactionPostsUnderNewId(t *testing.T) {
+	ts, ids := newSeededServer(t)
+	item := ts.plaid.items["access-alice"]
+	item.purchase("alice-p1", "alice-card", 30, 1, "Whole Foods", "FOOD_AND_DRINK")
+	item.transactions[len(item.transactions)-1].Pending = true
+
+	plan := createPlan(t, ts, "alice-p1")
+	ts.expect(http.StatusOK, http.MethodPost, "/api/planning/accept", "alice", models.AcceptPaymentPlanRequest{PaymentPlan: plan})
+	if total := categorySpend(t, ts); total != 162.5 {
+		t.Fatalf("got spend %v with a pending charge, want 162.5 without it", total)
+	}
+
+	// the charge posts under a new id and plaid stops returning the pending one
+	ts.plaid.mu.Lock()
+	item.transactions = item.transactions[:len(item.transactions)-1]
+	item.purchase("alice-t3", "alice-card", 30, 1, "Whole Foods", "FOOD_AND_DRINK")
+	item.transactions[len(item.transactions)-1].PendingTransactionId = *plaid.NewNullableString(plaid.PtrString("alice-p1"))
+	ts.plaid.mu.Unlock()
+	ts.expect(http.StatusOK, http.MethodPost, ids.Replace("/admin/users/{alice}/resync"), "admin", nil)
+
+	if total := categorySpend(t, ts); total != 192.5 {
+		t.Fatalf("got spend %v once posted, want 192.5", total)
+	}
+	if planned := inPlan(t, ts); len(planned) != 1 || planned[0] != "alice-t3" {
+		t.Fatalf("got transactions %v in plan, want the posted alice-t3", planned)
+	}
+	var res handlers.TransactionQueryResponse
+	ts.expect(http.StatusOK, http.MethodGet, "/api/core/transactions/query", "alice", nil).decode(t, &res)
+	if len(res.Transactions) != 3 {
+		t.Fatalf("got %d transactions, want the 3 plaid returns", len(res.Transactions))
+	}
+}
+
+// categorySpend returns alice's spend over the last 30 days
+func categorySpend(t *testing.T, ts *testServer) float64 {
+	t.Helper()
+	var res handlers.CategorySpendResponse
+	ts.expect(http.StatusOK, http.MethodGet, "/api/core/analytics/categories?period=30d", "alice", nil).decode(t, &res)
+	return res.Total
+}
+
+func TestConcurrentTransactionListsAnnotateCopies(t *testing.T) {
+	ts, _ := newSeededServer(t)
+	plan := createPlan(t, ts, "alice-t1")
+	ts.expect(http.StatusOK, http.MethodPost, "/api/planning/accept", "alice", models.AcceptPaymentPlanRequest{PaymentPlan: plan})
+	alice, err := ts.repos.Users.GetByClerkId(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ts.cache.AccountDetails.Invalidate(context.Background(), alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lists share a single fetch from plaid, and its transactions with the ingest hooks, so they
+	// must not annotate them in place, which go test -race reports
+	responses := make([]*response, 5)
+	var wg sync.WaitGroup
+	for idx := range responses {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			responses[idx] = ts.do(http.MethodGet, "/api/core/transactions/?in_plan=true", "alice", nil)
+		}(idx)
+	}
+	wg.Wait()
+	for _, resp := range responses {
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d, want 200: %s", resp.StatusCode, resp.body)
+		}
+		var listed []models.Transaction
+		resp.decode(t, &listed)
+		if len(listed) != 1 || listed[0].PlaidTransactionId != "alice-t1" || listed[0].PaymentPlanId == "" {
+			t.Fatalf("got transactions %+v in plan, want alice-t1 with its plan", listed)
+		}
+	}
+}
@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"github.com/go-redis/cache/v8"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
 	"github.com/jalexanderII/zero-railway/models"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/jalexanderII/zero-railway/repository"
+	"github.com/jalexanderII/zero-railway/validation"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // @Summary Create a user.
@@ -20,28 +23,32 @@ import (
 // @Produce json
 // @Success 200 {object} DBInsertResponse
 // @Router /users [post]
-func CreateUser(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func CreateUser(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		nUser := new(models.User)
-		if err := c.BodyParser(nUser); err != nil {
-			return FiberJsonResponse(c, fiber.StatusBadRequest, "error", "request body malformed", err.Error())
+		if err := validation.ParseBody(c, nUser); err != nil {
+			return err
 		}
+		// roles are granted by an admin, never by the user
+		nUser.Roles = nil
 
-		user, err := h.GetUserByEmail(nUser.Email, rcache)
+		user, err := h.GetUserByEmail(c.UserContext(), nUser.Email, rcache)
 		if user == nil || err != nil {
-			// ErrNoDocuments means that the filter did not match any documents in the collection
-			if user == nil || err == mongo.ErrNoDocuments {
+			// ErrNotFound means that no user has that email yet
+			if user == nil || errors.Is(err, repository.ErrNotFound) {
 				nUser.ID = primitive.NewObjectID()
 				nUser.CreatedAt = time.Now()
 				nUser.UpdatedAt = time.Now()
-				res, err := h.Db.InsertOne(h.C, nUser)
+				id, err := h.Users.Create(c.UserContext(), nUser)
 				if err != nil {
-					return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed to create user", err.Error())
+					return apierror.Wrap(err, "failed to create user")
 				}
-				return FiberJsonResponse(c, fiber.StatusOK, "success", "new user created", res.InsertedID)
+				_, after := auditChanges(nil, nUser)
+				h.RecordAudit(c, models.AuditEvent{Action: "user.created", UserId: id, Target: "user:" + id.Hex(), After: after})
+				return FiberJsonResponse(c, fiber.StatusOK, "success", "new user created", id)
 			}
-			h.L.Error("[UserDB] Error checking if user already exists", "error", err.Error())
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "error checking if user already exists", err.Error())
+			logging.Ctx(c).WithError(err).Error("[UserDB] Error checking if user already exists")
+			return apierror.Wrap(err, "error checking if user already exists")
 		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "users already exists", DBInsertResponse{user.ID})
 	}
@@ -54,11 +61,11 @@ func CreateUser(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
 // @Produce json
 // @Success 200 {object} models.User
 // @Router /users [get]
-func GetUser(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func GetUser(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
 		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "found user", user)
 	}
@@ -75,19 +82,19 @@ func GetUserByID(h *Handler) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		userId, err := primitive.ObjectIDFromHex(c.Params("id"))
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusBadRequest, "error", "invalid user id", err.Error())
+			return apierror.Validation("invalid user id", err.Error())
 		}
-		var user models.User
-		filter := bson.M{"_id": userId}
-		if err = h.Db.FindOne(h.C, filter).Decode(&user); err != nil {
-			return FiberJsonResponse(c, fiber.StatusNotFound, "error", "user not found", err.Error())
+		user, err := h.Users.GetByID(c.UserContext(), userId)
+		if err != nil {
+			return apierror.Wrap(err, "user not found")
 		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "user", user)
 	}
 }
 
 type UpdateInput struct {
-	PhoneNumber string `json:"phoneNumber" bson:"phone_number"`
+	// PhoneNumber is a US number without its +1 country code
+	PhoneNumber string `json:"phoneNumber" bson:"phone_number" validate:"required,numeric,len=10"`
 }
 
 type UpdateResponse struct {
@@ -103,27 +110,27 @@ type UpdateResponse struct {
 // @Produce json
 // @Success 200 {object} UpdateResponse
 // @Router /users [put]
-func UpdateUserPhone(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func UpdateUserPhone(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		user, err := GetUserFromCache(c, rcache)
 		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting user's account", err.Error())
+			return apierror.Wrap(err, "failed getting user's account")
 		}
 		uUser := new(UpdateInput)
-		if err = c.BodyParser(uUser); err != nil {
-			return FiberJsonResponse(c, fiber.StatusBadRequest, "error", "request body malformed", err.Error())
+		if err = validation.ParseBody(c, uUser); err != nil {
+			return err
 		}
 		if user.PhoneNumber != uUser.PhoneNumber {
 			uUser.PhoneNumber = fmt.Sprintf("+1%s", uUser.PhoneNumber)
-			h.L.Info("User phone number updated", "user", user.Email, "phone_number", uUser.PhoneNumber)
+			logging.Ctx(c).Info("User phone number updated")
 
-			filter := bson.M{"_id": user.GetID()}
-			update := bson.M{"$set": uUser}
-			res, err := h.Db.UpdateOne(h.C, filter, update)
+			modified, err := h.Users.UpdatePhoneNumber(c.UserContext(), *user.GetID(), uUser.PhoneNumber)
 			if err != nil {
-				return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed to update user", err.Error())
+				return apierror.Wrap(err, "failed to update user")
 			}
-			return FiberJsonResponse(c, fiber.StatusOK, "success", "updated user", UpdateResponse{res.ModifiedCount})
+			before, after := auditChanges(fiber.Map{"phone_number": user.PhoneNumber}, fiber.Map{"phone_number": uUser.PhoneNumber})
+			h.RecordAudit(c, models.AuditEvent{Action: "user.phone_number.updated", UserId: user.ID, Target: "user:" + user.ID.Hex(), Before: before, After: after})
+			return FiberJsonResponse(c, fiber.StatusOK, "success", "updated user", UpdateResponse{modified})
 		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "no update needed", UpdateResponse{0})
 	}
@@ -137,58 +144,28 @@ func UpdateUserPhone(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
 // @Produce json
 // @Success 200 {object} DBInsertResponse
 // @Router /clerk [post]
-func CreateUserClerkWebhook(h *Handler, rcache *cache.Cache) func(c *fiber.Ctx) error {
+func CreateUserClerkWebhook(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		nUserWebhook := new(models.ClerkUserEvent)
-		if err := c.BodyParser(nUserWebhook); err != nil {
-			return FiberJsonResponse(c, fiber.StatusBadRequest, "error", "request body malformed", err.Error())
+		if err := validation.ParseBody(c, nUserWebhook); err != nil {
+			return err
 		}
-		user, err := h.GetUserByEmail(nUserWebhook.Data.GetEmail(), rcache)
+		user, err := h.GetUserByEmail(c.UserContext(), nUserWebhook.Data.GetEmail(), rcache)
 		if user == nil || err != nil {
-			// ErrNoDocuments means that the filter did not match any documents in the collection
-			if user == nil || err == mongo.ErrNoDocuments {
+			// ErrNotFound means that no user has that email yet
+			if user == nil || errors.Is(err, repository.ErrNotFound) {
 				nUser := nUserWebhook.Data.NewDBUser()
-				res, err := h.Db.InsertOne(h.C, nUser)
+				id, err := h.Users.Create(c.UserContext(), &nUser)
 				if err != nil {
-					return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed to create user", err.Error())
+					return apierror.Wrap(err, "failed to create user")
 				}
-				return FiberJsonResponse(c, fiber.StatusOK, "success", "new user created", res.InsertedID)
+				_, after := auditChanges(nil, nUser)
+				h.RecordAudit(c, models.AuditEvent{Action: "user.created", UserId: id, Target: "user:" + id.Hex(), After: after, Metadata: map[string]any{"source": "clerk"}})
+				return FiberJsonResponse(c, fiber.StatusOK, "success", "new user created", id)
 			}
-			h.L.Error("[UserDB] Error checking if user already exists", "error", err.Error())
-			return FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "error checking if user already exists", err.Error())
+			logging.Ctx(c).WithError(err).Error("[UserDB] Error checking if user already exists")
+			return apierror.Wrap(err, "error checking if user already exists")
 		}
 		return FiberJsonResponse(c, fiber.StatusOK, "success", "users already exists", DBInsertResponse{user.ID})
 	}
 }
-
-func CleanUp(h *Handler) func(c *fiber.Ctx) error {
-	return func(c *fiber.Ctx) error {
-		var results []models.User
-		cursor, err := h.UserDb.Find(h.C, bson.D{})
-		if err != nil {
-			return FiberJsonResponse(c, fiber.StatusNotFound, "error", "user not found", err.Error())
-		}
-		if err = cursor.All(h.C, &results); err != nil {
-			h.L.Error("[DB] Error getting all users", "error", err.Error())
-			return FiberJsonResponse(c, fiber.StatusNotFound, "error", "user not found", err.Error())
-		}
-		var count = 0
-		for _, user := range results {
-			if user.PhoneNumber == "+1undefined" {
-				test := c.Params("test")
-				if test == "false" {
-					filter := bson.D{{Key: "_id", Value: user.ID}}
-					_, err = h.UserDb.DeleteOne(h.C, filter)
-					if err == nil {
-						count++
-					} else {
-						h.L.Error("[DB] Error deleting user", "error", err.Error())
-					}
-				} else {
-					h.L.Info("[DB] Test mode, not deleting user", "user", user.PhoneNumber)
-				}
-			}
-		}
-		return FiberJsonResponse(c, fiber.StatusOK, "success", "done deleting users", count)
-	}
-}
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	client "github.com/jalexanderII/zero-railway/app/clients"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/tracing"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// targetUtilization is the utilization recommendations aim to get each card under
+const targetUtilization = 30.0
+
+// UtilizationTracker snapshots credit card balances daily and texts users when their utilization
+// crosses one of models.UtilizationAlertThresholds
+type UtilizationTracker struct {
+	H *Handler
+	T *client.TwilioClient
+	// mu serializes Record, so concurrent fetches of a user's accounts alert them once
+	mu sync.Mutex
+}
+
+func NewUtilizationTracker(h *Handler, tc *client.TwilioClient) *UtilizationTracker {
+	return &UtilizationTracker{H: h, T: tc}
+}
+
+// utilizationCrossing is a threshold the utilization of an account, or the overall one when accountId
+// is empty, rose above since the previous snapshot
+type utilizationCrossing struct {
+	accountId   string
+	threshold   float64
+	utilization float64
+	line        string
+}
+
+// Record saves today's snapshot of every credit account and alerts the user of any threshold their
+// utilization crossed since the previous snapshot. Each crossing alerts once a day, and is only
+// recorded once the SMS is sent.
+func (u *UtilizationTracker) Record(ctx context.Context, userId primitive.ObjectID, accounts []*models.Account) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	today := time.Now().UTC().Format("2006-01-02")
+	current := make([]models.BalanceSnapshot, 0)
+	for _, acc := range accounts {
+		if acc == nil || acc.Type != "credit" || acc.CreditLimit <= 0 {
+			continue
+		}
+		current = append(current, models.BalanceSnapshot{
+			UserId:         userId,
+			AccountId:      acc.PlaidAccountId,
+			Name:           accountName(acc),
+			Date:           today,
+			CurrentBalance: acc.CurrentBalance,
+			CreditLimit:    acc.CreditLimit,
+			Utilization:    utilization(acc.CurrentBalance, acc.CreditLimit),
+		})
+	}
+	if len(current) == 0 {
+		return nil
+	}
+
+	previous, err := u.H.Snapshots.LatestBefore(ctx, userId, today)
+	if err != nil {
+		return err
+	}
+	if err = u.H.Snapshots.Upsert(ctx, current); err != nil {
+		return err
+	}
+	if len(previous) == 0 {
+		return nil
+	}
+
+	crossings, err := u.newCrossings(ctx, userId, today, previous, current)
+	if err != nil || len(crossings) == 0 {
+		return err
+	}
+
+	user, err := u.H.GetUserByID(ctx, userId.Hex())
+	if err != nil {
+		return err
+	}
+	if !user.GetNotificationPreferences().SendUtilizationAlerts() {
+		return nil
+	}
+	if user.PhoneNumber == "" {
+		logging.FromContext(ctx).WithField("user_id", userId.Hex()).Info("[Utilization] user has no phone number, skipping alert")
+		return nil
+	}
+	lines := make([]string, len(crossings))
+	for idx, crossing := range crossings {
+		lines[idx] = crossing.line
+	}
+	message := strings.Join(lines, "\n") + "\nKeeping utilization under 30% helps your credit score."
+	if _, err = u.T.SendSMS(ctx, user.PhoneNumber, message); err != nil {
+		return err
+	}
+	for _, crossing := range crossings {
+		if _, err = u.H.Snapshots.RecordAlert(ctx, &models.UtilizationAlert{
+			UserId:      userId,
+			AccountId:   crossing.accountId,
+			Date:        today,
+			Threshold:   crossing.threshold,
+			Utilization: crossing.utilization,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newCrossings returns the thresholds crossed between the previous and current snapshots that have
+// not alerted yet today
+func (u *UtilizationTracker) newCrossings(ctx context.Context, userId primitive.ObjectID, today string, previous, current []models.BalanceSnapshot) ([]utilizationCrossing, error) {
+	alerts, err := u.H.Snapshots.ListAlerts(ctx, userId, today)
+	if err != nil {
+		return nil, err
+	}
+	alerted := make(map[string]bool, len(alerts))
+	for _, alert := range alerts {
+		alerted[fmt.Sprintf("%s/%v", alert.AccountId, alert.Threshold)] = true
+	}
+
+	var crossings []utilizationCrossing
+	add := func(crossing utilizationCrossing) {
+		if !alerted[fmt.Sprintf("%s/%v", crossing.accountId, crossing.threshold)] {
+			crossings = append(crossings, crossing)
+		}
+	}
+	previousByAccount := make(map[string]models.BalanceSnapshot, len(previous))
+	for _, snapshot := range previous {
+		previousByAccount[snapshot.AccountId] = snapshot
+	}
+	for _, snapshot := range current {
+		if before, ok := previousByAccount[snapshot.AccountId]; ok {
+			if threshold := crossedThreshold(before.Utilization, snapshot.Utilization); threshold > 0 {
+				add(utilizationCrossing{
+					accountId:   snapshot.AccountId,
+					threshold:   threshold,
+					utilization: snapshot.Utilization,
+					line:        fmt.Sprintf("%s is now at %.0f%% utilization (over %.0f%%)", snapshot.Name, snapshot.Utilization, threshold),
+				})
+			}
+		}
+	}
+	overallBefore, overallNow := overallUtilization(previous), overallUtilization(current)
+	if threshold := crossedThreshold(overallBefore.Utilization, overallNow.Utilization); threshold > 0 {
+		add(utilizationCrossing{
+			threshold:   threshold,
+			utilization: overallNow.Utilization,
+			line:        fmt.Sprintf("Your overall credit utilization is now %.0f%% (over %.0f%%)", overallNow.Utilization, threshold),
+		})
+	}
+	return crossings, nil
+}
+
+// snapshotConcurrency bounds the users whose accounts the balance snapshot job refreshes at once
+const snapshotConcurrency = 4
+
+// SnapshotResponse lists the users whose balances a snapshot job refreshes
+type SnapshotResponse struct {
+	UserIds []string `json:"user_ids"`
+}
+
+// @Summary Snapshot every user's credit balances.
+// @Description refresh the accounts of every user with a linked Item from plaid and record their balance snapshot for the day, so utilization history has no gaps for users who do not open the app. The job runs in the background. Meant to run daily.
+// @Tags admin
+// @Produce json
+// @Success 202 {object} SnapshotResponse
+// @Router /admin/jobs/balance_snapshots [post]
+func AdminSnapshotBalances(u *UtilizationTracker, rcache *caching.Store, timeout time.Duration) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		tokens, err := u.H.Tokens.List(c.UserContext())
+		if err != nil {
+			return apierror.Wrap(err, "failed listing linked Items")
+		}
+
+		response := SnapshotResponse{UserIds: make([]string, 0)}
+		var userIds []primitive.ObjectID
+		seen := make(map[primitive.ObjectID]bool)
+		for _, token := range tokens {
+			if token.User == nil || seen[token.User.ID] {
+				continue
+			}
+			seen[token.User.ID] = true
+			userIds = append(userIds, token.User.ID)
+			response.UserIds = append(response.UserIds, token.User.ID.Hex())
+		}
+
+		// the job outlives the request, keeping its trace and logger
+		ctx, cancel := context.WithTimeout(logging.WithEntry(tracing.Detach(c.UserContext()), logging.FromContext(c.UserContext())), timeout)
+		go func() {
+			defer cancel()
+			u.SnapshotBalances(ctx, rcache, userIds)
+		}()
+		return FiberJsonResponse(c, fiber.StatusAccepted, "success", "balance snapshots started", response)
+	}
+}
+
+// SnapshotBalances refreshes the accounts of the users from plaid and records their balance snapshot
+// for the day, logging the users it failed for
+func (u *UtilizationTracker) SnapshotBalances(ctx context.Context, rcache *caching.Store, userIds []primitive.ObjectID) {
+	var mu sync.Mutex
+	failed := 0
+	sem := make(chan struct{}, snapshotConcurrency)
+	var wg sync.WaitGroup
+	for _, userId := range userIds {
+		wg.Add(1)
+		go func(userId primitive.ObjectID) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			details, err := FetchDataAndCache(ctx, u.H, userId, rcache, true)
+			if err == nil {
+				err = u.Record(ctx, userId, details.Accounts)
+			}
+			if err != nil {
+				logging.FromContext(ctx).WithError(err).WithField("user_id", userId.Hex()).Error("[Utilization] error recording balance snapshots")
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}(userId)
+	}
+	wg.Wait()
+	logging.FromContext(ctx).WithField("users", len(userIds)).WithField("failed", failed).Info("[Utilization] balance snapshots recorded")
+}
+
+// @Summary Get a user's current credit utilization.
+// @Description fetch the current per card and overall utilization with recommendations to get each card under 30% before its next statement.
+// @Tags utilization
+// @Produce json
+// @Success 200 {object} models.CurrentUtilizationResponse
+// @Router /utilization [get]
+func GetCurrentUtilization(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		accounts, err := GetUserAccounts(c.UserContext(), h, user.GetID(), rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting users accounts")
+		}
+
+		now := time.Now().UTC()
+		response := models.CurrentUtilizationResponse{
+			Accounts:        make([]models.AccountUtilization, 0),
+			Recommendations: make([]models.UtilizationRecommendation, 0),
+		}
+		var snapshots []models.BalanceSnapshot
+		for _, acc := range accounts {
+			if acc == nil || acc.Type != "credit" || acc.CreditLimit <= 0 {
+				continue
+			}
+			point := models.UtilizationPoint{
+				Date:           now.Format("2006-01-02"),
+				CurrentBalance: acc.CurrentBalance,
+				CreditLimit:    acc.CreditLimit,
+				Utilization:    utilization(acc.CurrentBalance, acc.CreditLimit),
+			}
+			response.Accounts = append(response.Accounts, models.AccountUtilization{AccountId: acc.PlaidAccountId, Name: accountName(acc), UtilizationPoint: point})
+			snapshots = append(snapshots, models.BalanceSnapshot{CurrentBalance: acc.CurrentBalance, CreditLimit: acc.CreditLimit})
+			if rec, ok := utilizationRecommendation(acc, now); ok {
+				response.Recommendations = append(response.Recommendations, rec)
+			}
+		}
+		sort.Slice(response.Recommendations, func(i, j int) bool {
+			return response.Recommendations[i].PaymentAmount > response.Recommendations[j].PaymentAmount
+		})
+		response.Overall = overallUtilization(snapshots)
+		response.Overall.Date = now.Format("2006-01-02")
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "current utilization", response)
+	}
+}
+
+// @Summary Get a user's credit utilization history.
+// @Description fetch the daily per card and overall utilization over the last days.
+// @Tags utilization
+// @Param days query int false "Days of history (default 90, max 730)"
+// @Produce json
+// @Success 200 {object} models.UtilizationHistoryResponse
+// @Router /utilization/history [get]
+func GetUtilizationHistory(h *Handler, rcache *caching.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		user, err := GetUserFromCache(c, rcache)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting user's account")
+		}
+		days, err := strconv.Atoi(c.Query("days", "90"))
+		if err != nil || days <= 0 || days > 730 {
+			return apierror.Validation("invalid days", "days must be between 1 and 730")
+		}
+
+		since := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+		snapshots, err := h.Snapshots.ListSince(c.UserContext(), *user.GetID(), since)
+		if err != nil {
+			return apierror.Wrap(err, "failed getting balance snapshots")
+		}
+
+		response := models.UtilizationHistoryResponse{
+			Overall:  make([]models.UtilizationPoint, 0),
+			Accounts: make([]models.AccountUtilizationHistory, 0),
+		}
+		byAccount := make(map[string]int)
+		byDate := make(map[string][]models.BalanceSnapshot)
+		var dates []string
+		for _, snapshot := range snapshots {
+			idx, ok := byAccount[snapshot.AccountId]
+			if !ok {
+				idx = len(response.Accounts)
+				byAccount[snapshot.AccountId] = idx
+				response.Accounts = append(response.Accounts, models.AccountUtilizationHistory{AccountId: snapshot.AccountId, Name: snapshot.Name})
+			}
+			response.Accounts[idx].History = append(response.Accounts[idx].History, snapshotPoint(snapshot))
+
+			if _, ok := byDate[snapshot.Date]; !ok {
+				dates = append(dates, snapshot.Date)
+			}
+			byDate[snapshot.Date] = append(byDate[snapshot.Date], snapshot)
+		}
+		for _, date := range dates {
+			point := overallUtilization(byDate[date])
+			point.Date = date
+			response.Overall = append(response.Overall, point)
+		}
+		return FiberJsonResponse(c, fiber.StatusOK, "success", "utilization history", response)
+	}
+}
+
+// utilizationRecommendation computes how much to pay on a card before its next statement date to
+// bring it under targetUtilization
+func utilizationRecommendation(acc *models.Account, now time.Time) (models.UtilizationRecommendation, bool) {
+	current := utilization(acc.CurrentBalance, acc.CreditLimit)
+	if current <= targetUtilization {
+		return models.UtilizationRecommendation{}, false
+	}
+
+	payment := math.Ceil((acc.CurrentBalance-acc.CreditLimit*targetUtilization/100)*100) / 100
+	rec := models.UtilizationRecommendation{
+		AccountId:         acc.PlaidAccountId,
+		Name:              accountName(acc),
+		CurrentBalance:    acc.CurrentBalance,
+		CreditLimit:       acc.CreditLimit,
+		Utilization:       current,
+		TargetUtilization: targetUtilization,
+		PaymentAmount:     payment,
+		Message:           fmt.Sprintf("Pay $%.2f on %s to get under %.0f%% utilization", payment, accountName(acc), targetUtilization),
+	}
+	if next, ok := nextStatementDate(acc.LastStatementIssueDate, now); ok {
+		rec.NextStatementDate = next.Format("2006-01-02")
+		rec.Message = fmt.Sprintf("Pay $%.2f on %s before %s to get under %.0f%% utilization on your next statement",
+			payment, accountName(acc), next.Format("Jan 2"), targetUtilization)
+	}
+	return rec, true
+}
+
+// nextStatementDate estimates the next statement issue date assuming monthly statements
+func nextStatementDate(lastStatementIssueDate string, now time.Time) (time.Time, bool) {
+	last, err := time.Parse("2006-01-02", lastStatementIssueDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+	next := last.AddDate(0, 1, 0)
+	for !next.After(now) {
+		next = next.AddDate(0, 1, 0)
+	}
+	return next, true
+}
+
+// crossedThreshold returns the highest alert threshold utilization rose above between two
+// snapshots, or 0 if it did not cross any
+func crossedThreshold(before, after float64) float64 {
+	crossed := 0.0
+	for _, threshold := range models.UtilizationAlertThresholds {
+		if before < threshold && after >= threshold {
+			crossed = threshold
+		}
+	}
+	return crossed
+}
+
+func overallUtilization(snapshots []models.BalanceSnapshot) models.UtilizationPoint {
+	var point models.UtilizationPoint
+	for _, snapshot := range snapshots {
+		point.CurrentBalance += snapshot.CurrentBalance
+		point.CreditLimit += snapshot.CreditLimit
+	}
+	point.Utilization = utilization(point.CurrentBalance, point.CreditLimit)
+	return point
+}
+
+func snapshotPoint(snapshot models.BalanceSnapshot) models.UtilizationPoint {
+	return models.UtilizationPoint{
+		Date:           snapshot.Date,
+		CurrentBalance: snapshot.CurrentBalance,
+		CreditLimit:    snapshot.CreditLimit,
+		Utilization:    snapshot.Utilization,
+	}
+}
+
+// utilization is the balance as a percentage of the limit, rounded to two decimals
+func utilization(balance, limit float64) float64 {
+	if limit <= 0 {
+		return 0
+	}
+	return math.Round(balance/limit*10000) / 100
+}
+
+func accountName(acc *models.Account) string {
+	if acc.OfficialName != "" {
+		return acc.OfficialName
+	}
+	return acc.Name
+}
@@ -0,0 +1,156 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/handlers"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// newUtilizationTracker returns a tracker for a user whose card was at 20% utilization yesterday
+func newUtilizationTracker(t *testing.T, prefs *models.NotificationPreferences) (*testServer, *models.User, *handlers.UtilizationTracker) {
+	t.Helper()
+	ctx := context.Background()
+	ts := newTestServer(t)
+	user := ts.addUser("alice")
+	if prefs != nil {
+		if _, err := ts.repos.Users.UpdateNotificationPreferences(ctx, user.ID, prefs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	snapshots := []models.BalanceSnapshot{{UserId: user.ID, AccountId: "card", Name: "Card", Date: yesterday, CurrentBalance: 200, CreditLimit: 1000, Utilization: 20}}
+	if err := ts.repos.Snapshots.Upsert(ctx, snapshots); err != nil {
+		t.Fatal(err)
+	}
+	return ts, user, handlers.NewUtilizationTracker(handlers.NewHandler(ts.repos, nil), ts.twilio)
+}
+
+// over60 is the card at 60% utilization, crossing every alert threshold
+var over60 = []*models.Account{{PlaidAccountId: "card", Name: "Card", Type: "credit", CurrentBalance: 600, CreditLimit: 1000}}
+
+func TestUtilizationAlertsOncePerDay(t *testing.T) {
+	ctx := context.Background()
+	ts, user, tracker := newUtilizationTracker(t, nil)
+	today := time.Now().UTC().Format("2006-01-02")
+
+	ts.sms.fail = true
+	if err := tracker.Record(ctx, user.ID, over60); err == nil {
+		t.Fatal("got no error when the SMS failed")
+	}
+	if alerts, _ := ts.repos.Snapshots.ListAlerts(ctx, user.ID, today); len(alerts) != 0 {
+		t.Fatalf("got %d alerts recorded for a failed SMS, want none", len(alerts))
+	}
+
+	ts.sms.fail = false
+	for run := 0; run < 3; run++ {
+		if err := tracker.Record(ctx, user.ID, over60); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent := ts.sms.messages(); len(sent) != 1 {
+		t.Fatalf("got %d texts for the same crossing, want 1", len(sent))
+	}
+	// the card and the overall utilization
+	if alerts, _ := ts.repos.Snapshots.ListAlerts(ctx, user.ID, today); len(alerts) != 2 {
+		t.Fatalf("got %d alerts recorded, want 2", len(alerts))
+	}
+}
+
+func TestUtilizationAlertsFollowPreferences(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name  string
+		prefs *models.NotificationPreferences
+		sent  int
+	}{
+		{name: "default preferences", sent: 1},
+		{name: "utilization alerts on", prefs: &models.NotificationPreferences{UtilizationAlerts: &on}, sent: 1},
+		{name: "utilization alerts off", prefs: &models.NotificationPreferences{UtilizationAlerts: &off}, sent: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, user, tracker := newUtilizationTracker(t, tt.prefs)
+			if err := tracker.Record(context.Background(), user.ID, over60); err != nil {
+				t.Fatal(err)
+			}
+			if sent := ts.sms.messages(); len(sent) != tt.sent {
+				t.Fatalf("got %d texts, want %d", len(sent), tt.sent)
+			}
+		})
+	}
+}
+
+func TestSnapshotBalancesJob(t *testing.T) {
+	ctx := context.Background()
+	ts, _ := newSeededServer(t)
+	ts.expect(http.StatusForbidden, http.MethodPost, "/admin/jobs/balance_snapshots", "alice", nil)
+
+	alice, err := ts.repos.Users.GetByClerkId(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	snapshots := []models.BalanceSnapshot{{UserId: alice.ID, AccountId: "alice-card", Name: "Alice Card", Date: yesterday, CurrentBalance: 200, CreditLimit: 2000, Utilization: 10}}
+	if err = ts.repos.Snapshots.Upsert(ctx, snapshots); err != nil {
+		t.Fatal(err)
+	}
+	// alice's balance went up to 40% since yesterday
+	ts.plaid.mu.Lock()
+	ts.plaid.items["access-alice"].accounts[0].Balances.Current = *plaid.NewNullableFloat32(plaid.PtrFloat32(800))
+	ts.plaid.mu.Unlock()
+
+	var res handlers.SnapshotResponse
+	ts.expect(http.StatusAccepted, http.MethodPost, "/admin/jobs/balance_snapshots", "admin", nil).decode(t, &res)
+	if len(res.UserIds) != 2 {
+		t.Fatalf("got users %v snapshotted, want alice and bob", res.UserIds)
+	}
+	bob, err := ts.repos.Users.GetByClerkId(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the job runs in the background, alice's snapshot is recorded before her crossing is texted
+	today := time.Now().UTC().Format("2006-01-02")
+	for poll := 0; len(ts.sms.messages()) == 0; poll++ {
+		if poll == 50 {
+			t.Fatal("got no text for alice's crossing")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if recorded, _ := ts.repos.Snapshots.ListSince(ctx, alice.ID, today); len(recorded) != 1 || recorded[0].Utilization != 40 {
+		t.Fatalf("got alice's snapshots %+v today, want one at 40%%", recorded)
+	}
+	for poll := 0; ; poll++ {
+		recorded, _ := ts.repos.Snapshots.ListSince(ctx, bob.ID, today)
+		if len(recorded) == 1 {
+			break
+		}
+		if poll == 50 {
+			t.Fatalf("got bob's snapshots %+v today, want one", recorded)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if sent := ts.sms.messages(); len(sent) != 1 {
+		t.Fatalf("got %d texts, want 1 for alice's crossing", len(sent))
+	}
+}
+
+func TestCurrentUtilizationSkipsCardsWithoutLiabilities(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.addUser("alice")
+	item := (&fakeItem{}).card("card", "Card", 600, 1000).card("new-card", "New Card", 100, 500)
+	// plaid has no liabilities yet for a card linked today
+	item.liabilities = item.liabilities[:1]
+	ts.link(user, "access-alice", item)
+
+	var res models.CurrentUtilizationResponse
+	ts.expect(http.StatusOK, http.MethodGet, "/api/core/utilization/", "alice", nil).decode(t, &res)
+	if len(res.Accounts) != 1 || res.Accounts[0].AccountId != "card" {
+		t.Fatalf("got accounts %+v, want only the card with liabilities", res.Accounts)
+	}
+}
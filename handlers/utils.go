@@ -3,87 +3,70 @@ package handlers
 import (
 	"context"
 	"fmt"
-	"github.com/go-redis/cache/v8"
+	"github.com/jalexanderII/zero-railway/apierror"
 	client "github.com/jalexanderII/zero-railway/app/clients"
 	"net/http"
-	"os"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/jalexanderII/zero-railway/database"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/metrics"
 	"github.com/jalexanderII/zero-railway/models"
-	"github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/jalexanderII/zero-railway/repository"
+	"github.com/jalexanderII/zero-railway/tracing"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-import "go.mongodb.org/mongo-driver/mongo"
+// itemFetchConcurrency bounds the Items of a user fetched from plaid at once
+const itemFetchConcurrency = 4
 
 type DBInsertResponse struct {
 	InsertedId primitive.ObjectID `json:"inserted_id" bson:"_id"`
 }
 
+// Handler holds the dependencies shared by every route
 type Handler struct {
-	Db     *mongo.Collection
-	UserDb *mongo.Collection
-	P      *client.PlaidClient
-	L      *logrus.Logger
-	C      context.Context
-	H      *http.Client
+	*repository.Repositories
+	P *client.PlaidClient
+	H *http.Client
 }
 
-func NewHandler(collectionName string, l *logrus.Logger, p *client.PlaidClient) *Handler {
+func NewHandler(repos *repository.Repositories, p *client.PlaidClient) *Handler {
 	return &Handler{
-		Db:     database.GetCollection(collectionName),
-		UserDb: database.GetCollection(os.Getenv("USER_COLLECTION")),
-		P:      p,
-		L:      l,
-		C:      context.Background(),
-		H:      &http.Client{Timeout: 10 * time.Second},
+		Repositories: repos,
+		P:            p,
+		// H only calls the planning service
+		H: &http.Client{Timeout: 10 * time.Second, Transport: tracing.Transport(&metrics.PlanningTransport{})},
 	}
 }
 
-func (h *Handler) GetUserByEmail(email string, rcache *cache.Cache) (*models.User, error) {
-	var cachedUser models.User
-	err := rcache.Get(h.C, email, &cachedUser)
-	if err == cache.ErrCacheMiss {
-		var user models.User
-		filter := bson.M{"email": email}
-		err := h.UserDb.FindOne(h.C, filter).Decode(&user)
+func (h *Handler) GetUserByEmail(ctx context.Context, email string, rcache *caching.Store) (*models.User, error) {
+	cachedUser, err := rcache.Users.GetByEmail(ctx, email)
+	if err == caching.ErrMiss {
+		user, err := h.Users.GetByEmail(ctx, email)
 		if err != nil {
-			h.L.Error("[UserDB] Error getting user", "error", err)
+			logging.FromContext(ctx).WithError(err).Error("[UserDB] Error getting user")
 			return nil, err
 		}
-
-		if err := rcache.Set(&cache.Item{
-			Ctx:   h.C,
-			Key:   email,
-			Value: &user,
-			TTL:   24 * time.Hour,
-		}); err != nil {
+		if err := rcache.Users.Set(ctx, user); err != nil {
 			return nil, err
 		}
-
-		return &user, nil
+		return user, nil
 	} else if err != nil {
 		return nil, err
 	}
 
-	return &cachedUser, nil
+	return cachedUser, nil
 }
 
-func (h *Handler) GetUserByID(userId string) (*models.User, error) {
+func (h *Handler) GetUserByID(ctx context.Context, userId string) (*models.User, error) {
 	Id, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
 		return nil, err
 	}
-	var user models.User
-	filter := bson.M{"_id": Id}
-	if err = h.UserDb.FindOne(h.C, filter).Decode(&user); err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return h.Users.GetByID(ctx, Id)
 }
 
 func FiberJsonResponse(c *fiber.Ctx, httpStatus int, status, message string, data any) error {
@@ -113,18 +96,9 @@ func DeleteCookie(c *fiber.Ctx, name string) {
 	})
 }
 
-// GetPlaidErrorCode will get the error code from the error message and return it as a string
+// GetPlaidErrorCode will get the plaid error code of err and return it as a string
 func GetPlaidErrorCode(err error) string {
-	errorMessage := err.Error()
-
-	// first get the index of the substring code
-	start := strings.Index(errorMessage, ", code: ") + 8
-
-	// get the end by creating a substring and getting the index of the first comma
-	end := strings.Index(errorMessage[start:], ", ") + start
-
-	// return the substring with the window of indexes
-	return errorMessage[start:end]
+	return client.GetPlaidErrorCode(err)
 }
 
 func FormatPhoneNumber(pn string) string {
@@ -135,74 +109,155 @@ func FormatPhoneNumber(pn string) string {
 	return pn
 }
 
-func FetchDataAndCache(userID primitive.ObjectID, plaidClient *client.PlaidClient, rcache *cache.Cache, reset bool) (*models.AccountDetailsResponse, error) {
-	var cachedAccountDetails models.AccountDetailsResponse
-	if reset {
-		err := rcache.Delete(plaidClient.C, userID.Hex())
+// FetchDataAndCache returns the account details of the user, fetching them from plaid when none are
+// cached or reset is set. Concurrent fetches for a user are collapsed into one across replicas. An Item
+// that fails leaves its accounts out of the details, only failing the fetch when every Item does.
+func FetchDataAndCache(ctx context.Context, h *Handler, userID primitive.ObjectID, rcache *caching.Store, reset bool) (*models.AccountDetailsResponse, error) {
+	fetch := func(ctx context.Context) (*models.AccountDetailsResponse, error) {
+		tokens, err := h.Tokens.ListByUser(ctx, userID)
 		if err != nil {
+			logging.FromContext(ctx).WithError(err).Error("[PlaidDb] Error getting all users tokens")
 			return nil, err
 		}
-	}
-	err := rcache.Get(plaidClient.C, userID.Hex(), &cachedAccountDetails)
-	if err == cache.ErrCacheMiss || reset {
-		tokens, err := plaidClient.GetTokens(userID)
+
+		results, err := fetchItems(ctx, h, tokens)
 		if err != nil {
 			return nil, err
 		}
-
 		var accounts []*models.Account
 		var transactions []*models.Transaction
-		for _, token := range *tokens {
-			accountDetails, err := plaidClient.GetAccountDetails(&token)
-			if err != nil {
-				return nil, err
+		// the accounts whose transactions were fetched, so the stored ones plaid dropped can be pruned
+		fetchedAccounts := make(map[string]bool)
+		items := make([]*models.ItemSyncStatus, len(results))
+		for idx, result := range results {
+			items[idx] = result.status
+			if result.details != nil {
+				accounts = append(accounts, result.details.Accounts...)
+				transactions = append(transactions, result.details.Transactions...)
+				for _, acc := range result.details.Accounts {
+					if acc.NotNull() {
+						fetchedAccounts[acc.PlaidAccountId] = true
+					}
+				}
+				for _, trxn := range result.details.Transactions {
+					fetchedAccounts[trxn.PlaidAccountId] = true
+				}
 			}
-			accounts = append(accounts, accountDetails.Accounts...)
-			transactions = append(transactions, accountDetails.Transactions...)
 		}
+		if err = h.Accounts.Upsert(ctx, accounts); err != nil {
+			logging.FromContext(ctx).WithError(err).Error("[AccDb] Error saving accounts")
+			return nil, err
+		}
+		if err = h.Transactions.Upsert(ctx, transactions); err != nil {
+			logging.FromContext(ctx).WithError(err).Error("[TrxnDb] Error saving transactions")
+			return nil, err
+		}
+		accountIds := make([]string, 0, len(fetchedAccounts))
+		for id := range fetchedAccounts {
+			accountIds = append(accountIds, id)
+		}
+		// a day of slack, plaid's window starts at a local date while transactions are dated in UTC
+		since := time.Now().Add(-client.TransactionsWindow).AddDate(0, 0, 1).UnixMilli()
+		if _, err = h.Transactions.Prune(ctx, userID, accountIds, since, transactions); err != nil {
+			logging.FromContext(ctx).WithError(err).Error("[TrxnDb] Error pruning transactions")
+			return nil, err
+		}
+
 		consolidatedAccountDetails := models.AccountDetailsResponse{
 			Accounts:     accounts,
 			Transactions: transactions,
+			Items:        items,
 		}
+		h.P.RunIngestHooks(ctx, userID, &consolidatedAccountDetails)
+		return &consolidatedAccountDetails, nil
+	}
 
-		if err := rcache.Set(&cache.Item{
-			Ctx:   plaidClient.C,
-			Key:   userID.Hex(),
-			Value: &consolidatedAccountDetails,
-			TTL:   24 * time.Hour,
-		}); err != nil {
-			return nil, err
-		}
+	if reset {
+		return rcache.AccountDetails.Refresh(ctx, userID, fetch)
+	}
+	return rcache.AccountDetails.Fetch(ctx, userID, fetch)
+}
 
-		return &consolidatedAccountDetails, nil
-	} else if err != nil {
-		return nil, err
+// itemFetch is the outcome of fetching the accounts of one Item, details are nil if it failed
+type itemFetch struct {
+	details *models.AccountDetailsResponse
+	status  *models.ItemSyncStatus
+	err     error
+}
+
+// fetchItems fetches the accounts of every Item at once, in the order of tokens, returning an error
+// only if no Item could be fetched
+func fetchItems(ctx context.Context, h *Handler, tokens []models.Token) ([]*itemFetch, error) {
+	results := make([]*itemFetch, len(tokens))
+	sem := make(chan struct{}, itemFetchConcurrency)
+	var wg sync.WaitGroup
+	for idx := range tokens {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			token := &tokens[idx]
+			result := &itemFetch{status: &models.ItemSyncStatus{
+				TokenId:      token.ID,
+				ItemId:       token.ItemId,
+				Institution:  token.Institution,
+				Status:       models.ITEM_SYNC_OK,
+				LastSyncedAt: token.LastSyncedAt,
+			}}
+			results[idx] = result
+			log := logging.FromContext(ctx).WithField("item_id", token.ItemId)
+
+			result.details, result.err = h.P.GetAccountDetails(ctx, token)
+			if result.err != nil {
+				log.WithError(result.err).Error("[Plaid] Error fetching item accounts")
+				result.status.Status = models.ITEM_SYNC_ERROR
+				result.status.ErrorCode = GetPlaidErrorCode(result.err)
+				return
+			}
+			syncedAt := time.Now()
+			result.status.LastSyncedAt = &syncedAt
+			if err := h.Tokens.MarkSynced(ctx, token.ID, syncedAt); err != nil {
+				log.WithError(err).Error("[PlaidDb] Error recording item sync")
+			}
+		}(idx)
 	}
+	wg.Wait()
 
-	return &cachedAccountDetails, nil
+	for _, result := range results {
+		if result.err == nil {
+			return results, nil
+		}
+	}
+	if len(results) > 0 {
+		return nil, results[0].err
+	}
+	return results, nil
 }
 
-func FetchAccountDetails(userID primitive.ObjectID, plaidClient *client.PlaidClient, rcache *cache.Cache) ([]*models.Account, error) {
-	AccountDetails, err := FetchDataAndCache(userID, plaidClient, rcache, false)
+func FetchAccountDetails(ctx context.Context, h *Handler, userID primitive.ObjectID, rcache *caching.Store) ([]*models.Account, error) {
+	AccountDetails, err := FetchDataAndCache(ctx, h, userID, rcache, false)
 	if err != nil {
 		return nil, err
 	}
 	return AccountDetails.Accounts, nil
 }
 
-func FetchTransactionDetails(userID primitive.ObjectID, plaidClient *client.PlaidClient, rcache *cache.Cache) ([]*models.Transaction, error) {
-	AccountDetails, err := FetchDataAndCache(userID, plaidClient, rcache, false)
+func FetchTransactionDetails(ctx context.Context, h *Handler, userID primitive.ObjectID, rcache *caching.Store) ([]*models.Transaction, error) {
+	AccountDetails, err := FetchDataAndCache(ctx, h, userID, rcache, false)
 	if err != nil {
 		return nil, err
 	}
 	return AccountDetails.Transactions, nil
 }
 
-func GetUserFromCache(c *fiber.Ctx, rcache *cache.Cache) (*models.User, error) {
-	var user models.User
-	err := rcache.Get(c.Context(), c.Get("Clerk"), &user)
-	if err != nil {
-		return nil, fmt.Errorf("user not found in cache %s", c.Get("Clerk"))
+func GetUserFromCache(c *fiber.Ctx, rcache *caching.Store) (*models.User, error) {
+	user, err := rcache.Users.GetByClerkId(c.UserContext(), c.Get("Clerk"))
+	if err == caching.ErrMiss {
+		return nil, apierror.Unauthorized("no signed in user for the request")
+	} else if err != nil {
+		return nil, apierror.Internal("failed getting user from cache", err)
 	}
-	return &user, nil
+	return user, nil
 }
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/config"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDKey is the fiber local holding the request id, set by the requestid middleware
+const RequestIDKey = "requestid"
+
+type entryKey struct{}
+
+// New configures the process wide logger, JSON formatted in production so the platform can index the
+// fields, and returns it
+func New(cfg *config.Config) (*logrus.Logger, error) {
+	level, err := logrus.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	l := logrus.StandardLogger()
+	l.SetLevel(level)
+	if cfg.IsProduction() {
+		l.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	} else {
+		l.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	}
+	return l, nil
+}
+
+// WithEntry returns a copy of ctx carrying entry
+func WithEntry(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// WithFields returns a copy of ctx whose logger also carries fields
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return WithEntry(ctx, FromContext(ctx).WithFields(fields))
+}
+
+// FromContext returns the logger of ctx, the process wide logger when ctx has none
+func FromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(entryKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// Ctx returns the logger of the request, with the route once one has matched
+func Ctx(c *fiber.Ctx) *logrus.Entry {
+	entry := FromContext(c.UserContext())
+	if route := c.Route().Path; route != "" && route != "/" {
+		return entry.WithField("route", route)
+	}
+	return entry
+}
+
+// Middleware stores a logger carrying the request id, method and path, and the trace id when the request
+// is sampled, in the request's user context, then logs the request once it has been handled. It must run
+// after the tracing and requestid middlewares.
+func Middleware(l *logrus.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		requestId, _ := c.Locals(RequestIDKey).(string)
+
+		fields := logrus.Fields{
+			"request_id": requestId,
+			"method":     c.Method(),
+			"path":       c.Path(),
+		}
+		span := trace.SpanFromContext(c.UserContext())
+		if sc := span.SpanContext(); sc.IsValid() {
+			fields["trace_id"] = sc.TraceID().String()
+			span.SetAttributes(attribute.String("request.id", requestId))
+		}
+		c.SetUserContext(WithEntry(c.UserContext(), l.WithFields(fields)))
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		// errors returned to fiber are turned into responses after the middleware chain
+		if fe, ok := err.(*fiber.Error); ok {
+			status = fe.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+		entry := Ctx(c).WithFields(logrus.Fields{
+			"status":     status,
+			"latency_ms": time.Since(start).Milliseconds(),
+			"ip":         c.IP(),
+		})
+		if err != nil {
+			entry = entry.WithError(err)
+		}
+		if status >= fiber.StatusInternalServerError {
+			entry.Error("request failed")
+		} else {
+			entry.Info("request handled")
+		}
+		return err
+	}
+}
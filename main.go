@@ -1,22 +1,13 @@
 package main
 
 import (
-	"github.com/jalexanderII/zero-railway/config"
 	"os"
 
 	"github.com/jalexanderII/zero-railway/app"
+	"github.com/jalexanderII/zero-railway/config"
+	"github.com/jalexanderII/zero-railway/logging"
 )
 
-func getPort() string {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = ":8080"
-	} else {
-		port = ":" + port
-	}
-	return port
-}
-
 // @title Zero Fintech Backend API
 // @version 0.1
 // @description This is the backend API for the Zero Fintech app.
@@ -25,13 +16,26 @@ func getPort() string {
 // @host localhost:8080
 // @BasePath /
 func main() {
-	// load env
-	err := config.LoadENV()
+	// load config
+	cfg, err := config.Load()
 	if err != nil {
 		panic(err)
 	}
+	l, err := logging.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+	l.WithField("config", cfg.String()).Info("config loaded")
+
+	// go run . migrate up | down [steps] | status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err = app.RunMigrations(cfg, l, os.Args[2:]); err != nil {
+			panic(err)
+		}
+		return
+	}
 
-	err = app.SetupAndRunApp(getPort())
+	err = app.SetupAndRunApp(cfg, l)
 	if err != nil {
 		panic(err)
 	}
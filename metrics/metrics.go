@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// Cache names used to label cache lookups
+const (
+	CacheAccountDetails = "account_details"
+	CacheUserByEmail    = "user_by_email"
+	CacheUserByClerkId  = "user_by_clerk_id"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	plaidRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "plaid_api_requests_total",
+		Help: "Plaid API calls, by endpoint.",
+	}, []string{"endpoint"})
+	plaidErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "plaid_api_errors_total",
+		Help: "Failed Plaid API calls, by endpoint and plaid error code.",
+	}, []string{"endpoint", "error_code"})
+	plaidDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "plaid_api_request_duration_seconds",
+		Help:    "Plaid API call latency, by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	planningDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "planning_request_duration_seconds",
+		Help:    "Planning service request latency, by method, endpoint and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "endpoint", "status"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_lookups_total",
+		Help: "Redis cache lookups, by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	smsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_sent_total",
+		Help: "SMS sends, by outcome (success or failure).",
+	}, []string{"outcome"})
+)
+
+// Handler serves the metrics in the prometheus exposition format
+func Handler() fiber.Handler {
+	handler := fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+	return func(c *fiber.Ctx) error {
+		handler(c.Context())
+		return nil
+	}
+}
+
+// Middleware records the count and latency of every request, labelled by the matched route so that
+// path parameters don't create a series per value
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		// errors returned to fiber are turned into responses after the middleware chain
+		if fe, ok := err.(*fiber.Error); ok {
+			status = fe.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+		route := c.Route().Path
+		if string(c.Response().Header.Peek("X-Cache")) == "hit" {
+			// served by the cache middleware before reaching a route
+			route = "cached"
+		} else if route == "" || route == "/" && c.Path() != "/" {
+			route = "unmatched"
+		}
+
+		labels := prometheus.Labels{"method": c.Method(), "route": route, "status": strconv.Itoa(status)}
+		httpRequests.With(labels).Inc()
+		httpDuration.With(labels).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// ObservePlaidCall records a Plaid API call, errorCode is only used when err is not nil
+func ObservePlaidCall(endpoint string, start time.Time, err error, errorCode string) {
+	plaidRequests.WithLabelValues(endpoint).Inc()
+	plaidDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		plaidErrors.WithLabelValues(endpoint, errorCode).Inc()
+	}
+}
+
+// ObserveCacheLookup records whether a lookup in the named cache was a hit
+func ObserveCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// ObserveSMS records the outcome of sending an SMS
+func ObserveSMS(err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	smsSent.WithLabelValues(outcome).Inc()
+}
+
+// objectIdSegment matches path segments holding a mongo object id
+var objectIdSegment = regexp.MustCompile(`^[0-9a-fA-F]{24}$`)
+
+// PlanningTransport instruments the requests made to the planning service
+type PlanningTransport struct {
+	// Base is the transport the requests are sent with, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+func (t *PlanningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	planningDuration.WithLabelValues(req.Method, planningEndpoint(req.URL.Path), status).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// planningEndpoint replaces the ids in a planning service path, e.g. /waterfall/:id
+func planningEndpoint(path string) string {
+	segments := strings.Split(path, "/")
+	for idx, segment := range segments {
+		if objectIdSegment.MatchString(segment) {
+			segments[idx] = ":id"
+		}
+	}
+	if endpoint := strings.Join(segments, "/"); endpoint != "" {
+		return endpoint
+	}
+	return "/"
+}
@@ -0,0 +1,29 @@
+package models
+
+type CategorySpend struct {
+	Category string  `json:"category" bson:"_id"`
+	Total    float64 `json:"total" bson:"total"`
+	Count    int     `json:"count" bson:"count"`
+}
+
+type MerchantSpend struct {
+	Merchant      string  `json:"merchant" bson:"_id"`
+	Total         float64 `json:"total" bson:"total"`
+	Count         int     `json:"count" bson:"count"`
+	AverageAmount float64 `json:"average_amount" bson:"average_amount"`
+	LastDate      int64   `json:"last_date" bson:"last_date"`
+}
+
+// MonthCategorySpend is the spend of a category during a month formatted as YYYY-MM
+type MonthCategorySpend struct {
+	Month    string  `json:"month" bson:"month"`
+	Category string  `json:"category" bson:"category"`
+	Total    float64 `json:"total" bson:"total"`
+}
+
+// ChargeSeries is every charge of a single merchant in chronological order
+type ChargeSeries struct {
+	Merchant string    `json:"merchant" bson:"_id"`
+	Dates    []int64   `json:"dates" bson:"dates"`
+	Amounts  []float64 `json:"amounts" bson:"amounts"`
+}
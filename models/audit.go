@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEvent records an action taken on the data of a user, events are only ever appended
+type AuditEvent struct {
+	ID primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	// ActorId is the user who took the action, unset for actions taken by the system
+	ActorId primitive.ObjectID `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	// UserId is the user whose data the action was taken on
+	UserId primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Action string             `json:"action" bson:"action"`
+	// Target names what the action was taken on, e.g. user:<id>
+	Target string `json:"target,omitempty" bson:"target,omitempty"`
+	Status int    `json:"status,omitempty" bson:"status,omitempty"`
+	// Before and After hold the fields the action changed, with sensitive values redacted
+	Before    map[string]any `json:"before,omitempty" bson:"before,omitempty"`
+	After     map[string]any `json:"after,omitempty" bson:"after,omitempty"`
+	Metadata  map[string]any `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	RequestId string         `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	IP        string         `json:"ip,omitempty" bson:"ip,omitempty"`
+	CreatedAt time.Time      `json:"created_at" bson:"created_at"`
+}
+
+// ItemHealth is the state of a linked plaid Item, as reported by plaid
+type ItemHealth struct {
+	TokenId       primitive.ObjectID `json:"token_id"`
+	UserId        primitive.ObjectID `json:"user_id"`
+	ItemId        string             `json:"item_id"`
+	Institution   string             `json:"institution"`
+	InstitutionID string             `json:"institution_id"`
+	Purpose       Purpose            `json:"purpose"`
+	Healthy       bool               `json:"healthy"`
+	// ErrorCode is the plaid error the Item is in, e.g. ITEM_LOGIN_REQUIRED
+	ErrorCode              string     `json:"error_code,omitempty"`
+	ErrorMessage           string     `json:"error_message,omitempty"`
+	ConsentExpiresAt       *time.Time `json:"consent_expires_at,omitempty"`
+	LastTransactionsUpdate *time.Time `json:"last_transactions_update,omitempty"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultBudgetThresholds are the percentages of a budget's limit that alert when not configured
+var DefaultBudgetThresholds = []int{50, 80, 100}
+
+// Budget is a monthly spending limit for a category, a card, or a category on a card
+type Budget struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserId     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name       string             `json:"name" bson:"name"`
+	Category   string             `json:"category,omitempty" bson:"category,omitempty"`
+	AccountId  string             `json:"account_id,omitempty" bson:"account_id,omitempty"`
+	Limit      float64            `json:"limit" bson:"limit"`
+	Thresholds []int              `json:"thresholds" bson:"thresholds"`
+	UpdatedAt  time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	CreatedAt  time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+}
+
+// BudgetAlert records that a threshold of a budget alerted for a period, so it only alerts once
+type BudgetAlert struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	BudgetId  primitive.ObjectID `json:"budget_id" bson:"budget_id"`
+	UserId    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Period    string             `json:"period" bson:"period"`
+	Threshold int                `json:"threshold" bson:"threshold"`
+	Spend     float64            `json:"spend" bson:"spend"`
+	CreatedAt time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+}
+
+type BudgetRequest struct {
+	Name      string  `json:"name" validate:"required,notblank"`
+	Category  string  `json:"category,omitempty" validate:"required_without=AccountId"`
+	AccountId string  `json:"account_id,omitempty" validate:"required_without=Category"`
+	Limit     float64 `json:"limit" validate:"gt=0"`
+	// Thresholds are percentages of the limit, they default to DefaultBudgetThresholds
+	Thresholds []int `json:"thresholds,omitempty" validate:"unique,dive,gte=1,lte=200"`
+}
+
+type BudgetStatus struct {
+	Budget
+	Period  string  `json:"period"`
+	Spend   float64 `json:"spend"`
+	Percent float64 `json:"percent"`
+}
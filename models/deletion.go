@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DeletionStatus string
+
+const (
+	DELETION_STATUS_PENDING   DeletionStatus = "pending"
+	DELETION_STATUS_RUNNING   DeletionStatus = "running"
+	DELETION_STATUS_COMPLETED DeletionStatus = "completed"
+	DELETION_STATUS_FAILED    DeletionStatus = "failed"
+)
+
+// AccountDeletion tracks the closure of a user's account step by step, so a deletion that fails resumes
+// after the last step done. Once completed it stays behind as the tombstone of the deleted user.
+type AccountDeletion struct {
+	ID      primitive.ObjectID `json:"id" bson:"_id"`
+	UserId  primitive.ObjectID `json:"user_id" bson:"user_id"`
+	ClerkId string             `json:"clerk_id,omitempty" bson:"clerk_id,omitempty"`
+	// Email is only kept until the deletion completes, to purge the user cached under it
+	Email string `json:"-" bson:"email,omitempty"`
+	// Source is who asked for the deletion: user, clerk or admin
+	Source string         `json:"source" bson:"source"`
+	Status DeletionStatus `json:"status" bson:"status"`
+	// Steps are the steps done so far, in order
+	Steps []string `json:"steps" bson:"steps"`
+	// FailedStep and Error describe why the last attempt stopped
+	FailedStep  string     `json:"failed_step,omitempty" bson:"failed_step,omitempty"`
+	Error       string     `json:"error,omitempty" bson:"error,omitempty"`
+	Attempts    int        `json:"attempts" bson:"attempts"`
+	RequestedAt time.Time  `json:"requested_at" bson:"requested_at"`
+	UpdatedAt   time.Time  `json:"updated_at" bson:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// Done reports whether step has already been done
+func (d *AccountDeletion) Done(step string) bool {
+	for _, done := range d.Steps {
+		if done == step {
+			return true
+		}
+	}
+	return false
+}
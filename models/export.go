@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ExportStatus string
+
+const (
+	EXPORT_STATUS_PENDING   ExportStatus = "pending"
+	EXPORT_STATUS_RUNNING   ExportStatus = "running"
+	EXPORT_STATUS_COMPLETED ExportStatus = "completed"
+	EXPORT_STATUS_FAILED    ExportStatus = "failed"
+)
+
+// DataExport is a copy of everything stored about a user, built asynchronously on request. The archive
+// itself is stored apart and only read when downloaded.
+type DataExport struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	UserId primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Status ExportStatus       `json:"status" bson:"status"`
+	Error  string             `json:"error,omitempty" bson:"error,omitempty"`
+	// Size is the size of the archive in bytes
+	Size int `json:"size,omitempty" bson:"size,omitempty"`
+	// DownloadURL is a signed, short lived URL to the archive, set once the export is completed
+	DownloadURL string     `json:"download_url,omitempty" bson:"-"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+	// ExpiresAt is when the export and its archive are removed
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusDegraded    = "degraded"
+	HealthStatusUnavailable = "unavailable"
+
+	DependencyStatusUp   = "up"
+	DependencyStatusDown = "down"
+)
+
+// DependencyHealth is the result of checking a single dependency
+type DependencyHealth struct {
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`
+	Critical  bool     `json:"critical"`
+	LatencyMs float64  `json:"latency_ms"`
+	Error     string   `json:"error,omitempty"`
+	Features  []string `json:"features,omitempty"`
+}
+
+// ReadinessResponse reports the service as ok, degraded when an optional dependency is down, or
+// unavailable when a critical one is. AffectedFeatures lists the features of every dependency down.
+type ReadinessResponse struct {
+	Status           string             `json:"status"`
+	Dependencies     []DependencyHealth `json:"dependencies"`
+	AffectedFeatures []string           `json:"affected_features,omitempty"`
+}
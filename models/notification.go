@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReminderKindOverdue is the kind of reminder sent once an account is past its due date
+const ReminderKindOverdue = "overdue"
+
+// DueDateReminder records a reminder sent for an account's statement due date, so each lead time
+// only reminds once per due date
+type DueDateReminder struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserId    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	AccountId string             `json:"account_id" bson:"account_id"`
+	DueDate   string             `json:"due_date" bson:"due_date"`
+	Kind      string             `json:"kind" bson:"kind"`
+	CreatedAt time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+}
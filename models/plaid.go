@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/plaid/plaid-go/plaid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -36,6 +37,8 @@ type Token struct {
 	Institution   string             `bson:"institution"`
 	InstitutionID string             `bson:"institution_id"`
 	Purpose       Purpose            `bson:"purpose"`
+	// LastSyncedAt is when the accounts of the Item were last fetched from plaid without error
+	LastSyncedAt *time.Time `bson:"last_synced_at,omitempty"`
 }
 
 type CreateLinkTokenResponse struct {
@@ -60,6 +63,36 @@ type PlaidInstitution struct {
 type AccountDetailsResponse struct {
 	Accounts     []*Account     `json:"accounts,omitempty"`
 	Transactions []*Transaction `json:"transactions,omitempty"`
+	// Items is the outcome of the fetch of each linked Item, the accounts of a failed Item are missing
+	Items []*ItemSyncStatus `json:"items,omitempty"`
+}
+
+// Partial reports whether the accounts of some Item could not be fetched
+func (r *AccountDetailsResponse) Partial() bool {
+	for _, item := range r.Items {
+		if item.Status != ITEM_SYNC_OK {
+			return true
+		}
+	}
+	return false
+}
+
+type ItemSync string
+
+const (
+	ITEM_SYNC_OK    ItemSync = "ok"
+	ITEM_SYNC_ERROR ItemSync = "error"
+)
+
+// ItemSyncStatus is the outcome of fetching the accounts of a linked Item from plaid
+type ItemSyncStatus struct {
+	TokenId     primitive.ObjectID `json:"token_id"`
+	ItemId      string             `json:"item_id"`
+	Institution string             `json:"institution"`
+	Status      ItemSync           `json:"status"`
+	// ErrorCode is the plaid error the fetch failed with, e.g. ITEM_LOGIN_REQUIRED
+	ErrorCode    string     `json:"error_code,omitempty"`
+	LastSyncedAt *time.Time `json:"last_synced_at,omitempty"`
 }
 
 type CreateAccountRequest struct {
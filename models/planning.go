@@ -58,47 +58,50 @@ const (
 	DELETE_STATUS_FAILED      DeleteStatus = 3
 )
 
+// PaymentPlan is returned by the planning service, the validate tags apply when a client sends one back
+// to accept it
 type PaymentPlan struct {
 	ID               primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Name             string             `json:"name,omitempty"`
-	PaymentPlanId    string             `json:"payment_plan_id,omitempty"`
+	PaymentPlanId    string             `json:"payment_plan_id,omitempty" validate:"required"`
 	UserId           string             `json:"user_id,omitempty"`
-	PaymentTaskId    []string           `json:"payment_task_id,omitempty"`
-	Amount           float64            `json:"amount,omitempty"`
-	Timeline         float64            `json:"timeline,omitempty"`
-	PaymentFreq      PaymentFrequency   `json:"payment_freq,omitempty"`
-	AmountPerPayment float64            `json:"amount_per_payment,omitempty"`
-	PlanType         PlanType           `json:"plan_type,omitempty"`
+	PaymentTaskId    []string           `json:"payment_task_id,omitempty" validate:"dive,required"`
+	Amount           float64            `json:"amount,omitempty" validate:"gt=0"`
+	Timeline         float64            `json:"timeline,omitempty" validate:"gte=0"`
+	PaymentFreq      PaymentFrequency   `json:"payment_freq,omitempty" validate:"gte=0,lte=4"`
+	AmountPerPayment float64            `json:"amount_per_payment,omitempty" validate:"gte=0"`
+	PlanType         PlanType           `json:"plan_type,omitempty" validate:"gte=0,lte=2"`
 	EndDate          string             `json:"end_date,omitempty"`
 	Active           bool               `json:"active,omitempty"`
-	Status           PaymentStatus      `json:"status,omitempty"`
-	PaymentAction    []PaymentAction    `json:"payment_action,omitempty"`
-	Transactions     []string           `json:"transactions" bson:"transactions,omitempty"`
+	Status           PaymentStatus      `json:"status,omitempty" validate:"gte=0,lte=4"`
+	PaymentAction    []PaymentAction    `json:"payment_action,omitempty" validate:"required,min=1,dive"`
+	Transactions     []string           `json:"transactions" bson:"transactions,omitempty" validate:"unique,dive,required"`
 }
 
 type PaymentAction struct {
 	ID              primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
-	AccountId       string              `json:"account_id,omitempty"`
-	Amount          float64             `json:"amount,omitempty"`
+	AccountId       string              `json:"account_id,omitempty" validate:"required"`
+	Amount          float64             `json:"amount,omitempty" validate:"gt=0"`
 	TransactionDate string              `json:"transaction_date,omitempty"`
-	Status          PaymentActionStatus `json:"status,omitempty"`
+	Status          PaymentActionStatus `json:"status,omitempty" validate:"gte=0,lte=3"`
 }
 
 // MetaData is a DB Serialization of Proto MetaData
 type MetaData struct {
-	PreferredPlanType         int32   `json:"preferred_plan_type"`
-	PreferredTimelineInMonths float64 `json:"preferred_timeline_in_months"`
-	PreferredPaymentFreq      int32   `json:"preferred_payment_freq"`
+	PreferredPlanType         int32   `json:"preferred_plan_type" validate:"gte=0,lte=2"`
+	PreferredTimelineInMonths float64 `json:"preferred_timeline_in_months" validate:"gte=0,lte=120"`
+	PreferredPaymentFreq      int32   `json:"preferred_payment_freq" validate:"gte=0,lte=4"`
 }
 
 type AccountInfo struct {
-	TransactionIds []string `json:"transaction_ids,omitempty"`
-	AccountId      string   `json:"account_id,omitempty"`
-	Amount         float64  `json:"amount,omitempty"`
+	TransactionIds []string `json:"transaction_ids,omitempty" validate:"unique,dive,required"`
+	AccountId      string   `json:"account_id,omitempty" validate:"required"`
+	Amount         float64  `json:"amount,omitempty" validate:"gt=0"`
 }
 
+// GetPaymentPlanRequest is sent by the client to create payment plans, UserId is set from the caller
 type GetPaymentPlanRequest struct {
-	AccountInfo []AccountInfo `json:"account_info,omitempty"`
+	AccountInfo []AccountInfo `json:"account_info,omitempty" validate:"required,min=1,dive"`
 	UserId      string        `json:"user_id,omitempty"`
 	MetaData    MetaData      `json:"meta_data,omitempty"`
 	SavePlan    bool          `json:"save_plan"`
@@ -43,4 +43,5 @@ type Transaction struct {
 	UpdatedAt            time.Time           `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 	CreatedAt            time.Time           `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	InPlan               bool                `json:"in_plan" bson:"in_plan"`
+	PaymentPlanId        string              `json:"payment_plan_id,omitempty" bson:"payment_plan_id,omitempty"`
 }
@@ -10,11 +10,65 @@ import (
 type User struct {
 	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Username    string             `json:"username" bson:"username"`
-	Email       string             `json:"email" bson:"email"`
+	Email       string             `json:"email" bson:"email" validate:"required,email"`
 	PhoneNumber string             `json:"phone_number" bson:"phone_number"`
 	ClerkId     string             `json:"clerk_id" bson:"clerk_id"`
-	UpdatedAt   time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
-	CreatedAt   time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	// Roles grant rights beyond the user's own data, they are only set in the database
+	Roles                   []Role                   `json:"roles,omitempty" bson:"roles,omitempty"`
+	NotificationPreferences *NotificationPreferences `json:"notification_preferences,omitempty" bson:"notification_preferences,omitempty"`
+	UpdatedAt               time.Time                `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	CreatedAt               time.Time                `json:"created_at,omitempty" bson:"created_at,omitempty"`
+}
+
+// NotificationPreferences controls which SMS reminders a user receives
+type NotificationPreferences struct {
+	DueDateReminders bool `json:"due_date_reminders" bson:"due_date_reminders"`
+	// ReminderDays are the number of days before a statement due date to send a reminder
+	ReminderDays  []int `json:"reminder_days" bson:"reminder_days" validate:"dive,gte=0,lte=30"`
+	OverdueAlerts bool  `json:"overdue_alerts" bson:"overdue_alerts"`
+	// BudgetAlerts and UtilizationAlerts are sent unless turned off, including for preferences saved
+	// before they existed
+	BudgetAlerts      *bool `json:"budget_alerts,omitempty" bson:"budget_alerts,omitempty"`
+	UtilizationAlerts *bool `json:"utilization_alerts,omitempty" bson:"utilization_alerts,omitempty"`
+}
+
+func (p NotificationPreferences) SendBudgetAlerts() bool {
+	return p.BudgetAlerts == nil || *p.BudgetAlerts
+}
+
+func (p NotificationPreferences) SendUtilizationAlerts() bool {
+	return p.UtilizationAlerts == nil || *p.UtilizationAlerts
+}
+
+// DefaultNotificationPreferences are used for users who never set their own
+var DefaultNotificationPreferences = NotificationPreferences{
+	DueDateReminders: true,
+	ReminderDays:     []int{7, 3, 1},
+	OverdueAlerts:    true,
+}
+
+// Role is a set of rights a user may be granted
+type Role string
+
+const (
+	// RoleAdmin may act on every user's data
+	RoleAdmin Role = "admin"
+)
+
+func (u *User) HasRole(role Role) bool {
+	if u == nil {
+		return false
+	}
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) IsAdmin() bool {
+	return u.HasRole(RoleAdmin)
 }
 
 func (u *User) GetID() *primitive.ObjectID {
@@ -27,6 +81,13 @@ func (u *User) GetID() *primitive.ObjectID {
 	return &u.ID
 }
 
+func (u *User) GetNotificationPreferences() NotificationPreferences {
+	if u == nil || u.NotificationPreferences == nil {
+		return DefaultNotificationPreferences
+	}
+	return *u.NotificationPreferences
+}
+
 type ClerkUserEvent struct {
 	Data   ClerkUser `json:"data"`
 	Object string    `json:"object"`
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UtilizationAlertThresholds are the utilization percentages that alert the user when crossed
+var UtilizationAlertThresholds = []float64{30, 50}
+
+// BalanceSnapshot is the balance of a credit account at the end of a day
+type BalanceSnapshot struct {
+	ID             primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserId         primitive.ObjectID `json:"user_id" bson:"user_id"`
+	AccountId      string             `json:"account_id" bson:"account_id"`
+	Name           string             `json:"name" bson:"name"`
+	Date           string             `json:"date" bson:"date"`
+	CurrentBalance float64            `json:"current_balance" bson:"current_balance"`
+	CreditLimit    float64            `json:"credit_limit" bson:"credit_limit"`
+	Utilization    float64            `json:"utilization" bson:"utilization"`
+	UpdatedAt      time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	CreatedAt      time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+}
+
+// UtilizationAlert records that the utilization of an account, or the overall one when AccountId is
+// empty, crossed a threshold on a day, so it only alerts once
+type UtilizationAlert struct {
+	ID          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserId      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	AccountId   string             `json:"account_id" bson:"account_id"`
+	Date        string             `json:"date" bson:"date"`
+	Threshold   float64            `json:"threshold" bson:"threshold"`
+	Utilization float64            `json:"utilization" bson:"utilization"`
+	CreatedAt   time.Time          `json:"created_at,omitempty" bson:"created_at,omitempty"`
+}
+
+type UtilizationPoint struct {
+	Date           string  `json:"date"`
+	CurrentBalance float64 `json:"current_balance"`
+	CreditLimit    float64 `json:"credit_limit"`
+	Utilization    float64 `json:"utilization"`
+}
+
+type AccountUtilizationHistory struct {
+	AccountId string             `json:"account_id"`
+	Name      string             `json:"name"`
+	History   []UtilizationPoint `json:"history"`
+}
+
+type UtilizationHistoryResponse struct {
+	Overall  []UtilizationPoint          `json:"overall"`
+	Accounts []AccountUtilizationHistory `json:"accounts"`
+}
+
+// UtilizationRecommendation is how much to pay on a card before its next statement is issued to
+// report a utilization under the target
+type UtilizationRecommendation struct {
+	AccountId         string  `json:"account_id"`
+	Name              string  `json:"name"`
+	CurrentBalance    float64 `json:"current_balance"`
+	CreditLimit       float64 `json:"credit_limit"`
+	Utilization       float64 `json:"utilization"`
+	TargetUtilization float64 `json:"target_utilization"`
+	PaymentAmount     float64 `json:"payment_amount"`
+	NextStatementDate string  `json:"next_statement_date,omitempty"`
+	Message           string  `json:"message"`
+}
+
+type AccountUtilization struct {
+	AccountId string `json:"account_id"`
+	Name      string `json:"name"`
+	UtilizationPoint
+}
+
+type CurrentUtilizationResponse struct {
+	Overall         UtilizationPoint            `json:"overall"`
+	Accounts        []AccountUtilization        `json:"accounts"`
+	Recommendations []UtilizationRecommendation `json:"recommendations"`
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AccountRepo stores the accounts fetched from plaid along with their liabilities
+type AccountRepo interface {
+	// Upsert saves the accounts keyed by user and plaid account id
+	Upsert(ctx context.Context, accounts []*models.Account) error
+	// ListWithDueDates returns every credit account that has a payment due date or is overdue
+	ListWithDueDates(ctx context.Context) ([]models.Account, error)
+	ListByUser(ctx context.Context, userId primitive.ObjectID) ([]models.Account, error)
+	DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error)
+}
+
+type MongoAccountRepo struct {
+	Db *mongo.Collection
+}
+
+func NewMongoAccountRepo(db *mongo.Collection) *MongoAccountRepo {
+	return &MongoAccountRepo{Db: db}
+}
+
+func (r *MongoAccountRepo) Upsert(ctx context.Context, accounts []*models.Account) error {
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, len(accounts))
+	for _, account := range accounts {
+		if !account.NotNull() {
+			continue
+		}
+		fields, err := toFields(account)
+		if err != nil {
+			return err
+		}
+		delete(fields, "created_at")
+		fields["updated_at"] = now
+
+		filter := bson.M{"user_id": account.UserId, "plaid_account_id": account.PlaidAccountId}
+		update := bson.M{"$set": fields, "$setOnInsert": bson.M{"created_at": now}}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+
+	_, err := r.Db.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (r *MongoAccountRepo) ListWithDueDates(ctx context.Context) ([]models.Account, error) {
+	var accounts []models.Account
+	filter := bson.M{"type": "credit", "$or": bson.A{
+		bson.M{"next_payment_due_date": bson.M{"$nin": bson.A{"", nil}}},
+		bson.M{"is_overdue": true},
+	}}
+	cursor, err := r.Db.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func (r *MongoAccountRepo) ListByUser(ctx context.Context, userId primitive.ObjectID) ([]models.Account, error) {
+	accounts := make([]models.Account, 0)
+	cursor, err := r.Db.Find(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// toFields converts a document to its bson fields so they can be used in a $set
+func toFields(doc any) (bson.M, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.M
+	if err = bson.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func (r *MongoAccountRepo) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error) {
+	res, err := r.Db.DeleteMany(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+type MemoryAccountRepo struct {
+	mu       sync.RWMutex
+	accounts map[string]models.Account
+}
+
+func NewMemoryAccountRepo() *MemoryAccountRepo {
+	return &MemoryAccountRepo{accounts: make(map[string]models.Account)}
+}
+
+func (r *MemoryAccountRepo) Upsert(_ context.Context, accounts []*models.Account) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, account := range accounts {
+		if !account.NotNull() {
+			continue
+		}
+		key := account.UserId.Hex() + "/" + account.PlaidAccountId
+		saved := *account
+		saved.CreatedAt = now
+		if existing, ok := r.accounts[key]; ok {
+			saved.CreatedAt = existing.CreatedAt
+		}
+		saved.UpdatedAt = now
+		r.accounts[key] = saved
+	}
+	return nil
+}
+
+func (r *MemoryAccountRepo) ListWithDueDates(_ context.Context) ([]models.Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var accounts []models.Account
+	for _, account := range r.accounts {
+		if account.Type == "credit" && (account.NextPaymentDueDate != "" || account.IsOverdue) {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
+}
+
+func (r *MemoryAccountRepo) ListByUser(_ context.Context, userId primitive.ObjectID) ([]models.Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	accounts := make([]models.Account, 0)
+	for _, account := range r.accounts {
+		if account.UserId == userId {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
+}
+
+func (r *MemoryAccountRepo) DeleteByUser(_ context.Context, userId primitive.ObjectID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted int64
+	for key, account := range r.accounts {
+		if account.UserId == userId {
+			delete(r.accounts, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditFilter selects audit events, every unset field matches all events
+type AuditFilter struct {
+	ActorId primitive.ObjectID
+	UserId  primitive.ObjectID
+	Action  string
+	Target  string
+	// Since is inclusive and Until exclusive
+	Since *time.Time
+	Until *time.Time
+}
+
+type AuditQuery struct {
+	Filter AuditFilter
+	// Before resumes a listing right after the event with this id, events are listed newest first
+	Before primitive.ObjectID
+	Limit  int
+}
+
+// AuditRepo stores the audit trail, events can be appended but never changed or removed
+type AuditRepo interface {
+	Append(ctx context.Context, event *models.AuditEvent) error
+	List(ctx context.Context, q AuditQuery) ([]models.AuditEvent, error)
+	// Each calls fn with every event matching the filter, oldest first, stopping at the first error
+	Each(ctx context.Context, f AuditFilter, fn func(*models.AuditEvent) error) error
+}
+
+type MongoAuditRepo struct {
+	Db *mongo.Collection
+}
+
+func NewMongoAuditRepo(db *mongo.Collection) *MongoAuditRepo {
+	return &MongoAuditRepo{Db: db}
+}
+
+func (r *MongoAuditRepo) Append(ctx context.Context, event *models.AuditEvent) error {
+	event.ID = primitive.NewObjectID()
+	event.CreatedAt = time.Now()
+	_, err := r.Db.InsertOne(ctx, event)
+	return err
+}
+
+func (r *MongoAuditRepo) List(ctx context.Context, q AuditQuery) ([]models.AuditEvent, error) {
+	filter := auditFilter(q.Filter)
+	if !q.Before.IsZero() {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.M{"$lt": q.Before}})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	cursor, err := r.Db.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]models.AuditEvent, 0)
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *MongoAuditRepo) Each(ctx context.Context, f AuditFilter, fn func(*models.AuditEvent) error) error {
+	cursor, err := r.Db.Find(ctx, auditFilter(f), options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var event models.AuditEvent
+		if err = cursor.Decode(&event); err != nil {
+			return err
+		}
+		if err = fn(&event); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func auditFilter(f AuditFilter) bson.D {
+	filter := bson.D{}
+	if !f.ActorId.IsZero() {
+		filter = append(filter, bson.E{Key: "actor_id", Value: f.ActorId})
+	}
+	if !f.UserId.IsZero() {
+		filter = append(filter, bson.E{Key: "user_id", Value: f.UserId})
+	}
+	if f.Action != "" {
+		filter = append(filter, bson.E{Key: "action", Value: f.Action})
+	}
+	if f.Target != "" {
+		filter = append(filter, bson.E{Key: "target", Value: f.Target})
+	}
+	created := bson.M{}
+	if f.Since != nil {
+		created["$gte"] = *f.Since
+	}
+	if f.Until != nil {
+		created["$lt"] = *f.Until
+	}
+	if len(created) > 0 {
+		filter = append(filter, bson.E{Key: "created_at", Value: created})
+	}
+	return filter
+}
+
+type MemoryAuditRepo struct {
+	mu     sync.RWMutex
+	events []models.AuditEvent
+}
+
+func NewMemoryAuditRepo() *MemoryAuditRepo {
+	return &MemoryAuditRepo{}
+}
+
+func (r *MemoryAuditRepo) Append(_ context.Context, event *models.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event.ID = primitive.NewObjectID()
+	event.CreatedAt = time.Now()
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *MemoryAuditRepo) List(_ context.Context, q AuditQuery) ([]models.AuditEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	events := make([]models.AuditEvent, 0)
+	// events are appended in order, so walking them backwards lists the newest first
+	for idx := len(r.events) - 1; idx >= 0; idx-- {
+		event := r.events[idx]
+		if !q.Before.IsZero() && event.ID.Hex() >= q.Before.Hex() {
+			continue
+		}
+		if !auditMatches(q.Filter, &event) {
+			continue
+		}
+		events = append(events, event)
+		if q.Limit > 0 && len(events) == q.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (r *MemoryAuditRepo) Each(_ context.Context, f AuditFilter, fn func(*models.AuditEvent) error) error {
+	r.mu.RLock()
+	events := append([]models.AuditEvent(nil), r.events...)
+	r.mu.RUnlock()
+	for idx := range events {
+		if !auditMatches(f, &events[idx]) {
+			continue
+		}
+		if err := fn(&events[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func auditMatches(f AuditFilter, event *models.AuditEvent) bool {
+	switch {
+	case !f.ActorId.IsZero() && event.ActorId != f.ActorId:
+		return false
+	case !f.UserId.IsZero() && event.UserId != f.UserId:
+		return false
+	case f.Action != "" && event.Action != f.Action:
+		return false
+	case f.Target != "" && event.Target != f.Target:
+		return false
+	case f.Since != nil && event.CreatedAt.Before(*f.Since):
+		return false
+	case f.Until != nil && !event.CreatedAt.Before(*f.Until):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type BudgetRepo interface {
+	ListByUser(ctx context.Context, userId primitive.ObjectID) ([]models.Budget, error)
+	Create(ctx context.Context, budget *models.Budget) error
+	// Update replaces the editable fields of a budget owned by the user
+	Update(ctx context.Context, budget *models.Budget) (int64, error)
+	Delete(ctx context.Context, userId, id primitive.ObjectID) error
+	// RecordAlert stores the alert and reports whether its threshold had not alerted yet this period
+	RecordAlert(ctx context.Context, alert *models.BudgetAlert) (bool, error)
+	ListAlertsByUser(ctx context.Context, userId primitive.ObjectID) ([]models.BudgetAlert, error)
+	// DeleteByUser removes every budget of the user along with their alerts
+	DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error)
+}
+
+type MongoBudgetRepo struct {
+	Db      *mongo.Collection
+	AlertDb *mongo.Collection
+}
+
+func NewMongoBudgetRepo(db, alertDb *mongo.Collection) *MongoBudgetRepo {
+	return &MongoBudgetRepo{Db: db, AlertDb: alertDb}
+}
+
+func (r *MongoBudgetRepo) ListByUser(ctx context.Context, userId primitive.ObjectID) ([]models.Budget, error) {
+	budgets := make([]models.Budget, 0)
+	cursor, err := r.Db.Find(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &budgets); err != nil {
+		return nil, err
+	}
+	return budgets, nil
+}
+
+func (r *MongoBudgetRepo) Create(ctx context.Context, budget *models.Budget) error {
+	budget.ID = primitive.NewObjectID()
+	budget.CreatedAt, budget.UpdatedAt = time.Now(), time.Now()
+	_, err := r.Db.InsertOne(ctx, budget)
+	return err
+}
+
+func (r *MongoBudgetRepo) Update(ctx context.Context, budget *models.Budget) (int64, error) {
+	filter := bson.M{"_id": budget.ID, "user_id": budget.UserId}
+	update := bson.M{"$set": bson.M{
+		"name":       budget.Name,
+		"category":   budget.Category,
+		"account_id": budget.AccountId,
+		"limit":      budget.Limit,
+		"thresholds": budget.Thresholds,
+		"updated_at": time.Now(),
+	}}
+	res, err := r.Db.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	if res.MatchedCount == 0 {
+		return 0, ErrNotFound
+	}
+	return res.ModifiedCount, nil
+}
+
+func (r *MongoBudgetRepo) Delete(ctx context.Context, userId, id primitive.ObjectID) error {
+	res, err := r.Db.DeleteOne(ctx, bson.M{"_id": id, "user_id": userId})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoBudgetRepo) RecordAlert(ctx context.Context, alert *models.BudgetAlert) (bool, error) {
+	alert.ID = primitive.NewObjectID()
+	alert.CreatedAt = time.Now()
+	_, err := r.AlertDb.InsertOne(ctx, alert)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *MongoBudgetRepo) ListAlertsByUser(ctx context.Context, userId primitive.ObjectID) ([]models.BudgetAlert, error) {
+	alerts := make([]models.BudgetAlert, 0)
+	cursor, err := r.AlertDb.Find(ctx, bson.M{"user_id": userId}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+func (r *MongoBudgetRepo) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error) {
+	if _, err := r.AlertDb.DeleteMany(ctx, bson.M{"user_id": userId}); err != nil {
+		return 0, err
+	}
+	res, err := r.Db.DeleteMany(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+type MemoryBudgetRepo struct {
+	mu      sync.RWMutex
+	budgets map[primitive.ObjectID]models.Budget
+	alerts  map[string]models.BudgetAlert
+}
+
+func NewMemoryBudgetRepo() *MemoryBudgetRepo {
+	return &MemoryBudgetRepo{
+		budgets: make(map[primitive.ObjectID]models.Budget),
+		alerts:  make(map[string]models.BudgetAlert),
+	}
+}
+
+func (r *MemoryBudgetRepo) ListByUser(_ context.Context, userId primitive.ObjectID) ([]models.Budget, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	budgets := make([]models.Budget, 0)
+	for _, budget := range r.budgets {
+		if budget.UserId == userId {
+			budgets = append(budgets, budget)
+		}
+	}
+	return budgets, nil
+}
+
+func (r *MemoryBudgetRepo) Create(_ context.Context, budget *models.Budget) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	budget.ID = primitive.NewObjectID()
+	budget.CreatedAt, budget.UpdatedAt = time.Now(), time.Now()
+	r.budgets[budget.ID] = *budget
+	return nil
+}
+
+func (r *MemoryBudgetRepo) Update(_ context.Context, budget *models.Budget) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	existing, ok := r.budgets[budget.ID]
+	if !ok || existing.UserId != budget.UserId {
+		return 0, ErrNotFound
+	}
+	existing.Name, existing.Category, existing.AccountId = budget.Name, budget.Category, budget.AccountId
+	existing.Limit, existing.Thresholds, existing.UpdatedAt = budget.Limit, budget.Thresholds, time.Now()
+	r.budgets[budget.ID] = existing
+	return 1, nil
+}
+
+func (r *MemoryBudgetRepo) Delete(_ context.Context, userId, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.budgets[id]; !ok || existing.UserId != userId {
+		return ErrNotFound
+	}
+	delete(r.budgets, id)
+	return nil
+}
+
+func (r *MemoryBudgetRepo) RecordAlert(_ context.Context, alert *models.BudgetAlert) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := alert.BudgetId.Hex() + "/" + alert.Period + "/" + strconv.Itoa(alert.Threshold)
+	if _, ok := r.alerts[key]; ok {
+		return false, nil
+	}
+	alert.ID = primitive.NewObjectID()
+	alert.CreatedAt = time.Now()
+	r.alerts[key] = *alert
+	return true, nil
+}
+
+func (r *MemoryBudgetRepo) ListAlertsByUser(_ context.Context, userId primitive.ObjectID) ([]models.BudgetAlert, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	alerts := make([]models.BudgetAlert, 0)
+	for _, alert := range r.alerts {
+		if alert.UserId == userId {
+			alerts = append(alerts, alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].CreatedAt.Before(alerts[j].CreatedAt) })
+	return alerts, nil
+}
+
+func (r *MemoryBudgetRepo) DeleteByUser(_ context.Context, userId primitive.ObjectID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, alert := range r.alerts {
+		if alert.UserId == userId {
+			delete(r.alerts, key)
+		}
+	}
+	var deleted int64
+	for id, budget := range r.budgets {
+		if budget.UserId == userId {
+			delete(r.budgets, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
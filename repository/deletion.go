@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeletionRepo stores the deletions of user accounts, a user has at most one deletion which is kept as
+// their tombstone once completed
+type DeletionRepo interface {
+	// Start inserts the deletion under a new id, unless the user already has one which is returned instead
+	Start(ctx context.Context, deletion *models.AccountDeletion) (*models.AccountDeletion, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*models.AccountDeletion, error)
+	GetByClerkId(ctx context.Context, clerkId string) (*models.AccountDeletion, error)
+	// Claim marks a pending or failed deletion running, or one left running since before staleBefore,
+	// and returns it. ErrNotFound means it is completed or already being run.
+	Claim(ctx context.Context, id primitive.ObjectID, staleBefore time.Time) (*models.AccountDeletion, error)
+	StepDone(ctx context.Context, id primitive.ObjectID, step string) error
+	Fail(ctx context.Context, id primitive.ObjectID, step, reason string) error
+	// Complete marks the deletion completed and forgets the email of the user
+	Complete(ctx context.Context, id primitive.ObjectID) error
+	// ListUnfinished returns the deletions that could be claimed, oldest first
+	ListUnfinished(ctx context.Context, staleBefore time.Time) ([]models.AccountDeletion, error)
+}
+
+type MongoDeletionRepo struct {
+	Db *mongo.Collection
+}
+
+func NewMongoDeletionRepo(db *mongo.Collection) *MongoDeletionRepo {
+	return &MongoDeletionRepo{Db: db}
+}
+
+func (r *MongoDeletionRepo) Start(ctx context.Context, deletion *models.AccountDeletion) (*models.AccountDeletion, error) {
+	deletion.ID = primitive.NewObjectID()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var started models.AccountDeletion
+	err := r.Db.FindOneAndUpdate(ctx, bson.M{"user_id": deletion.UserId}, bson.M{"$setOnInsert": deletion}, opts).Decode(&started)
+	if err != nil {
+		return nil, err
+	}
+	return &started, nil
+}
+
+func (r *MongoDeletionRepo) Get(ctx context.Context, id primitive.ObjectID) (*models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	if err := r.Db.FindOne(ctx, bson.M{"_id": id}).Decode(&deletion); err != nil {
+		return nil, notFound(err)
+	}
+	return &deletion, nil
+}
+
+func (r *MongoDeletionRepo) GetByClerkId(ctx context.Context, clerkId string) (*models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	if err := r.Db.FindOne(ctx, bson.M{"clerk_id": clerkId}).Decode(&deletion); err != nil {
+		return nil, notFound(err)
+	}
+	return &deletion, nil
+}
+
+// claimable selects the deletions nobody is running
+func claimable(staleBefore time.Time) bson.A {
+	return bson.A{
+		bson.M{"status": bson.M{"$in": bson.A{models.DELETION_STATUS_PENDING, models.DELETION_STATUS_FAILED}}},
+		bson.M{"status": models.DELETION_STATUS_RUNNING, "updated_at": bson.M{"$lt": staleBefore}},
+	}
+}
+
+func (r *MongoDeletionRepo) Claim(ctx context.Context, id primitive.ObjectID, staleBefore time.Time) (*models.AccountDeletion, error) {
+	update := bson.M{
+		"$set":   bson.M{"status": models.DELETION_STATUS_RUNNING, "updated_at": time.Now()},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"failed_step": "", "error": ""},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var deletion models.AccountDeletion
+	if err := r.Db.FindOneAndUpdate(ctx, bson.M{"_id": id, "$or": claimable(staleBefore)}, update, opts).Decode(&deletion); err != nil {
+		return nil, notFound(err)
+	}
+	return &deletion, nil
+}
+
+func (r *MongoDeletionRepo) StepDone(ctx context.Context, id primitive.ObjectID, step string) error {
+	update := bson.M{"$addToSet": bson.M{"steps": step}, "$set": bson.M{"updated_at": time.Now()}}
+	_, err := r.Db.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (r *MongoDeletionRepo) Fail(ctx context.Context, id primitive.ObjectID, step, reason string) error {
+	update := bson.M{"$set": bson.M{
+		"status":      models.DELETION_STATUS_FAILED,
+		"failed_step": step,
+		"error":       reason,
+		"updated_at":  time.Now(),
+	}}
+	_, err := r.Db.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (r *MongoDeletionRepo) Complete(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"status": models.DELETION_STATUS_COMPLETED, "updated_at": now, "completed_at": now},
+		"$unset": bson.M{"email": ""},
+	}
+	_, err := r.Db.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (r *MongoDeletionRepo) ListUnfinished(ctx context.Context, staleBefore time.Time) ([]models.AccountDeletion, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "requested_at", Value: 1}})
+	cursor, err := r.Db.Find(ctx, bson.M{"$or": claimable(staleBefore)}, opts)
+	if err != nil {
+		return nil, err
+	}
+	deletions := make([]models.AccountDeletion, 0)
+	if err = cursor.All(ctx, &deletions); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+type MemoryDeletionRepo struct {
+	mu        sync.Mutex
+	deletions map[primitive.ObjectID]models.AccountDeletion
+}
+
+func NewMemoryDeletionRepo() *MemoryDeletionRepo {
+	return &MemoryDeletionRepo{deletions: make(map[primitive.ObjectID]models.AccountDeletion)}
+}
+
+func (r *MemoryDeletionRepo) Start(_ context.Context, deletion *models.AccountDeletion) (*models.AccountDeletion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.deletions {
+		if existing.UserId == deletion.UserId {
+			return &existing, nil
+		}
+	}
+	deletion.ID = primitive.NewObjectID()
+	r.deletions[deletion.ID] = *deletion
+	started := *deletion
+	return &started, nil
+}
+
+func (r *MemoryDeletionRepo) Get(_ context.Context, id primitive.ObjectID) (*models.AccountDeletion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deletion, ok := r.deletions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &deletion, nil
+}
+
+func (r *MemoryDeletionRepo) GetByClerkId(_ context.Context, clerkId string) (*models.AccountDeletion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, deletion := range r.deletions {
+		if deletion.ClerkId == clerkId {
+			return &deletion, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// isClaimable reports whether nobody is running the deletion
+func isClaimable(deletion models.AccountDeletion, staleBefore time.Time) bool {
+	switch deletion.Status {
+	case models.DELETION_STATUS_PENDING, models.DELETION_STATUS_FAILED:
+		return true
+	case models.DELETION_STATUS_RUNNING:
+		return deletion.UpdatedAt.Before(staleBefore)
+	}
+	return false
+}
+
+func (r *MemoryDeletionRepo) Claim(_ context.Context, id primitive.ObjectID, staleBefore time.Time) (*models.AccountDeletion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deletion, ok := r.deletions[id]
+	if !ok || !isClaimable(deletion, staleBefore) {
+		return nil, ErrNotFound
+	}
+	deletion.Status, deletion.UpdatedAt = models.DELETION_STATUS_RUNNING, time.Now()
+	deletion.FailedStep, deletion.Error = "", ""
+	deletion.Attempts++
+	r.deletions[id] = deletion
+	return &deletion, nil
+}
+
+func (r *MemoryDeletionRepo) StepDone(_ context.Context, id primitive.ObjectID, step string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if deletion, ok := r.deletions[id]; ok {
+		if !deletion.Done(step) {
+			deletion.Steps = append(append([]string(nil), deletion.Steps...), step)
+		}
+		deletion.UpdatedAt = time.Now()
+		r.deletions[id] = deletion
+	}
+	return nil
+}
+
+func (r *MemoryDeletionRepo) Fail(_ context.Context, id primitive.ObjectID, step, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if deletion, ok := r.deletions[id]; ok {
+		deletion.Status, deletion.FailedStep, deletion.Error = models.DELETION_STATUS_FAILED, step, reason
+		deletion.UpdatedAt = time.Now()
+		r.deletions[id] = deletion
+	}
+	return nil
+}
+
+func (r *MemoryDeletionRepo) Complete(_ context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if deletion, ok := r.deletions[id]; ok {
+		now := time.Now()
+		deletion.Status, deletion.UpdatedAt, deletion.CompletedAt = models.DELETION_STATUS_COMPLETED, now, &now
+		deletion.Email = ""
+		r.deletions[id] = deletion
+	}
+	return nil
+}
+
+func (r *MemoryDeletionRepo) ListUnfinished(_ context.Context, staleBefore time.Time) ([]models.AccountDeletion, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deletions := make([]models.AccountDeletion, 0)
+	for _, deletion := range r.deletions {
+		if isClaimable(deletion, staleBefore) {
+			deletions = append(deletions, deletion)
+		}
+	}
+	sort.Slice(deletions, func(i, j int) bool { return deletions[i].RequestedAt.Before(deletions[j].RequestedAt) })
+	return deletions, nil
+}
@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportRepo stores the data exports of users along with their archives, expired exports are removed
+// by a TTL index on expires_at and their archives by PurgeExpired
+type ExportRepo interface {
+	// Create inserts the export under a new id
+	Create(ctx context.Context, export *models.DataExport) error
+	Get(ctx context.Context, id primitive.ObjectID) (*models.DataExport, error)
+	SetStatus(ctx context.Context, id primitive.ObjectID, status models.ExportStatus, reason string) error
+	// Complete stores the archive of the export and marks it completed
+	Complete(ctx context.Context, id primitive.ObjectID, archive []byte) error
+	Archive(ctx context.Context, id primitive.ObjectID) ([]byte, error)
+	// PurgeExpired deletes the archives of the exports expired as of now and returns how many there were
+	PurgeExpired(ctx context.Context, now time.Time) (int64, error)
+	// DeleteByUser removes every export of the user along with their archives
+	DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error)
+}
+
+// MongoExportRepo keeps the archives in a GridFS bucket, as they can outgrow the 16MB limit of a
+// document. An archive is stored under the id of its export, with the user and expiry of the export as
+// metadata.
+type MongoExportRepo struct {
+	Db            *mongo.Collection
+	ArchiveBucket string
+}
+
+func NewMongoExportRepo(db *mongo.Collection, archiveBucket string) *MongoExportRepo {
+	return &MongoExportRepo{Db: db, ArchiveBucket: archiveBucket}
+}
+
+// archives returns the bucket of the archives bound to the deadline of ctx. A bucket is not safe for
+// concurrent use, so each call gets its own.
+func (r *MongoExportRepo) archives(ctx context.Context) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(r.Db.Database(), options.GridFSBucket().SetName(r.ArchiveBucket))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = bucket.SetReadDeadline(deadline)
+		_ = bucket.SetWriteDeadline(deadline)
+	}
+	return bucket, nil
+}
+
+// deleteArchives deletes the archives matching the filter on their files
+func (r *MongoExportRepo) deleteArchives(ctx context.Context, filter bson.M) (int64, error) {
+	bucket, err := r.archives(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cursor, err := bucket.FindContext(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var files []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err = cursor.All(ctx, &files); err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, file := range files {
+		if err = bucket.DeleteContext(ctx, file.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (r *MongoExportRepo) Create(ctx context.Context, export *models.DataExport) error {
+	export.ID = primitive.NewObjectID()
+	_, err := r.Db.InsertOne(ctx, export)
+	return err
+}
+
+func (r *MongoExportRepo) Get(ctx context.Context, id primitive.ObjectID) (*models.DataExport, error) {
+	var export models.DataExport
+	opts := options.FindOne().SetProjection(bson.M{"archive": 0})
+	if err := r.Db.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&export); err != nil {
+		return nil, notFound(err)
+	}
+	return &export, nil
+}
+
+func (r *MongoExportRepo) SetStatus(ctx context.Context, id primitive.ObjectID, status models.ExportStatus, reason string) error {
+	_, err := r.Db.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": status, "error": reason}})
+	return err
+}
+
+func (r *MongoExportRepo) Complete(ctx context.Context, id primitive.ObjectID, archive []byte) error {
+	export, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	bucket, err := r.archives(ctx)
+	if err != nil {
+		return err
+	}
+	// a retried export replaces the archive of the previous attempt
+	if err = bucket.DeleteContext(ctx, id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+		return err
+	}
+	metadata := bson.M{"user_id": export.UserId, "expires_at": export.ExpiresAt}
+	if err = bucket.UploadFromStreamWithID(id, id.Hex()+".zip", bytes.NewReader(archive), options.GridFSUpload().SetMetadata(metadata)); err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{
+		"status":       models.EXPORT_STATUS_COMPLETED,
+		"size":         len(archive),
+		"completed_at": time.Now(),
+	}}
+	_, err = r.Db.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (r *MongoExportRepo) Archive(ctx context.Context, id primitive.ObjectID) ([]byte, error) {
+	export, err := r.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if export.Status != models.EXPORT_STATUS_COMPLETED {
+		return nil, ErrNotFound
+	}
+	bucket, err := r.archives(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var archive bytes.Buffer
+	if _, err = bucket.DownloadToStream(id, &archive); err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return archive.Bytes(), nil
+}
+
+func (r *MongoExportRepo) PurgeExpired(ctx context.Context, now time.Time) (int64, error) {
+	return r.deleteArchives(ctx, bson.M{"metadata.expires_at": bson.M{"$lte": now}})
+}
+
+func (r *MongoExportRepo) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error) {
+	if _, err := r.deleteArchives(ctx, bson.M{"metadata.user_id": userId}); err != nil {
+		return 0, err
+	}
+	res, err := r.Db.DeleteMany(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+type MemoryExportRepo struct {
+	mu       sync.RWMutex
+	exports  map[primitive.ObjectID]models.DataExport
+	archives map[primitive.ObjectID][]byte
+}
+
+func NewMemoryExportRepo() *MemoryExportRepo {
+	return &MemoryExportRepo{
+		exports:  make(map[primitive.ObjectID]models.DataExport),
+		archives: make(map[primitive.ObjectID][]byte),
+	}
+}
+
+func (r *MemoryExportRepo) Create(_ context.Context, export *models.DataExport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	export.ID = primitive.NewObjectID()
+	r.exports[export.ID] = *export
+	return nil
+}
+
+// live returns the export unless it does not exist or has expired, the lock must be held
+func (r *MemoryExportRepo) live(id primitive.ObjectID) (models.DataExport, bool) {
+	export, ok := r.exports[id]
+	if !ok || time.Now().After(export.ExpiresAt) {
+		return export, false
+	}
+	return export, true
+}
+
+func (r *MemoryExportRepo) Get(_ context.Context, id primitive.ObjectID) (*models.DataExport, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	export, ok := r.live(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &export, nil
+}
+
+func (r *MemoryExportRepo) SetStatus(_ context.Context, id primitive.ObjectID, status models.ExportStatus, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if export, ok := r.exports[id]; ok {
+		export.Status, export.Error = status, reason
+		r.exports[id] = export
+	}
+	return nil
+}
+
+func (r *MemoryExportRepo) Complete(_ context.Context, id primitive.ObjectID, archive []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if export, ok := r.exports[id]; ok {
+		now := time.Now()
+		export.Status, export.Size, export.CompletedAt = models.EXPORT_STATUS_COMPLETED, len(archive), &now
+		r.exports[id] = export
+		r.archives[id] = archive
+	}
+	return nil
+}
+
+func (r *MemoryExportRepo) Archive(_ context.Context, id primitive.ObjectID) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	export, ok := r.live(id)
+	if !ok || export.Status != models.EXPORT_STATUS_COMPLETED {
+		return nil, ErrNotFound
+	}
+	return r.archives[id], nil
+}
+
+func (r *MemoryExportRepo) PurgeExpired(_ context.Context, now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var purged int64
+	for id, export := range r.exports {
+		if export.ExpiresAt.After(now) {
+			continue
+		}
+		delete(r.exports, id)
+		if _, ok := r.archives[id]; ok {
+			delete(r.archives, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (r *MemoryExportRepo) DeleteByUser(_ context.Context, userId primitive.ObjectID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted int64
+	for id, export := range r.exports {
+		if export.UserId == userId {
+			delete(r.exports, id)
+			delete(r.archives, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
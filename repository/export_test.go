@@ -0,0 +1,42 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryExportRepo()
+	userId, now := primitive.NewObjectID(), time.Now()
+	expired := &models.DataExport{UserId: userId, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	live := &models.DataExport{UserId: userId, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	for _, export := range []*models.DataExport{expired, live} {
+		if err := repo.Create(ctx, export); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Complete(ctx, export.ID, []byte("archive")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := repo.PurgeExpired(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Fatalf("got %d archives purged, want 1", purged)
+	}
+	if _, err = repo.Archive(ctx, expired.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("got error %v for the expired archive, want %v", err, repository.ErrNotFound)
+	}
+	if archive, err := repo.Archive(ctx, live.ID); err != nil || string(archive) != "archive" {
+		t.Fatalf("got archive %q and error %v, want the live archive", archive, err)
+	}
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PaymentTaskRepo interface {
+	// CreateMany inserts the tasks under new ids and returns those ids in order
+	CreateMany(ctx context.Context, tasks []models.PaymentTask) ([]primitive.ObjectID, error)
+	Get(ctx context.Context, id primitive.ObjectID) (*models.PaymentTask, error)
+	ListByUser(ctx context.Context, userId primitive.ObjectID) ([]models.PaymentTask, error)
+	DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error)
+}
+
+type MongoPaymentTaskRepo struct {
+	Db *mongo.Collection
+}
+
+func NewMongoPaymentTaskRepo(db *mongo.Collection) *MongoPaymentTaskRepo {
+	return &MongoPaymentTaskRepo{Db: db}
+}
+
+func (r *MongoPaymentTaskRepo) CreateMany(ctx context.Context, tasks []models.PaymentTask) ([]primitive.ObjectID, error) {
+	// Map struct slice to interface slice as InsertMany accepts interface slice as parameter
+	insertableList := make([]interface{}, len(tasks))
+	ids := make([]primitive.ObjectID, len(tasks))
+	for idx, task := range tasks {
+		task.ID = primitive.NewObjectID()
+		ids[idx] = task.ID
+		insertableList[idx] = task
+	}
+	if _, err := r.Db.InsertMany(ctx, insertableList); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *MongoPaymentTaskRepo) Get(ctx context.Context, id primitive.ObjectID) (*models.PaymentTask, error) {
+	var paymentTask models.PaymentTask
+	if err := r.Db.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&paymentTask); err != nil {
+		return nil, notFound(err)
+	}
+	return &paymentTask, nil
+}
+
+func (r *MongoPaymentTaskRepo) ListByUser(ctx context.Context, userId primitive.ObjectID) ([]models.PaymentTask, error) {
+	paymentTasks := make([]models.PaymentTask, 0)
+	opts := options.Find().SetSkip(0).SetLimit(1000)
+	cursor, err := r.Db.Find(ctx, bson.M{"user_id": userId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &paymentTasks); err != nil {
+		return nil, err
+	}
+	return paymentTasks, nil
+}
+
+func (r *MongoPaymentTaskRepo) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error) {
+	res, err := r.Db.DeleteMany(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+type MemoryPaymentTaskRepo struct {
+	mu    sync.RWMutex
+	tasks []models.PaymentTask
+}
+
+func NewMemoryPaymentTaskRepo() *MemoryPaymentTaskRepo {
+	return &MemoryPaymentTaskRepo{}
+}
+
+func (r *MemoryPaymentTaskRepo) CreateMany(_ context.Context, tasks []models.PaymentTask) ([]primitive.ObjectID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]primitive.ObjectID, len(tasks))
+	for idx, task := range tasks {
+		task.ID = primitive.NewObjectID()
+		ids[idx] = task.ID
+		r.tasks = append(r.tasks, task)
+	}
+	return ids, nil
+}
+
+func (r *MemoryPaymentTaskRepo) Get(_ context.Context, id primitive.ObjectID) (*models.PaymentTask, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, task := range r.tasks {
+		if task.ID == id {
+			found := task
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryPaymentTaskRepo) ListByUser(_ context.Context, userId primitive.ObjectID) ([]models.PaymentTask, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	paymentTasks := make([]models.PaymentTask, 0)
+	for _, task := range r.tasks {
+		if task.UserId == userId {
+			paymentTasks = append(paymentTasks, task)
+		}
+	}
+	return paymentTasks, nil
+}
+
+func (r *MemoryPaymentTaskRepo) DeleteByUser(_ context.Context, userId primitive.ObjectID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.tasks[:0]
+	for _, task := range r.tasks {
+		if task.UserId != userId {
+			kept = append(kept, task)
+		}
+	}
+	deleted := int64(len(r.tasks) - len(kept))
+	r.tasks = kept
+	return deleted, nil
+}
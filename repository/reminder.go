@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReminderRepo interface {
+	// Record stores the reminder and reports whether it had not been sent yet for its due date
+	Record(ctx context.Context, reminder *models.DueDateReminder) (bool, error)
+	// Sent reports whether the reminder was recorded for its due date
+	Sent(ctx context.Context, reminder *models.DueDateReminder) (bool, error)
+	ListByUser(ctx context.Context, userId primitive.ObjectID) ([]models.DueDateReminder, error)
+	DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error)
+}
+
+type MongoReminderRepo struct {
+	Db *mongo.Collection
+}
+
+func NewMongoReminderRepo(db *mongo.Collection) *MongoReminderRepo {
+	return &MongoReminderRepo{Db: db}
+}
+
+func (r *MongoReminderRepo) Record(ctx context.Context, reminder *models.DueDateReminder) (bool, error) {
+	reminder.ID = primitive.NewObjectID()
+	reminder.CreatedAt = time.Now()
+	_, err := r.Db.InsertOne(ctx, reminder)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *MongoReminderRepo) Sent(ctx context.Context, reminder *models.DueDateReminder) (bool, error) {
+	filter := bson.M{"account_id": reminder.AccountId, "due_date": reminder.DueDate, "kind": reminder.Kind}
+	count, err := r.Db.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *MongoReminderRepo) ListByUser(ctx context.Context, userId primitive.ObjectID) ([]models.DueDateReminder, error) {
+	reminders := make([]models.DueDateReminder, 0)
+	cursor, err := r.Db.Find(ctx, bson.M{"user_id": userId}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &reminders); err != nil {
+		return nil, err
+	}
+	return reminders, nil
+}
+
+func (r *MongoReminderRepo) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error) {
+	res, err := r.Db.DeleteMany(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+type MemoryReminderRepo struct {
+	mu        sync.Mutex
+	reminders map[string]models.DueDateReminder
+}
+
+func NewMemoryReminderRepo() *MemoryReminderRepo {
+	return &MemoryReminderRepo{reminders: make(map[string]models.DueDateReminder)}
+}
+
+func (r *MemoryReminderRepo) Record(_ context.Context, reminder *models.DueDateReminder) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := reminderKey(reminder)
+	if _, ok := r.reminders[key]; ok {
+		return false, nil
+	}
+	reminder.ID = primitive.NewObjectID()
+	reminder.CreatedAt = time.Now()
+	r.reminders[key] = *reminder
+	return true, nil
+}
+
+func (r *MemoryReminderRepo) Sent(_ context.Context, reminder *models.DueDateReminder) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.reminders[reminderKey(reminder)]
+	return ok, nil
+}
+
+func reminderKey(reminder *models.DueDateReminder) string {
+	return reminder.AccountId + "/" + reminder.DueDate + "/" + reminder.Kind
+}
+
+func (r *MemoryReminderRepo) ListByUser(_ context.Context, userId primitive.ObjectID) ([]models.DueDateReminder, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reminders := make([]models.DueDateReminder, 0)
+	for _, reminder := range r.reminders {
+		if reminder.UserId == userId {
+			reminders = append(reminders, reminder)
+		}
+	}
+	sort.Slice(reminders, func(i, j int) bool { return reminders[i].CreatedAt.Before(reminders[j].CreatedAt) })
+	return reminders, nil
+}
+
+func (r *MemoryReminderRepo) DeleteByUser(_ context.Context, userId primitive.ObjectID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted int64
+	for key, reminder := range r.reminders {
+		if reminder.UserId == userId {
+			delete(r.reminders, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
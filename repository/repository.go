@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrNotFound is returned by every repository when the requested document does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a write is refused because of the current state of the documents
+var ErrConflict = errors.New("conflict")
+
+// Repositories groups every repository the handlers depend on
+type Repositories struct {
+	Users        UserRepo
+	Tokens       TokenRepo
+	Accounts     AccountRepo
+	Transactions TransactionRepo
+	PaymentTasks PaymentTaskRepo
+	Budgets      BudgetRepo
+	Snapshots    BalanceSnapshotRepo
+	Reminders    ReminderRepo
+	Audit        AuditRepo
+	Exports      ExportRepo
+	Deletions    DeletionRepo
+}
+
+// Collections names the mongo collection backing each repository
+type Collections struct {
+	Users             string
+	Tokens            string
+	Accounts          string
+	Transactions      string
+	PaymentTasks      string
+	Budgets           string
+	BudgetAlerts      string
+	BalanceSnapshots  string
+	UtilizationAlerts string
+	Reminders         string
+	AuditEvents       string
+	Exports           string
+	Deletions         string
+	// ExportArchives is the GridFS bucket of the archives of the exports
+	ExportArchives string
+}
+
+// NewMongoRepositories returns the repositories backed by a mongo database. The indexes their queries
+// rely on are created by the migrations in database/migrations.
+func NewMongoRepositories(db *mongo.Database, names Collections) *Repositories {
+	return &Repositories{
+		Users:        NewMongoUserRepo(db.Collection(names.Users)),
+		Tokens:       NewMongoTokenRepo(db.Collection(names.Tokens)),
+		Accounts:     NewMongoAccountRepo(db.Collection(names.Accounts)),
+		Transactions: NewMongoTransactionRepo(db.Collection(names.Transactions)),
+		PaymentTasks: NewMongoPaymentTaskRepo(db.Collection(names.PaymentTasks)),
+		Budgets:      NewMongoBudgetRepo(db.Collection(names.Budgets), db.Collection(names.BudgetAlerts)),
+		Snapshots:    NewMongoBalanceSnapshotRepo(db.Collection(names.BalanceSnapshots), db.Collection(names.UtilizationAlerts)),
+		Reminders:    NewMongoReminderRepo(db.Collection(names.Reminders)),
+		Audit:        NewMongoAuditRepo(db.Collection(names.AuditEvents)),
+		Exports:      NewMongoExportRepo(db.Collection(names.Exports), names.ExportArchives),
+		Deletions:    NewMongoDeletionRepo(db.Collection(names.Deletions)),
+	}
+}
+
+// NewMemoryRepositories returns in memory repositories, for tests and local development without a
+// database
+func NewMemoryRepositories() *Repositories {
+	return &Repositories{
+		Users:        NewMemoryUserRepo(),
+		Tokens:       NewMemoryTokenRepo(),
+		Accounts:     NewMemoryAccountRepo(),
+		Transactions: NewMemoryTransactionRepo(),
+		PaymentTasks: NewMemoryPaymentTaskRepo(),
+		Budgets:      NewMemoryBudgetRepo(),
+		Snapshots:    NewMemoryBalanceSnapshotRepo(),
+		Reminders:    NewMemoryReminderRepo(),
+		Audit:        NewMemoryAuditRepo(),
+		Exports:      NewMemoryExportRepo(),
+		Deletions:    NewMemoryDeletionRepo(),
+	}
+}
+
+// notFound maps the driver's no documents error to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BalanceSnapshotRepo stores the daily balance of every credit account
+type BalanceSnapshotRepo interface {
+	// Upsert saves the snapshots, keeping a single one per account per day
+	Upsert(ctx context.Context, snapshots []models.BalanceSnapshot) error
+	// LatestBefore returns every snapshot of the most recent day before date, formatted as YYYY-MM-DD
+	LatestBefore(ctx context.Context, userId primitive.ObjectID, date string) ([]models.BalanceSnapshot, error)
+	// ListSince returns the snapshots from date onwards in chronological order
+	ListSince(ctx context.Context, userId primitive.ObjectID, date string) ([]models.BalanceSnapshot, error)
+	// RecordAlert stores the alert and reports whether its threshold had not alerted yet that day
+	RecordAlert(ctx context.Context, alert *models.UtilizationAlert) (bool, error)
+	// ListAlerts returns the alerts of the user on date
+	ListAlerts(ctx context.Context, userId primitive.ObjectID, date string) ([]models.UtilizationAlert, error)
+	// DeleteByUser removes every snapshot of the user along with their alerts
+	DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error)
+}
+
+type MongoBalanceSnapshotRepo struct {
+	Db      *mongo.Collection
+	AlertDb *mongo.Collection
+}
+
+func NewMongoBalanceSnapshotRepo(db, alertDb *mongo.Collection) *MongoBalanceSnapshotRepo {
+	return &MongoBalanceSnapshotRepo{Db: db, AlertDb: alertDb}
+}
+
+func (r *MongoBalanceSnapshotRepo) Upsert(ctx context.Context, snapshots []models.BalanceSnapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	now := time.Now()
+	writes := make([]mongo.WriteModel, len(snapshots))
+	for idx, snapshot := range snapshots {
+		filter := bson.M{"user_id": snapshot.UserId, "account_id": snapshot.AccountId, "date": snapshot.Date}
+		update := bson.M{
+			"$set": bson.M{
+				"name":            snapshot.Name,
+				"current_balance": snapshot.CurrentBalance,
+				"credit_limit":    snapshot.CreditLimit,
+				"utilization":     snapshot.Utilization,
+				"updated_at":      now,
+			},
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+		}
+		writes[idx] = mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true)
+	}
+	_, err := r.Db.BulkWrite(ctx, writes)
+	return err
+}
+
+func (r *MongoBalanceSnapshotRepo) find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]models.BalanceSnapshot, error) {
+	snapshots := make([]models.BalanceSnapshot, 0)
+	cursor, err := r.Db.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+func (r *MongoBalanceSnapshotRepo) LatestBefore(ctx context.Context, userId primitive.ObjectID, date string) ([]models.BalanceSnapshot, error) {
+	var latest models.BalanceSnapshot
+	filter := bson.M{"user_id": userId, "date": bson.M{"$lt": date}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "date", Value: -1}})
+	err := r.Db.FindOne(ctx, filter, opts).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.find(ctx, bson.M{"user_id": userId, "date": latest.Date})
+}
+
+func (r *MongoBalanceSnapshotRepo) ListSince(ctx context.Context, userId primitive.ObjectID, date string) ([]models.BalanceSnapshot, error) {
+	filter := bson.M{"user_id": userId, "date": bson.M{"$gte": date}}
+	return r.find(ctx, filter, options.Find().SetSort(bson.D{{Key: "date", Value: 1}}))
+}
+
+func (r *MongoBalanceSnapshotRepo) RecordAlert(ctx context.Context, alert *models.UtilizationAlert) (bool, error) {
+	alert.ID = primitive.NewObjectID()
+	alert.CreatedAt = time.Now()
+	_, err := r.AlertDb.InsertOne(ctx, alert)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *MongoBalanceSnapshotRepo) ListAlerts(ctx context.Context, userId primitive.ObjectID, date string) ([]models.UtilizationAlert, error) {
+	alerts := make([]models.UtilizationAlert, 0)
+	cursor, err := r.AlertDb.Find(ctx, bson.M{"user_id": userId, "date": date})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+func (r *MongoBalanceSnapshotRepo) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error) {
+	if _, err := r.AlertDb.DeleteMany(ctx, bson.M{"user_id": userId}); err != nil {
+		return 0, err
+	}
+	res, err := r.Db.DeleteMany(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+type MemoryBalanceSnapshotRepo struct {
+	mu        sync.RWMutex
+	snapshots map[string]models.BalanceSnapshot
+	alerts    map[string]models.UtilizationAlert
+}
+
+func NewMemoryBalanceSnapshotRepo() *MemoryBalanceSnapshotRepo {
+	return &MemoryBalanceSnapshotRepo{
+		snapshots: make(map[string]models.BalanceSnapshot),
+		alerts:    make(map[string]models.UtilizationAlert),
+	}
+}
+
+func (r *MemoryBalanceSnapshotRepo) Upsert(_ context.Context, snapshots []models.BalanceSnapshot) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, snapshot := range snapshots {
+		key := snapshot.UserId.Hex() + "/" + snapshot.AccountId + "/" + snapshot.Date
+		snapshot.ID, snapshot.CreatedAt = primitive.NewObjectID(), now
+		if existing, ok := r.snapshots[key]; ok {
+			snapshot.ID, snapshot.CreatedAt = existing.ID, existing.CreatedAt
+		}
+		snapshot.UpdatedAt = now
+		r.snapshots[key] = snapshot
+	}
+	return nil
+}
+
+// list returns the user's snapshots matching the date in chronological order
+func (r *MemoryBalanceSnapshotRepo) list(userId primitive.ObjectID, match func(date string) bool) []models.BalanceSnapshot {
+	snapshots := make([]models.BalanceSnapshot, 0)
+	for _, snapshot := range r.snapshots {
+		if snapshot.UserId == userId && match(snapshot.Date) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Date < snapshots[j].Date })
+	return snapshots
+}
+
+func (r *MemoryBalanceSnapshotRepo) LatestBefore(_ context.Context, userId primitive.ObjectID, date string) ([]models.BalanceSnapshot, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	before := r.list(userId, func(d string) bool { return d < date })
+	if len(before) == 0 {
+		return nil, nil
+	}
+	latest := before[len(before)-1].Date
+	return r.list(userId, func(d string) bool { return d == latest }), nil
+}
+
+func (r *MemoryBalanceSnapshotRepo) ListSince(_ context.Context, userId primitive.ObjectID, date string) ([]models.BalanceSnapshot, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.list(userId, func(d string) bool { return d >= date }), nil
+}
+
+func (r *MemoryBalanceSnapshotRepo) RecordAlert(_ context.Context, alert *models.UtilizationAlert) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := alert.UserId.Hex() + "/" + alert.AccountId + "/" + strconv.FormatFloat(alert.Threshold, 'f', -1, 64) + "/" + alert.Date
+	if _, ok := r.alerts[key]; ok {
+		return false, nil
+	}
+	alert.ID = primitive.NewObjectID()
+	alert.CreatedAt = time.Now()
+	r.alerts[key] = *alert
+	return true, nil
+}
+
+func (r *MemoryBalanceSnapshotRepo) ListAlerts(_ context.Context, userId primitive.ObjectID, date string) ([]models.UtilizationAlert, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	alerts := make([]models.UtilizationAlert, 0)
+	for _, alert := range r.alerts {
+		if alert.UserId == userId && alert.Date == date {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, nil
+}
+
+func (r *MemoryBalanceSnapshotRepo) DeleteByUser(_ context.Context, userId primitive.ObjectID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, alert := range r.alerts {
+		if alert.UserId == userId {
+			delete(r.alerts, key)
+		}
+	}
+	var deleted int64
+	for key, snapshot := range r.snapshots {
+		if snapshot.UserId == userId {
+			delete(r.snapshots, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
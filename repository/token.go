@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TokenRepo stores the permanent plaid access tokens of every linked Item
+type TokenRepo interface {
+	// Create inserts the token under a new id
+	Create(ctx context.Context, token *models.Token) error
+	Update(ctx context.Context, id primitive.ObjectID, value, itemId string) error
+	// MarkSynced records that the accounts of the token's Item were fetched at syncedAt
+	MarkSynced(ctx context.Context, id primitive.ObjectID, syncedAt time.Time) error
+	ListByUser(ctx context.Context, userId primitive.ObjectID) ([]models.Token, error)
+	// List returns the tokens of every user
+	List(ctx context.Context) ([]models.Token, error)
+	// Get finds a token by its access token value, or by its id when tokenId is set
+	Get(ctx context.Context, accessToken, tokenId string) (*models.Token, error)
+	GetByUser(ctx context.Context, user *models.User) (*models.Token, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type MongoTokenRepo struct {
+	Db *mongo.Collection
+}
+
+func NewMongoTokenRepo(db *mongo.Collection) *MongoTokenRepo {
+	return &MongoTokenRepo{Db: db}
+}
+
+func (r *MongoTokenRepo) Create(ctx context.Context, token *models.Token) error {
+	token.ID = primitive.NewObjectID()
+	_, err := r.Db.InsertOne(ctx, token)
+	return err
+}
+
+func (r *MongoTokenRepo) Update(ctx context.Context, id primitive.ObjectID, value, itemId string) error {
+	filter := bson.D{{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "value", Value: value}, {Key: "item_id", Value: itemId}}}}
+	_, err := r.Db.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *MongoTokenRepo) MarkSynced(ctx context.Context, id primitive.ObjectID, syncedAt time.Time) error {
+	filter := bson.D{{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "last_synced_at", Value: syncedAt}}}}
+	_, err := r.Db.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *MongoTokenRepo) ListByUser(ctx context.Context, userId primitive.ObjectID) ([]models.Token, error) {
+	var results []models.Token
+	cursor, err := r.Db.Find(ctx, bson.D{{Key: "user._id", Value: userId}})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *MongoTokenRepo) List(ctx context.Context) ([]models.Token, error) {
+	var results []models.Token
+	cursor, err := r.Db.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *MongoTokenRepo) Get(ctx context.Context, accessToken, tokenId string) (*models.Token, error) {
+	var token models.Token
+	filter := []bson.M{{"value": accessToken}}
+	if tokenId != "" {
+		id, err := primitive.ObjectIDFromHex(tokenId)
+		if err != nil {
+			return nil, err
+		}
+		filter = []bson.M{{"_id": id}, {"value": accessToken}}
+	}
+
+	if err := r.Db.FindOne(ctx, bson.M{"$or": filter}).Decode(&token); err != nil {
+		return nil, notFound(err)
+	}
+	return &token, nil
+}
+
+func (r *MongoTokenRepo) GetByUser(ctx context.Context, user *models.User) (*models.Token, error) {
+	var token models.Token
+	filter := []bson.M{{"user._id": user.ID}, {"user.username": user.Username}, {"user.email": user.Email}}
+	if err := r.Db.FindOne(ctx, bson.M{"$or": filter}).Decode(&token); err != nil {
+		return nil, notFound(err)
+	}
+	return &token, nil
+}
+
+func (r *MongoTokenRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.Db.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type MemoryTokenRepo struct {
+	mu     sync.RWMutex
+	tokens []models.Token
+}
+
+func NewMemoryTokenRepo() *MemoryTokenRepo {
+	return &MemoryTokenRepo{}
+}
+
+func (r *MemoryTokenRepo) Create(_ context.Context, token *models.Token) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token.ID = primitive.NewObjectID()
+	r.tokens = append(r.tokens, *token)
+	return nil
+}
+
+func (r *MemoryTokenRepo) Update(_ context.Context, id primitive.ObjectID, value, itemId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for idx := range r.tokens {
+		if r.tokens[idx].ID == id {
+			r.tokens[idx].Value = value
+			r.tokens[idx].ItemId = itemId
+		}
+	}
+	return nil
+}
+
+func (r *MemoryTokenRepo) MarkSynced(_ context.Context, id primitive.ObjectID, syncedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for idx := range r.tokens {
+		if r.tokens[idx].ID == id {
+			r.tokens[idx].LastSyncedAt = &syncedAt
+		}
+	}
+	return nil
+}
+
+func (r *MemoryTokenRepo) ListByUser(_ context.Context, userId primitive.ObjectID) ([]models.Token, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var results []models.Token
+	for _, token := range r.tokens {
+		if token.User != nil && token.User.ID == userId {
+			results = append(results, token)
+		}
+	}
+	return results, nil
+}
+
+func (r *MemoryTokenRepo) List(_ context.Context) ([]models.Token, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]models.Token(nil), r.tokens...), nil
+}
+
+func (r *MemoryTokenRepo) Get(_ context.Context, accessToken, tokenId string) (*models.Token, error) {
+	var id primitive.ObjectID
+	if tokenId != "" {
+		var err error
+		if id, err = primitive.ObjectIDFromHex(tokenId); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, token := range r.tokens {
+		if token.Value == accessToken || (tokenId != "" && token.ID == id) {
+			found := token
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryTokenRepo) GetByUser(_ context.Context, user *models.User) (*models.Token, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, token := range r.tokens {
+		if token.User == nil {
+			continue
+		}
+		if token.User.ID == user.ID || token.User.Username == user.Username || token.User.Email == user.Email {
+			found := token
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryTokenRepo) Delete(_ context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for idx, token := range r.tokens {
+		if token.ID == id {
+			r.tokens = append(r.tokens[:idx], r.tokens[idx+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,830 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NonSpendCategories are the primary categories that move money around rather than spend it
+var NonSpendCategories = []string{"INCOME", "TRANSFER_IN", "TRANSFER_OUT", "LOAN_PAYMENTS", "BANK_FEES_REFUND"}
+
+// TransactionFilter selects a user's transactions, every unset field matches all transactions
+type TransactionFilter struct {
+	UserId primitive.ObjectID
+	// StartDate and EndDate are inclusive unix timestamps in milliseconds
+	StartDate        *int64
+	EndDate          *int64
+	AccountId        string
+	PrimaryCategory  string
+	DetailedCategory string
+	Merchant         string
+	MinAmount        *float64
+	MaxAmount        *float64
+	Pending          *bool
+	InPlan           *bool
+	// Search matches name or merchant name case insensitively
+	Search string
+}
+
+// TransactionCursor is the position of the last transaction of a page
+type TransactionCursor struct {
+	Value float64 `json:"v"`
+	ID    string  `json:"id"`
+}
+
+type TransactionQuery struct {
+	Filter TransactionFilter
+	// SortField is either date or amount, ties are broken by plaid transaction id
+	SortField  string
+	Descending bool
+	// After resumes the query right after the cursor
+	After *TransactionCursor
+	Limit int
+}
+
+// SpendFilter selects a user's outgoing, posted, non transfer transactions between Start and End
+type SpendFilter struct {
+	UserId    primitive.ObjectID
+	Start     time.Time
+	End       time.Time
+	Category  string
+	AccountId string
+}
+
+type TransactionRepo interface {
+	// Upsert saves transactions fetched from plaid without overwriting their in plan status
+	Upsert(ctx context.Context, transactions []*models.Transaction) error
+	// Prune deletes the user's transactions plaid no longer returns: the ones on accountIds dated since
+	// or later that are not in fetched, and the pending ones a fetched transaction posted under a new
+	// id. The in plan status of a pending transaction is handed over to the one it posted as.
+	Prune(ctx context.Context, userId primitive.ObjectID, accountIds []string, since int64, fetched []*models.Transaction) (int64, error)
+	Query(ctx context.Context, q TransactionQuery) ([]*models.Transaction, error)
+	// InPlan maps the plaid transaction id of every in plan transaction of the user to its plan
+	InPlan(ctx context.Context, userId primitive.ObjectID) (map[string]string, error)
+	// MarkInPlan covers the transactions by the payment plan, all or none of them. It returns ErrConflict
+	// if another plan covers any of them, and ErrNotFound if any of them was never stored.
+	MarkInPlan(ctx context.Context, userId primitive.ObjectID, paymentPlanId string, transactionIds []string) error
+	// ReleasePlan uncovers the transactions of the user covered by the payment plan
+	ReleasePlan(ctx context.Context, userId primitive.ObjectID, paymentPlanId string) error
+
+	TotalSpend(ctx context.Context, f SpendFilter) (float64, error)
+	SpendByCategory(ctx context.Context, f SpendFilter, detailed bool) ([]models.CategorySpend, error)
+	// SpendByMerchant orders merchants by total spend, or by number of charges when byCount is
+	// set, and returns at most limit of them when limit is positive
+	SpendByMerchant(ctx context.Context, f SpendFilter, byCount bool, limit int) ([]models.MerchantSpend, error)
+	MonthlySpendByCategory(ctx context.Context, f SpendFilter) ([]models.MonthCategorySpend, error)
+	// MerchantCharges returns the charges of every merchant with at least minCharges of them
+	MerchantCharges(ctx context.Context, f SpendFilter, minCharges int) ([]models.ChargeSeries, error)
+	DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error)
+}
+
+type MongoTransactionRepo struct {
+	Db *mongo.Collection
+}
+
+func NewMongoTransactionRepo(db *mongo.Collection) *MongoTransactionRepo {
+	return &MongoTransactionRepo{Db: db}
+}
+
+func (r *MongoTransactionRepo) Upsert(ctx context.Context, transactions []*models.Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, len(transactions))
+	for _, transaction := range transactions {
+		fields, err := toFields(transaction)
+		if err != nil {
+			return err
+		}
+		delete(fields, "in_plan")
+		delete(fields, "payment_plan_id")
+		delete(fields, "created_at")
+		fields["updated_at"] = now
+
+		filter := bson.M{"user_id": transaction.UserId, "plaid_transaction_id": transaction.PlaidTransactionId}
+		update := bson.M{
+			"$set":         fields,
+			"$setOnInsert": bson.M{"in_plan": false, "created_at": now},
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+	}
+
+	_, err := r.Db.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (r *MongoTransactionRepo) Prune(ctx context.Context, userId primitive.ObjectID, accountIds []string, since int64, fetched []*models.Transaction) (int64, error) {
+	keep := make([]string, 0, len(fetched))
+	postedAs := make(map[string]string)
+	for _, trxn := range fetched {
+		keep = append(keep, trxn.PlaidTransactionId)
+		if trxn.PendingTransactionId != "" {
+			postedAs[trxn.PendingTransactionId] = trxn.PlaidTransactionId
+		}
+	}
+	superseded := make([]string, 0, len(postedAs))
+	for id := range postedAs {
+		superseded = append(superseded, id)
+	}
+	if accountIds == nil {
+		accountIds = []string{}
+	}
+
+	filter := bson.M{
+		"user_id":              userId,
+		"plaid_transaction_id": bson.M{"$nin": keep},
+		"$or": bson.A{
+			bson.M{"plaid_account_id": bson.M{"$in": accountIds}, "date": bson.M{"$gte": since}},
+			bson.M{"plaid_transaction_id": bson.M{"$in": superseded}},
+		},
+	}
+	var stale []models.Transaction
+	cursor, err := r.Db.Find(ctx, filter, options.Find().SetProjection(bson.M{"plaid_transaction_id": 1, "in_plan": 1, "payment_plan_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	if err = cursor.All(ctx, &stale); err != nil {
+		return 0, err
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, len(stale))
+	for idx, trxn := range stale {
+		ids[idx] = trxn.PlaidTransactionId
+		posted, ok := postedAs[trxn.PlaidTransactionId]
+		if !trxn.InPlan || !ok {
+			continue
+		}
+		// the plan covers the charge whatever id it posted under
+		filter := bson.M{"user_id": userId, "plaid_transaction_id": posted, "in_plan": bson.M{"$ne": true}}
+		update := bson.M{"$set": bson.M{"in_plan": true, "payment_plan_id": trxn.PaymentPlanId, "updated_at": time.Now()}}
+		if _, err = r.Db.UpdateOne(ctx, filter, update); err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := r.Db.DeleteMany(ctx, bson.M{"user_id": userId, "plaid_transaction_id": bson.M{"$in": ids}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (r *MongoTransactionRepo) Query(ctx context.Context, q TransactionQuery) ([]*models.Transaction, error) {
+	filter := transactionFilter(q.Filter)
+	direction := 1
+	if q.Descending {
+		direction = -1
+	}
+	if q.After != nil {
+		op := "$gt"
+		if q.Descending {
+			op = "$lt"
+		}
+		filter = append(filter, bson.E{Key: "$and", Value: bson.A{bson.M{"$or": bson.A{
+			bson.M{q.SortField: bson.M{op: q.After.Value}},
+			bson.M{q.SortField: q.After.Value, "plaid_transaction_id": bson.M{op: q.After.ID}},
+		}}}})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: q.SortField, Value: direction}, {Key: "plaid_transaction_id", Value: direction}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	cursor, err := r.Db.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	transactions := make([]*models.Transaction, 0)
+	if err = cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+func transactionFilter(f TransactionFilter) bson.D {
+	filter := bson.D{{Key: "user_id", Value: f.UserId}}
+
+	date := bson.M{}
+	if f.StartDate != nil {
+		date["$gte"] = *f.StartDate
+	}
+	if f.EndDate != nil {
+		date["$lte"] = *f.EndDate
+	}
+	if len(date) > 0 {
+		filter = append(filter, bson.E{Key: "date", Value: date})
+	}
+
+	for key, value := range map[string]string{
+		"plaid_account_id":  f.AccountId,
+		"primary_category":  f.PrimaryCategory,
+		"detailed_category": f.DetailedCategory,
+		"merchant_name":     f.Merchant,
+	} {
+		if value != "" {
+			filter = append(filter, bson.E{Key: key, Value: value})
+		}
+	}
+
+	amount := bson.M{}
+	if f.MinAmount != nil {
+		amount["$gte"] = *f.MinAmount
+	}
+	if f.MaxAmount != nil {
+		amount["$lte"] = *f.MaxAmount
+	}
+	if len(amount) > 0 {
+		filter = append(filter, bson.E{Key: "amount", Value: amount})
+	}
+
+	if f.Pending != nil {
+		filter = append(filter, bson.E{Key: "pending", Value: *f.Pending})
+	}
+	if f.InPlan != nil {
+		filter = append(filter, bson.E{Key: "in_plan", Value: *f.InPlan})
+	}
+
+	if f.Search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(f.Search), Options: "i"}
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"name": pattern},
+			bson.M{"merchant_name": pattern},
+		}})
+	}
+	return filter
+}
+
+func (r *MongoTransactionRepo) InPlan(ctx context.Context, userId primitive.ObjectID) (map[string]string, error) {
+	var results []models.Transaction
+	cursor, err := r.Db.Find(ctx, bson.M{"user_id": userId, "in_plan": true})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	inPlan := make(map[string]string, len(results))
+	for _, trxn := range results {
+		inPlan[trxn.PlaidTransactionId] = trxn.PaymentPlanId
+	}
+	return inPlan, nil
+}
+
+func (r *MongoTransactionRepo) MarkInPlan(ctx context.Context, userId primitive.ObjectID, paymentPlanId string, transactionIds []string) error {
+	var claimed []string
+	for _, id := range transactionIds {
+		// only claimed while no plan covers it, so concurrent plans can't both claim a transaction
+		filter := bson.M{"user_id": userId, "plaid_transaction_id": id, "in_plan": bson.M{"$ne": true}}
+		update := bson.M{"$set": bson.M{"in_plan": true, "payment_plan_id": paymentPlanId, "updated_at": time.Now()}}
+		res, err := r.Db.UpdateOne(ctx, filter, update)
+		if err == nil && res.MatchedCount == 0 {
+			err = r.coveredBy(ctx, userId, id, paymentPlanId)
+		}
+		if err != nil {
+			r.release(ctx, userId, paymentPlanId, claimed)
+			return err
+		}
+		if res.MatchedCount > 0 {
+			claimed = append(claimed, id)
+		}
+	}
+	return nil
+}
+
+// coveredBy returns nil if the transaction is already covered by the payment plan, ErrConflict if it is
+// covered by another one
+func (r *MongoTransactionRepo) coveredBy(ctx context.Context, userId primitive.ObjectID, transactionId, paymentPlanId string) error {
+	var trxn models.Transaction
+	if err := r.Db.FindOne(ctx, bson.M{"user_id": userId, "plaid_transaction_id": transactionId}).Decode(&trxn); err != nil {
+		return notFound(err)
+	}
+	if trxn.InPlan && trxn.PaymentPlanId != paymentPlanId {
+		return ErrConflict
+	}
+	return nil
+}
+
+// release undoes the claim of MarkInPlan on the transactions, it is best effort as the claim already
+// failed
+func (r *MongoTransactionRepo) release(ctx context.Context, userId primitive.ObjectID, paymentPlanId string, transactionIds []string) {
+	if len(transactionIds) == 0 {
+		return
+	}
+	filter := bson.M{"user_id": userId, "payment_plan_id": paymentPlanId, "plaid_transaction_id": bson.M{"$in": transactionIds}}
+	update := bson.M{
+		"$set":   bson.M{"in_plan": false, "updated_at": time.Now()},
+		"$unset": bson.M{"payment_plan_id": ""},
+	}
+	_, _ = r.Db.UpdateMany(ctx, filter, update)
+}
+
+func (r *MongoTransactionRepo) ReleasePlan(ctx context.Context, userId primitive.ObjectID, paymentPlanId string) error {
+	if paymentPlanId == "" {
+		return nil
+	}
+
+	filter := bson.M{"user_id": userId, "payment_plan_id": paymentPlanId}
+	update := bson.M{
+		"$set":   bson.M{"in_plan": false, "updated_at": time.Now()},
+		"$unset": bson.M{"payment_plan_id": ""},
+	}
+	_, err := r.Db.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// spendMatchStage selects the transactions counted by the spend filter
+func spendMatchStage(f SpendFilter) bson.D {
+	match := bson.M{
+		"user_id":          f.UserId,
+		"date":             bson.M{"$gte": f.Start.UnixMilli(), "$lte": f.End.UnixMilli()},
+		"amount":           bson.M{"$gt": 0},
+		"primary_category": bson.M{"$nin": NonSpendCategories},
+		// a pending charge is counted once it posts, possibly under a new id and amount
+		"pending": bson.M{"$ne": true},
+	}
+	if f.Category != "" {
+		match["primary_category"] = f.Category
+	}
+	if f.AccountId != "" {
+		match["plaid_account_id"] = f.AccountId
+	}
+	return bson.D{{Key: "$match", Value: match}}
+}
+
+// merchantKey groups by merchant name, falling back to the transaction name when plaid could not
+// identify the merchant
+func merchantKey() bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$merchant_name", ""}}, ""}},
+		"$merchant_name",
+		"$name",
+	}}
+}
+
+func (r *MongoTransactionRepo) aggregate(ctx context.Context, pipeline mongo.Pipeline, results any) error {
+	cursor, err := r.Db.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+func (r *MongoTransactionRepo) TotalSpend(ctx context.Context, f SpendFilter) (float64, error) {
+	pipeline := mongo.Pipeline{
+		spendMatchStage(f),
+		{{Key: "$group", Value: bson.M{"_id": nil, "total": bson.M{"$sum": "$amount"}}}},
+	}
+	var results []struct {
+		Total float64 `bson:"total"`
+	}
+	if err := r.aggregate(ctx, pipeline, &results); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Total, nil
+}
+
+func (r *MongoTransactionRepo) SpendByCategory(ctx context.Context, f SpendFilter, detailed bool) ([]models.CategorySpend, error) {
+	field := "$primary_category"
+	if detailed {
+		field = "$detailed_category"
+	}
+	pipeline := mongo.Pipeline{
+		spendMatchStage(f),
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$ifNull": bson.A{field, "UNCATEGORIZED"}},
+			"total": bson.M{"$sum": "$amount"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}}}},
+	}
+	items := make([]models.CategorySpend, 0)
+	if err := r.aggregate(ctx, pipeline, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *MongoTransactionRepo) SpendByMerchant(ctx context.Context, f SpendFilter, byCount bool, limit int) ([]models.MerchantSpend, error) {
+	sortBy := bson.D{{Key: "total", Value: -1}}
+	if byCount {
+		sortBy = bson.D{{Key: "count", Value: -1}, {Key: "total", Value: -1}}
+	}
+	pipeline := mongo.Pipeline{
+		spendMatchStage(f),
+		{{Key: "$group", Value: bson.M{
+			"_id":            merchantKey(),
+			"total":          bson.M{"$sum": "$amount"},
+			"count":          bson.M{"$sum": 1},
+			"average_amount": bson.M{"$avg": "$amount"},
+			"last_date":      bson.M{"$max": "$date"},
+		}}},
+		{{Key: "$sort", Value: sortBy}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+	items := make([]models.MerchantSpend, 0)
+	if err := r.aggregate(ctx, pipeline, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *MongoTransactionRepo) MonthlySpendByCategory(ctx context.Context, f SpendFilter) ([]models.MonthCategorySpend, error) {
+	pipeline := mongo.Pipeline{
+		spendMatchStage(f),
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"month":    bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": bson.M{"$toDate": "$date"}}},
+				"category": bson.M{"$ifNull": bson.A{"$primary_category", "UNCATEGORIZED"}},
+			},
+			"total": bson.M{"$sum": "$amount"},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "month": "$_id.month", "category": "$_id.category", "total": 1}}},
+	}
+	items := make([]models.MonthCategorySpend, 0)
+	if err := r.aggregate(ctx, pipeline, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *MongoTransactionRepo) MerchantCharges(ctx context.Context, f SpendFilter, minCharges int) ([]models.ChargeSeries, error) {
+	pipeline := mongo.Pipeline{
+		spendMatchStage(f),
+		{{Key: "$sort", Value: bson.D{{Key: "date", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     merchantKey(),
+			"dates":   bson.M{"$push": "$date"},
+			"amounts": bson.M{"$push": "$amount"},
+		}}},
+	}
+	if minCharges > 1 {
+		key := "dates." + strconv.Itoa(minCharges-1)
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{key: bson.M{"$exists": true}}}})
+	}
+	series := make([]models.ChargeSeries, 0)
+	if err := r.aggregate(ctx, pipeline, &series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+func (r *MongoTransactionRepo) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error) {
+	res, err := r.Db.DeleteMany(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+type MemoryTransactionRepo struct {
+	mu           sync.RWMutex
+	transactions map[string]*models.Transaction
+}
+
+func NewMemoryTransactionRepo() *MemoryTransactionRepo {
+	return &MemoryTransactionRepo{transactions: make(map[string]*models.Transaction)}
+}
+
+func transactionKey(userId primitive.ObjectID, plaidTransactionId string) string {
+	return userId.Hex() + "/" + plaidTransactionId
+}
+
+func (r *MemoryTransactionRepo) Upsert(_ context.Context, transactions []*models.Transaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, transaction := range transactions {
+		key := transactionKey(transaction.UserId, transaction.PlaidTransactionId)
+		saved := *transaction
+		saved.InPlan, saved.PaymentPlanId, saved.CreatedAt = false, "", now
+		if existing, ok := r.transactions[key]; ok {
+			saved.InPlan, saved.PaymentPlanId, saved.CreatedAt = existing.InPlan, existing.PaymentPlanId, existing.CreatedAt
+		}
+		saved.UpdatedAt = now
+		r.transactions[key] = &saved
+	}
+	return nil
+}
+
+func (r *MemoryTransactionRepo) Prune(_ context.Context, userId primitive.ObjectID, accountIds []string, since int64, fetched []*models.Transaction) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keep := make(map[string]bool, len(fetched))
+	postedAs := make(map[string]string)
+	for _, trxn := range fetched {
+		keep[trxn.PlaidTransactionId] = true
+		if trxn.PendingTransactionId != "" {
+			postedAs[trxn.PendingTransactionId] = trxn.PlaidTransactionId
+		}
+	}
+	accounts := make(map[string]bool, len(accountIds))
+	for _, id := range accountIds {
+		accounts[id] = true
+	}
+
+	var deleted int64
+	for key, t := range r.transactions {
+		posted, superseded := postedAs[t.PlaidTransactionId]
+		if t.UserId != userId || keep[t.PlaidTransactionId] || !(superseded || (accounts[t.PlaidAccountId] && t.Date >= since)) {
+			continue
+		}
+		if successor, ok := r.transactions[transactionKey(userId, posted)]; ok && superseded && t.InPlan && !successor.InPlan {
+			successor.InPlan, successor.PaymentPlanId, successor.UpdatedAt = true, t.PaymentPlanId, time.Now()
+		}
+		delete(r.transactions, key)
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (r *MemoryTransactionRepo) matching(f TransactionFilter) []*models.Transaction {
+	var results []*models.Transaction
+	search := strings.ToLower(f.Search)
+	for _, t := range r.transactions {
+		switch {
+		case t.UserId != f.UserId,
+			f.StartDate != nil && t.Date < *f.StartDate,
+			f.EndDate != nil && t.Date > *f.EndDate,
+			f.AccountId != "" && t.PlaidAccountId != f.AccountId,
+			f.PrimaryCategory != "" && t.PrimaryCategory != f.PrimaryCategory,
+			f.DetailedCategory != "" && t.DetailedCategory != f.DetailedCategory,
+			f.Merchant != "" && t.MerchantName != f.Merchant,
+			f.MinAmount != nil && t.Amount < *f.MinAmount,
+			f.MaxAmount != nil && t.Amount > *f.MaxAmount,
+			f.Pending != nil && t.Pending != *f.Pending,
+			f.InPlan != nil && t.InPlan != *f.InPlan,
+			search != "" && !strings.Contains(strings.ToLower(t.Name), search) && !strings.Contains(strings.ToLower(t.MerchantName), search):
+			continue
+		}
+		found := *t
+		results = append(results, &found)
+	}
+	return results
+}
+
+func (r *MemoryTransactionRepo) Query(_ context.Context, q TransactionQuery) ([]*models.Transaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	value := func(t *models.Transaction) float64 {
+		if q.SortField == "amount" {
+			return t.Amount
+		}
+		return float64(t.Date)
+	}
+	// less reports whether a comes before b in ascending order
+	less := func(av float64, aid string, bv float64, bid string) bool {
+		if av != bv {
+			return av < bv
+		}
+		return aid < bid
+	}
+
+	results := make([]*models.Transaction, 0)
+	for _, t := range r.matching(q.Filter) {
+		if q.After != nil {
+			after := less(q.After.Value, q.After.ID, value(t), t.PlaidTransactionId)
+			if q.Descending {
+				after = less(value(t), t.PlaidTransactionId, q.After.Value, q.After.ID)
+			}
+			if !after {
+				continue
+			}
+		}
+		results = append(results, t)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if q.Descending {
+			i, j = j, i
+		}
+		return less(value(results[i]), results[i].PlaidTransactionId, value(results[j]), results[j].PlaidTransactionId)
+	})
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+func (r *MemoryTransactionRepo) InPlan(_ context.Context, userId primitive.ObjectID) (map[string]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	inPlan := make(map[string]string)
+	for _, t := range r.transactions {
+		if t.UserId == userId && t.InPlan {
+			inPlan[t.PlaidTransactionId] = t.PaymentPlanId
+		}
+	}
+	return inPlan, nil
+}
+
+func (r *MemoryTransactionRepo) MarkInPlan(_ context.Context, userId primitive.ObjectID, paymentPlanId string, transactionIds []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range transactionIds {
+		t, ok := r.transactions[transactionKey(userId, id)]
+		if !ok {
+			return ErrNotFound
+		}
+		if t.InPlan && t.PaymentPlanId != paymentPlanId {
+			return ErrConflict
+		}
+	}
+	for _, id := range transactionIds {
+		t := r.transactions[transactionKey(userId, id)]
+		t.InPlan, t.PaymentPlanId, t.UpdatedAt = true, paymentPlanId, time.Now()
+	}
+	return nil
+}
+
+func (r *MemoryTransactionRepo) ReleasePlan(_ context.Context, userId primitive.ObjectID, paymentPlanId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.transactions {
+		if paymentPlanId != "" && t.UserId == userId && t.PaymentPlanId == paymentPlanId {
+			t.InPlan, t.PaymentPlanId, t.UpdatedAt = false, "", time.Now()
+		}
+	}
+	return nil
+}
+
+// spend returns the transactions counted by the spend filter in chronological order
+func (r *MemoryTransactionRepo) spend(f SpendFilter) []*models.Transaction {
+	start, end := f.Start.UnixMilli(), f.End.UnixMilli()
+	results := make([]*models.Transaction, 0)
+	for _, t := range r.matching(TransactionFilter{UserId: f.UserId, StartDate: &start, EndDate: &end, AccountId: f.AccountId}) {
+		if t.Amount <= 0 || t.Pending || (f.Category != "" && t.PrimaryCategory != f.Category) {
+			continue
+		}
+		excluded := false
+		for _, category := range NonSpendCategories {
+			excluded = excluded || t.PrimaryCategory == category
+		}
+		if !excluded {
+			results = append(results, t)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Date < results[j].Date })
+	return results
+}
+
+func merchantOf(t *models.Transaction) string {
+	if t.MerchantName != "" {
+		return t.MerchantName
+	}
+	return t.Name
+}
+
+func categoryOf(category string) string {
+	if category == "" {
+		return "UNCATEGORIZED"
+	}
+	return category
+}
+
+func (r *MemoryTransactionRepo) TotalSpend(_ context.Context, f SpendFilter) (float64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	total := 0.0
+	for _, t := range r.spend(f) {
+		total += t.Amount
+	}
+	return total, nil
+}
+
+func (r *MemoryTransactionRepo) SpendByCategory(_ context.Context, f SpendFilter, detailed bool) ([]models.CategorySpend, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	byCategory := make(map[string]*models.CategorySpend)
+	for _, t := range r.spend(f) {
+		category := categoryOf(t.PrimaryCategory)
+		if detailed {
+			category = categoryOf(t.DetailedCategory)
+		}
+		if _, ok := byCategory[category]; !ok {
+			byCategory[category] = &models.CategorySpend{Category: category}
+		}
+		byCategory[category].Total += t.Amount
+		byCategory[category].Count++
+	}
+
+	items := make([]models.CategorySpend, 0, len(byCategory))
+	for _, item := range byCategory {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Total > items[j].Total })
+	return items, nil
+}
+
+func (r *MemoryTransactionRepo) SpendByMerchant(_ context.Context, f SpendFilter, byCount bool, limit int) ([]models.MerchantSpend, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	byMerchant := make(map[string]*models.MerchantSpend)
+	for _, t := range r.spend(f) {
+		merchant := merchantOf(t)
+		if _, ok := byMerchant[merchant]; !ok {
+			byMerchant[merchant] = &models.MerchantSpend{Merchant: merchant}
+		}
+		item := byMerchant[merchant]
+		item.Total += t.Amount
+		item.Count++
+		item.AverageAmount = item.Total / float64(item.Count)
+		if t.Date > item.LastDate {
+			item.LastDate = t.Date
+		}
+	}
+
+	items := make([]models.MerchantSpend, 0, len(byMerchant))
+	for _, item := range byMerchant {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if byCount && items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Total > items[j].Total
+	})
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+func (r *MemoryTransactionRepo) MonthlySpendByCategory(_ context.Context, f SpendFilter) ([]models.MonthCategorySpend, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	type key struct{ month, category string }
+	totals := make(map[key]float64)
+	var order []key
+	for _, t := range r.spend(f) {
+		k := key{time.UnixMilli(t.Date).UTC().Format("2006-01"), categoryOf(t.PrimaryCategory)}
+		if _, ok := totals[k]; !ok {
+			order = append(order, k)
+		}
+		totals[k] += t.Amount
+	}
+
+	items := make([]models.MonthCategorySpend, 0, len(order))
+	for _, k := range order {
+		items = append(items, models.MonthCategorySpend{Month: k.month, Category: k.category, Total: totals[k]})
+	}
+	return items, nil
+}
+
+func (r *MemoryTransactionRepo) MerchantCharges(_ context.Context, f SpendFilter, minCharges int) ([]models.ChargeSeries, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	byMerchant := make(map[string]*models.ChargeSeries)
+	var order []string
+	for _, t := range r.spend(f) {
+		merchant := merchantOf(t)
+		if _, ok := byMerchant[merchant]; !ok {
+			byMerchant[merchant] = &models.ChargeSeries{Merchant: merchant}
+			order = append(order, merchant)
+		}
+		byMerchant[merchant].Dates = append(byMerchant[merchant].Dates, t.Date)
+		byMerchant[merchant].Amounts = append(byMerchant[merchant].Amounts, t.Amount)
+	}
+
+	series := make([]models.ChargeSeries, 0)
+	for _, merchant := range order {
+		if len(byMerchant[merchant].Dates) >= minCharges {
+			series = append(series, *byMerchant[merchant])
+		}
+	}
+	return series, nil
+}
+
+func (r *MemoryTransactionRepo) DeleteByUser(_ context.Context, userId primitive.ObjectID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted int64
+	for key, t := range r.transactions {
+		if t.UserId == userId {
+			delete(r.transactions, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
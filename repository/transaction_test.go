@@ -0,0 +1,168 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMarkInPlan(t *testing.T) {
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	tests := []struct {
+		name   string
+		userId primitive.ObjectID
+		planId string
+		ids    []string
+		err    error
+		// inPlan is alice's transactions covered afterwards, by plan
+		inPlan map[string]string
+	}{
+		{name: "uncovered transactions", userId: alice, planId: "plan-2", ids: []string{"t2", "t3"}, inPlan: map[string]string{"t1": "plan-1", "t2": "plan-2", "t3": "plan-2"}},
+		{name: "transactions already covered by the plan", userId: alice, planId: "plan-1", ids: []string{"t1", "t2"}, inPlan: map[string]string{"t1": "plan-1", "t2": "plan-1"}},
+		{name: "transaction covered by another plan", userId: alice, planId: "plan-2", ids: []string{"t2", "t1"}, err: repository.ErrConflict, inPlan: map[string]string{"t1": "plan-1"}},
+		{name: "transaction never stored", userId: alice, planId: "plan-2", ids: []string{"t2", "t9"}, err: repository.ErrNotFound, inPlan: map[string]string{"t1": "plan-1"}},
+		{name: "transaction of another user", userId: bob, planId: "plan-2", ids: []string{"t2"}, err: repository.ErrNotFound, inPlan: map[string]string{"t1": "plan-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := repository.NewMemoryTransactionRepo()
+			var transactions []*models.Transaction
+			for _, id := range []string{"t1", "t2", "t3"} {
+				transactions = append(transactions, &models.Transaction{ID: id, UserId: alice, PlaidTransactionId: id, Amount: 10})
+			}
+			if err := repo.Upsert(ctx, transactions); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.MarkInPlan(ctx, alice, "plan-1", []string{"t1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := repo.MarkInPlan(ctx, tt.userId, tt.planId, tt.ids); !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			inPlan, err := repo.InPlan(ctx, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(inPlan) != len(tt.inPlan) {
+				t.Fatalf("got transactions in plan %v, want %v", inPlan, tt.inPlan)
+			}
+			for id, planId := range tt.inPlan {
+				if inPlan[id] != planId {
+					t.Fatalf("got transactions in plan %v, want %v", inPlan, tt.inPlan)
+				}
+			}
+		})
+	}
+}
+
+func TestReleasePlanIsScopedToTheUser(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	repo := repository.NewMemoryTransactionRepo()
+	for _, userId := range []primitive.ObjectID{alice, bob} {
+		if err := repo.Upsert(ctx, []*models.Transaction{{ID: "t1", UserId: userId, PlaidTransactionId: "t1", Amount: 10}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.MarkInPlan(ctx, userId, "plan-1", []string{"t1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := repo.ReleasePlan(ctx, bob, "plan-1"); err != nil {
+		t.Fatal(err)
+	}
+	if inPlan, _ := repo.InPlan(ctx, alice); inPlan["t1"] != "plan-1" {
+		t.Fatalf("got alice's transactions in plan %v after releasing bob's, want t1", inPlan)
+	}
+	if inPlan, _ := repo.InPlan(ctx, bob); len(inPlan) != 0 {
+		t.Fatalf("got bob's transactions in plan %v after releasing them, want none", inPlan)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	const since = 1000
+	tests := []struct {
+		name       string
+		accountIds []string
+		fetched    []*models.Transaction
+		deleted    int64
+		// left is alice's transactions stored afterwards
+		left []string
+		// inPlan is alice's transactions covered afterwards, by plan
+		inPlan map[string]string
+	}{
+		{name: "everything fetched", accountIds: []string{"card"}, fetched: []*models.Transaction{{PlaidTransactionId: "t1"}, {PlaidTransactionId: "p1"}, {PlaidTransactionId: "old"}}, left: []string{"old", "other", "p1", "t1"}, inPlan: map[string]string{"p1": "plan-1"}},
+		{name: "dropped transactions", accountIds: []string{"card"}, fetched: []*models.Transaction{{PlaidTransactionId: "t1"}}, deleted: 1, left: []string{"old", "other", "t1"}, inPlan: map[string]string{}},
+		{name: "accounts not fetched", accountIds: []string{"savings"}, fetched: []*models.Transaction{}, left: []string{"old", "other", "p1", "t1"}, inPlan: map[string]string{"p1": "plan-1"}},
+		{name: "pending transaction posted under a new id", accountIds: []string{}, fetched: []*models.Transaction{{PlaidTransactionId: "t1"}, {PlaidTransactionId: "t2", PendingTransactionId: "p1"}}, deleted: 1, left: []string{"old", "other", "t1", "t2"}, inPlan: map[string]string{"t2": "plan-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := repository.NewMemoryTransactionRepo()
+			transactions := []*models.Transaction{
+				{ID: "t1", UserId: alice, PlaidAccountId: "card", PlaidTransactionId: "t1", Date: since + 10},
+				{ID: "p1", UserId: alice, PlaidAccountId: "card", PlaidTransactionId: "p1", Date: since + 20, Pending: true},
+				// dated before the fetched window
+				{ID: "old", UserId: alice, PlaidAccountId: "card", PlaidTransactionId: "old", Date: since - 10},
+				{ID: "other", UserId: alice, PlaidAccountId: "other-card", PlaidTransactionId: "other", Date: since + 10},
+				{ID: "b1", UserId: bob, PlaidAccountId: "card", PlaidTransactionId: "b1", Date: since + 10},
+			}
+			for _, trxn := range tt.fetched {
+				if trxn.PendingTransactionId != "" {
+					transactions = append(transactions, &models.Transaction{ID: trxn.PlaidTransactionId, UserId: alice, PlaidAccountId: "card", PlaidTransactionId: trxn.PlaidTransactionId, PendingTransactionId: trxn.PendingTransactionId, Date: since + 30})
+				}
+			}
+			if err := repo.Upsert(ctx, transactions); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.MarkInPlan(ctx, alice, "plan-1", []string{"p1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			deleted, err := repo.Prune(ctx, alice, tt.accountIds, since, tt.fetched)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tt.deleted {
+				t.Fatalf("got %d transactions deleted, want %d", deleted, tt.deleted)
+			}
+			left, err := repo.Query(ctx, repository.TransactionQuery{Filter: repository.TransactionFilter{UserId: alice}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]string, len(left))
+			for idx, trxn := range left {
+				ids[idx] = trxn.PlaidTransactionId
+			}
+			sort.Strings(ids)
+			if strings.Join(ids, ",") != strings.Join(tt.left, ",") {
+				t.Fatalf("got transactions %v left, want %v", ids, tt.left)
+			}
+			inPlan, err := repo.InPlan(ctx, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(inPlan) != len(tt.inPlan) {
+				t.Fatalf("got transactions in plan %v, want %v", inPlan, tt.inPlan)
+			}
+			for id, planId := range tt.inPlan {
+				if inPlan[id] != planId {
+					t.Fatalf("got transactions in plan %v, want %v", inPlan, tt.inPlan)
+				}
+			}
+			if bobs, _ := repo.Query(ctx, repository.TransactionQuery{Filter: repository.TransactionFilter{UserId: bob}}); len(bobs) != 1 {
+				t.Fatalf("got %d of bob's transactions, want 1", len(bobs))
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type UserRepo interface {
+	GetByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByClerkId(ctx context.Context, clerkId string) (*models.User, error)
+	List(ctx context.Context) ([]models.User, error)
+	// Create inserts the user, assigning it a new id when it has none
+	Create(ctx context.Context, user *models.User) (primitive.ObjectID, error)
+	UpdatePhoneNumber(ctx context.Context, id primitive.ObjectID, phoneNumber string) (int64, error)
+	UpdateNotificationPreferences(ctx context.Context, id primitive.ObjectID, prefs *models.NotificationPreferences) (int64, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type MongoUserRepo struct {
+	Db *mongo.Collection
+}
+
+func NewMongoUserRepo(db *mongo.Collection) *MongoUserRepo {
+	return &MongoUserRepo{Db: db}
+}
+
+func (r *MongoUserRepo) findOne(ctx context.Context, filter bson.M) (*models.User, error) {
+	var user models.User
+	if err := r.Db.FindOne(ctx, filter).Decode(&user); err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *MongoUserRepo) GetByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+func (r *MongoUserRepo) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"email": email})
+}
+
+func (r *MongoUserRepo) GetByClerkId(ctx context.Context, clerkId string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"clerk_id": clerkId})
+}
+
+func (r *MongoUserRepo) List(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	cursor, err := r.Db.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *MongoUserRepo) Create(ctx context.Context, user *models.User) (primitive.ObjectID, error) {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	if _, err := r.Db.InsertOne(ctx, user); err != nil {
+		return primitive.NilObjectID, err
+	}
+	return user.ID, nil
+}
+
+func (r *MongoUserRepo) update(ctx context.Context, id primitive.ObjectID, fields bson.M) (int64, error) {
+	fields["updated_at"] = time.Now()
+	res, err := r.Db.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return 0, err
+	}
+	if res.MatchedCount == 0 {
+		return 0, ErrNotFound
+	}
+	return res.ModifiedCount, nil
+}
+
+func (r *MongoUserRepo) UpdatePhoneNumber(ctx context.Context, id primitive.ObjectID, phoneNumber string) (int64, error) {
+	return r.update(ctx, id, bson.M{"phone_number": phoneNumber})
+}
+
+func (r *MongoUserRepo) UpdateNotificationPreferences(ctx context.Context, id primitive.ObjectID, prefs *models.NotificationPreferences) (int64, error) {
+	return r.update(ctx, id, bson.M{"notification_preferences": prefs})
+}
+
+func (r *MongoUserRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.Db.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type MemoryUserRepo struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]models.User
+}
+
+func NewMemoryUserRepo() *MemoryUserRepo {
+	return &MemoryUserRepo{users: make(map[primitive.ObjectID]models.User)}
+}
+
+func (r *MemoryUserRepo) find(match func(u *models.User) bool) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, user := range r.users {
+		if match(&user) {
+			found := user
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryUserRepo) GetByID(_ context.Context, id primitive.ObjectID) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.ID == id })
+}
+
+func (r *MemoryUserRepo) GetByEmail(_ context.Context, email string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Email == email })
+}
+
+func (r *MemoryUserRepo) GetByClerkId(_ context.Context, clerkId string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.ClerkId == clerkId })
+}
+
+func (r *MemoryUserRepo) List(_ context.Context) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (r *MemoryUserRepo) Create(_ context.Context, user *models.User) (primitive.ObjectID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	r.users[user.ID] = *user
+	return user.ID, nil
+}
+
+func (r *MemoryUserRepo) update(id primitive.ObjectID, apply func(u *models.User) bool) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if !apply(&user) {
+		return 0, nil
+	}
+	user.UpdatedAt = time.Now()
+	r.users[id] = user
+	return 1, nil
+}
+
+func (r *MemoryUserRepo) UpdatePhoneNumber(_ context.Context, id primitive.ObjectID, phoneNumber string) (int64, error) {
+	return r.update(id, func(u *models.User) bool {
+		changed := u.PhoneNumber != phoneNumber
+		u.PhoneNumber = phoneNumber
+		return changed
+	})
+}
+
+func (r *MemoryUserRepo) UpdateNotificationPreferences(_ context.Context, id primitive.ObjectID, prefs *models.NotificationPreferences) (int64, error) {
+	return r.update(id, func(u *models.User) bool {
+		u.NotificationPreferences = prefs
+		return true
+	})
+}
+
+func (r *MemoryUserRepo) Delete(_ context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.users, id)
+	return nil
+}
@@ -1,45 +1,96 @@
 package router
 
 import (
-	"github.com/go-redis/cache/v8"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"github.com/gofiber/fiber/v2"
-	"github.com/jalexanderII/zero-railway/handlers"
-	"github.com/jalexanderII/zero-railway/models"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/auth"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/repository"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func GetUserFromClerkId(UserDb *mongo.Collection, rcache *cache.Cache) fiber.Handler {
+func GetUserFromClerkId(users repository.UserRepo, rcache *caching.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		ctx := c.Context()
+		ctx := c.UserContext()
 		clerkId := c.Get("Clerk")
+		if clerkId == "" {
+			return c.Next()
+		}
 
-		var user models.User
-
-		err := rcache.Get(ctx, clerkId, &user)
-		if err != nil && err != cache.ErrCacheMiss {
-			return handlers.FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed get user from cache", err.Error())
+		user, err := rcache.Users.GetByClerkId(ctx, clerkId)
+		if err != nil && err != caching.ErrMiss {
+			return apierror.Wrap(err, "failed get user from cache")
 		}
 
-		if err == cache.ErrCacheMiss && clerkId != "" {
-			filter := bson.M{"clerk_id": clerkId}
-			err = UserDb.FindOne(ctx, filter).Decode(&user)
+		if err == caching.ErrMiss {
+			user, err = users.GetByClerkId(ctx, clerkId)
 			if err != nil {
-				l.Errorf("failed to get a user: %s", clerkId)
-				return handlers.FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed getting users from headers", err.Error())
+				logging.FromContext(ctx).WithError(err).WithField("clerk_id", clerkId).Error("failed to get a user")
+				return apierror.Wrap(err, "failed getting users from headers")
+			}
+			if err = rcache.Users.Set(ctx, user); err != nil {
+				return apierror.Wrap(err, "failed set user in cache")
 			}
+		}
+
+		if user.GetID() != nil {
+			auth.SetPrincipal(c, user)
+			// correlate the request's logs with the user
+			c.SetUserContext(logging.WithFields(ctx, logrus.Fields{"user_id": user.GetID().Hex()}))
+		}
+
+		return c.Next()
+	}
+}
 
-			if err = rcache.Set(&cache.Item{
-				Ctx:   ctx,
-				Key:   clerkId,
-				Value: &user,
-				TTL:   24 * time.Hour,
-			}); err != nil {
-				return handlers.FiberJsonResponse(c, fiber.StatusInternalServerError, "error", "failed set user in cache", err.Error())
+// clerkWebhookTolerance is how far the timestamp of a clerk webhook may be from now
+const clerkWebhookTolerance = 5 * time.Minute
+
+// VerifyClerkWebhook rejects webhooks not signed by clerk with secret, the whsec_ secret of the endpoint.
+// Clerk signs webhooks through svix: the signature is the HMAC-SHA256 of "id.timestamp.body".
+func VerifyClerkWebhook(secret string) fiber.Handler {
+	key, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	return func(c *fiber.Ctx) error {
+		id, timestamp := c.Get("svix-id"), c.Get("svix-timestamp")
+		sent, err := strconv.ParseInt(timestamp, 10, 64)
+		if id == "" || err != nil {
+			return apierror.Unauthorized("missing webhook signature")
+		}
+		if age := time.Since(time.Unix(sent, 0)); age > clerkWebhookTolerance || age < -clerkWebhookTolerance {
+			return apierror.Unauthorized("webhook timestamp is too far from now")
+		}
+
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(id + "." + timestamp + "."))
+		mac.Write(c.Body())
+		expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		// the header lists space separated signatures, one per active secret
+		for _, signature := range strings.Fields(c.Get("svix-signature")) {
+			version, value, ok := strings.Cut(signature, ",")
+			if ok && version == "v1" && hmac.Equal([]byte(value), []byte(expected)) {
+				return c.Next()
 			}
 		}
+		return apierror.Unauthorized("invalid webhook signature")
+	}
+}
 
+// RequireBearerToken rejects requests not presenting token in their Authorization header
+func RequireBearerToken(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		header := c.Get(fiber.HeaderAuthorization)
+		presented := strings.TrimPrefix(header, "Bearer ")
+		if presented == header || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			return apierror.Unauthorized("invalid bearer token")
+		}
 		return c.Next()
 	}
 }
@@ -1,25 +1,42 @@
 package router
 
 import (
+	"context"
+
+	"github.com/go-redis/redis/extra/redisotel/v8"
 	"github.com/go-redis/redis/v8"
 
-	"github.com/go-redis/cache/v8"
-	"os"
 	"time"
 
 	client "github.com/jalexanderII/zero-railway/app/clients"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/auth"
+	"github.com/jalexanderII/zero-railway/caching"
+	"github.com/jalexanderII/zero-railway/config"
+	"github.com/jalexanderII/zero-railway/database"
 	"github.com/jalexanderII/zero-railway/handlers"
+	"github.com/jalexanderII/zero-railway/logging"
+	"github.com/jalexanderII/zero-railway/metrics"
+	"github.com/jalexanderII/zero-railway/models"
+	"github.com/jalexanderII/zero-railway/repository"
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// Create a new instance of the logger.
-var l = logrus.New()
+// Services are the dependencies the endpoints are built over
+type Services struct {
+	Repos  *repository.Repositories
+	Cache  *caching.Store
+	Plaid  *client.PlaidClient
+	Twilio *client.TwilioClient
+	// Checks are the readiness checks of the datastores, next to the ones of the external services
+	Checks []handlers.DependencyCheck
+}
 
 // SetupRoutes establish all endpoints
-func SetupRoutes(app *fiber.App) {
-	opt, err := redis.ParseURL(os.Getenv("REDIS_URI"))
+func SetupRoutes(app *fiber.App, cfg *config.Config, store *database.Store, l *logrus.Logger) {
+	opt, err := redis.ParseURL(cfg.Redis.URI)
 	if err != nil {
 		panic(err)
 	}
@@ -30,19 +47,56 @@ func SetupRoutes(app *fiber.App) {
 		Password:    opt.Password,
 		DialTimeout: opt.DialTimeout,
 	})
-	rcache := cache.New(&cache.Options{
-		Redis:      rdb,
-		LocalCache: cache.NewTinyLFU(1000, 15*time.Minute),
+	rdb.AddHook(redisotel.NewTracingHook())
+	rcache := caching.New(rdb, 1000, 15*time.Minute)
+	// drop the local copies of entries invalidated by other replicas
+	rcache.Subscribe(logging.WithEntry(context.Background(), logrus.NewEntry(l)))
+
+	Register(app, cfg, &Services{
+		Repos:  repository.NewMongoRepositories(store.Database(), cfg.Collections.Names()),
+		Cache:  rcache,
+		Plaid:  client.NewPlaidClient(cfg.Plaid, l),
+		Twilio: client.NewTwilioClient(cfg.Twilio),
+		Checks: []handlers.DependencyCheck{
+			{Name: "mongo", Critical: true, Check: store.Ready},
+			{Name: "redis", Critical: true, Check: func(ctx context.Context) error {
+				return rdb.Ping(ctx).Err()
+			}},
+		},
+	}, l)
+}
+
+// Register establish all endpoints over the services
+func Register(app *fiber.App, cfg *config.Config, s *Services, l *logrus.Logger) {
+	rcache := s.Cache
+	plaidClient := s.Plaid
+	twilioClient := s.Twilio
+	repos := s.Repos
+	repos.Users = caching.InvalidateUsers(repos.Users, rcache)
+	repos.Tokens = caching.InvalidateTokens(repos.Tokens, rcache)
+	h := handlers.NewHandler(repos, plaidClient)
+	planningURL := cfg.PlanningURL
+
+	budgetAlerter := handlers.NewBudgetAlerter(h, twilioClient)
+	plaidClient.AddIngestHook(func(ctx context.Context, userId primitive.ObjectID, _ *models.AccountDetailsResponse) {
+		if err := budgetAlerter.Evaluate(ctx, userId); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("user_id", userId.Hex()).Error("[Budget] error evaluating budgets")
+		}
 	})
 
-	plaidClient := client.NewPlaidClient(os.Getenv("PLAID_COLLECTION"), l)
-	accountHandler := handlers.NewHandler(os.Getenv("ACCOUNT_COLLECTION"), l, plaidClient)
-	transactionHandler := handlers.NewHandler(os.Getenv("TRANSACTION_COLLECTION"), l, plaidClient)
-	paymentTaskHandler := handlers.NewHandler(os.Getenv("PAYMENT_TASK_COLLECTION"), l, plaidClient)
-	userHandler := handlers.NewHandler(os.Getenv("USER_COLLECTION"), l, plaidClient)
-	planningURL := os.Getenv("PLANNING_URL")
-	twilioClient := client.NewTwilioClient(l)
-	app.Use(GetUserFromClerkId(userHandler.UserDb, rcache))
+	utilizationTracker := handlers.NewUtilizationTracker(h, twilioClient)
+	plaidClient.AddIngestHook(func(ctx context.Context, userId primitive.ObjectID, details *models.AccountDetailsResponse) {
+		if err := utilizationTracker.Record(ctx, userId, details.Accounts); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("user_id", userId.Hex()).Error("[Utilization] error recording balance snapshots")
+		}
+	})
+	dueDateReminder := handlers.NewDueDateReminder(h, twilioClient)
+	if cfg.Exports.SigningKey == "" {
+		l.Warn("EXPORT_SIGNING_KEY is not set, data export download links won't survive a restart")
+	}
+	accountDeleter := handlers.NewAccountDeleter(h, planningURL, rcache, cfg.DeletionTimeout)
+	dataExporter := handlers.NewDataExporter(h, cfg.Exports.SigningKey, cfg.Exports.URLTTL, cfg.Exports.Retention, cfg.Exports.Timeout)
+	app.Use(GetUserFromClerkId(h.Users, rcache))
 
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
@@ -50,53 +104,128 @@ func SetupRoutes(app *fiber.App) {
 		})
 	})
 
+	healthChecker := handlers.NewHealthChecker(cfg.HealthCheckTimeout, append(s.Checks,
+		handlers.DependencyCheck{
+			Name:     "planning",
+			Features: []string{"payment plans", "waterfall", "kpis", "payment notifications"},
+			Check:    handlers.HTTPCheck(h.H, planningURL),
+		},
+		handlers.DependencyCheck{
+			Name:     "plaid",
+			Features: []string{"account linking", "account refresh"},
+			Check: handlers.ConfiguredCheck(map[string]string{
+				"PLAID_CLIENT_ID": cfg.Plaid.ClientID,
+				"plaid secret":    cfg.Plaid.Secret(),
+			}),
+		},
+		handlers.DependencyCheck{
+			Name:     "twilio",
+			Features: []string{"sms notifications", "budget alerts", "due date reminders"},
+			Check: handlers.ConfiguredCheck(map[string]string{
+				"TWILIO_ACCOUNT_SID":  cfg.Twilio.AccountSid,
+				"TWILIO_AUTH_TOKEN":   cfg.Twilio.AuthToken,
+				"TWILIO_PHONE_NUMBER": cfg.Twilio.PhoneNumber,
+			}),
+		},
+	)...)
+
 	app.Get("/health", handlers.HandleHealthCheck)
-	app.Get("/clear_cache/:email", handlers.ClearCache(userHandler, rcache))
+	app.Get("/health/live", handlers.HandleLiveness)
+	app.Get("/health/ready", handlers.HandleReadiness(healthChecker))
+	if cfg.MetricsToken != "" {
+		app.Get("/metrics", RequireBearerToken(cfg.MetricsToken), metrics.Handler())
+	} else {
+		l.Warn("METRICS_TOKEN is not set, /metrics is not served")
+	}
 
 	api := app.Group("/api")
-	api.Get("/user/:id", handlers.GetUserByID(userHandler))
-	api.Get("/cleanup/:test", handlers.CleanUp(userHandler))
+	api.Get("/user/:id", auth.OwnsParam("id", auth.UserID), handlers.GetUserByID(h))
 
 	coreEndpoints := api.Group("/core")
-	coreEndpoints.Get("/kpi", handlers.GetKPIs(accountHandler, planningURL, rcache))
-	coreEndpoints.Get("/paymentplan", handlers.GetPaymentPlans(paymentTaskHandler, planningURL, rcache))
-	coreEndpoints.Post("/paymentplan", handlers.CreatePaymentPlan(paymentTaskHandler, planningURL, rcache))
-	coreEndpoints.Post("/paymentplan/delete/:id", handlers.DeletePaymentPlan(paymentTaskHandler, planningURL))
+	coreEndpoints.Get("/kpi", handlers.GetKPIs(h, planningURL, rcache))
+	coreEndpoints.Get("/paymentplan", handlers.GetPaymentPlans(h, planningURL, rcache))
+	coreEndpoints.Post("/paymentplan", handlers.CreatePaymentPlan(h, planningURL, rcache))
+	coreEndpoints.Post("/paymentplan/delete/:id", auth.OwnsParam("id", handlers.PaymentPlanOwner(h, planningURL)), handlers.DeletePaymentPlan(h, planningURL))
 
 	accounts := coreEndpoints.Group("/accounts")
-	accounts.Get("/", handlers.GetUsersAccountsByEmail(accountHandler, rcache))
-	accounts.Get("/user_id/:user_id", handlers.GetUsersAccountsByUserID(accountHandler, rcache))
-	accounts.Get("/acc_id/:acc_id/:user_id", handlers.GetAccount(accountHandler, rcache))
+	accounts.Get("/", handlers.GetUsersAccountsByEmail(h, rcache))
+	accounts.Get("/user_id/:user_id", auth.OwnsParam("user_id", auth.UserID), handlers.GetUsersAccountsByUserID(h, rcache))
+	accounts.Get("/acc_id/:acc_id/:user_id", auth.OwnsParam("user_id", auth.UserID), handlers.GetAccount(h, rcache))
 
 	transactions := coreEndpoints.Group("/transactions")
-	transactions.Get("/", handlers.GetUsersTransactions(transactionHandler, rcache))
+	transactions.Get("/", handlers.GetUsersTransactions(h, rcache))
+	transactions.Get("/query", handlers.QueryTransactions(h, rcache))
+
+	analytics := coreEndpoints.Group("/analytics")
+	analytics.Get("/categories", handlers.GetSpendByCategory(h, rcache))
+	analytics.Get("/merchants", handlers.GetSpendByMerchant(h, rcache))
+	analytics.Get("/trends", handlers.GetMonthlySpendTrends(h, rcache))
+	analytics.Get("/recurring", handlers.GetRecurringCharges(h, rcache))
+
+	budgets := coreEndpoints.Group("/budgets")
+	budgets.Get("/", handlers.GetUsersBudgets(h, rcache))
+	budgets.Post("/", handlers.CreateBudget(h, rcache))
+	budgets.Put("/:id", handlers.UpdateBudget(h, rcache))
+	budgets.Delete("/:id", handlers.DeleteBudget(h, rcache))
+
+	utilization := coreEndpoints.Group("/utilization")
+	utilization.Get("/", handlers.GetCurrentUtilization(h, rcache))
+	utilization.Get("/history", handlers.GetUtilizationHistory(h, rcache))
 
 	paymentTasks := coreEndpoints.Group("/payment_tasks")
-	paymentTasks.Get("/", handlers.GetUsersPaymentTasks(paymentTaskHandler, rcache))
+	paymentTasks.Get("/", handlers.GetUsersPaymentTasks(h, rcache))
 
 	users := coreEndpoints.Group("/users")
-	users.Post("/", handlers.CreateUser(userHandler, rcache))
-	users.Get("/", handlers.GetUser(userHandler, rcache))
-	users.Put("/", handlers.UpdateUserPhone(userHandler, rcache))
+	users.Post("/", handlers.CreateUser(h, rcache))
+	users.Get("/", handlers.GetUser(h, rcache))
+	users.Put("/", handlers.UpdateUserPhone(h, rcache))
+	users.Delete("/", handlers.CloseAccount(accountDeleter, rcache))
+	users.Put("/notifications", handlers.UpdateNotificationPreferences(h, rcache))
+	users.Post("/export", dataExporter.RequestExport(rcache))
+	users.Get("/export/:id", dataExporter.GetExport())
 
 	clerk := users.Group("/clerk")
-	clerk.Post("/", handlers.CreateUserClerkWebhook(userHandler, rcache))
-	// clerk.Delete("/", handlers.DeleteUserClerkWebhook(userHandler))
-	// clerk.Patch("/", handlers.UpdateUserClerkWebhook(userHandler))
+	if cfg.ClerkWebhookSecret != "" {
+		clerk.Use(VerifyClerkWebhook(cfg.ClerkWebhookSecret))
+	} else {
+		l.Warn("CLERK_WEBHOOK_SECRET is not set, clerk webhooks are unverified and can't delete users")
+	}
+	clerk.Post("/", handlers.ClerkWebhook(h, accountDeleter, rcache, cfg.ClerkWebhookSecret != ""))
+	// clerk.Patch("/", handlers.UpdateUserClerkWebhook(h))
 
 	planning := api.Group("/planning")
-	planning.Get("/waterfall", handlers.GetWaterfall(accountHandler, planningURL, rcache))
-	planning.Post("/accept", handlers.AcceptPaymentPlan(paymentTaskHandler, planningURL, rcache))
+	planning.Get("/waterfall", handlers.GetWaterfall(h, planningURL, rcache))
+	planning.Post("/accept", handlers.AcceptPaymentPlan(h, planningURL, rcache))
 
 	// TODO: Add swagger annotations
 	plaidEndpoints := api.Group("/plaid")
 	plaidEndpoints.Post("/info", handlers.Info(plaidClient))
 	plaidEndpoints.Get("/link/:email/:purpose", handlers.Link)
-	plaidEndpoints.Post("/create_link", handlers.CreateLinkToken(plaidClient))
-	plaidEndpoints.Post("/exchange", handlers.ExchangePublicToken(plaidClient, rcache))
-	plaidEndpoints.Get("/linked", handlers.ArePlaidAccountsLinked(plaidClient, rcache))
-	plaidEndpoints.Get("/accounts", handlers.GetAccountInfo(plaidClient, rcache))
-
-	notificationEndpoints := api.Group("/notify")
-	notificationEndpoints.Get("/", handlers.NotifyUsersUpcomingPaymentActions(twilioClient, accountHandler, planningURL, rcache))
+	plaidEndpoints.Post("/create_link", handlers.CreateLinkToken(h))
+	plaidEndpoints.Post("/exchange", handlers.ExchangePublicToken(h, rcache))
+	plaidEndpoints.Get("/linked", handlers.ArePlaidAccountsLinked(h, rcache))
+	plaidEndpoints.Get("/accounts", handlers.GetAccountInfo(h, rcache))
+
+	// signed links, so they can be opened without the Clerk header
+	app.Get("/exports/:id/download", dataExporter.DownloadExport())
+
+	admin := app.Group("/admin", auth.RequireRole(models.RoleAdmin), handlers.AuditAdminActions(h))
+	admin.Get("/users", handlers.AdminFindUser(h)).Name("admin.users.find")
+	admin.Get("/users/:id", handlers.AdminGetUser(h)).Name("admin.users.get")
+	admin.Delete("/users/:id/cache", handlers.AdminClearCache(h, rcache)).Name("admin.users.clear_cache")
+	admin.Post("/users/:id/resync", handlers.AdminResync(h, rcache)).Name("admin.users.resync")
+	admin.Delete("/users/:id", handlers.AdminDeleteUser(accountDeleter)).Name("admin.users.delete")
+	admin.Get("/deletions/:id", handlers.AdminGetDeletion(accountDeleter)).Name("admin.deletions.get")
+	admin.Post("/deletions/:id/resume", handlers.AdminResumeDeletion(accountDeleter)).Name("admin.deletions.resume")
+	admin.Get("/items", handlers.AdminItemHealth(h)).Name("admin.items.health")
+	admin.Get("/audit", handlers.QueryAuditEvents(h)).Name("admin.audit.query")
+	admin.Get("/audit/export", handlers.ExportAuditEvents(h)).Name("admin.audit.export")
+
+	jobs := admin.Group("/jobs")
+	jobs.Post("/payment_notifications", handlers.NotifyUsersUpcomingPaymentActions(twilioClient, h, planningURL, rcache)).Name("admin.jobs.payment_notifications")
+	jobs.Post("/due_date_reminders", handlers.NotifyUsersUpcomingDueDates(dueDateReminder)).Name("admin.jobs.due_date_reminders")
+	jobs.Post("/purge_exports", dataExporter.AdminPurgeExports()).Name("admin.jobs.purge_exports")
+	jobs.Post("/balance_snapshots", handlers.AdminSnapshotBalances(utilizationTracker, rcache, cfg.SnapshotJobTimeout)).Name("admin.jobs.balance_snapshots")
+	jobs.Post("/cleanup_users", handlers.AdminCleanupUsers(accountDeleter)).Name("admin.jobs.cleanup_users")
+	jobs.Post("/resume_deletions", handlers.AdminResumeDeletions(accountDeleter)).Name("admin.jobs.resume_deletions")
 }
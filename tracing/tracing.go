@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/jalexanderII/zero-railway"
+
+// Setup installs the global tracer provider and the W3C trace context propagator. Spans are exported
+// over OTLP/HTTP when the exporter is otlp, the endpoint and headers being read by the exporter from the
+// standard OTEL_EXPORTER_OTLP_* environment variables. Otherwise spans are created, so that trace ids
+// still propagate, but not exported. The returned function flushes and stops the provider.
+func Setup(ctx context.Context, cfg *config.Config) (func(context.Context) error, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceNameKey.String(cfg.Tracing.ServiceName),
+		semconv.DeploymentEnvironmentKey.String(cfg.Environment),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+	}
+	switch cfg.Tracing.Exporter {
+	case "otlp":
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("creating otlp exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case "none":
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %q", cfg.Tracing.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer used for the app's own spans
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Detach returns a context carrying the span of ctx but not its deadline or cancellation, for work that
+// continues in the background once a request has been answered
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
+
+// Transport wraps base, http.DefaultTransport when nil, so outbound requests create client spans and
+// carry the traceparent header
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base)
+}
+
+// Middleware starts a server span for every request, continuing the trace of an incoming traceparent
+// header, and stores it in the request's user context for the handlers
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		carrier := propagation.MapCarrier{}
+		c.Request().Header.VisitAll(func(key, value []byte) {
+			carrier[string(key)] = string(value)
+		})
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), carrier)
+
+		ctx, span := Tracer().Start(ctx, c.Method()+" "+c.Path(), trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		span.SetAttributes(
+			semconv.HTTPMethodKey.String(c.Method()),
+			semconv.HTTPTargetKey.String(string(c.Request().RequestURI())),
+			semconv.HTTPSchemeKey.String(c.Protocol()),
+			semconv.NetHostNameKey.String(c.Hostname()),
+			semconv.HTTPClientIPKey.String(c.IP()),
+		)
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		// name the span by route once matched, so path parameters don't create a span name per value
+		if route := c.Route().Path; route != "" && route != "/" {
+			span.SetName(c.Method() + " " + route)
+			span.SetAttributes(semconv.HTTPRouteKey.String(route))
+		}
+		status := c.Response().StatusCode()
+		if fe, ok := err.(*fiber.Error); ok {
+			status = fe.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		if err != nil {
+			span.RecordError(err)
+		}
+		return err
+	}
+}
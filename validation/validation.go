@@ -0,0 +1,143 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jalexanderII/zero-railway/apierror"
+	"github.com/jalexanderII/zero-railway/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FieldError describes why a single field of a request was rejected
+type FieldError struct {
+	// Field is the path of the field as sent, e.g. account_info[0].amount
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	// report fields by their json names, as the client sent them
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	_ = v.RegisterValidation("objectid", func(fl validator.FieldLevel) bool {
+		return primitive.IsValidObjectID(fl.Field().String())
+	})
+	_ = v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+	_ = v.RegisterValidation("purpose", func(fl validator.FieldLevel) bool {
+		purpose, err := models.PurposeFromString(fl.Field().String())
+		return err == nil && purpose != models.PURPOSE_UNKNOWN
+	})
+	return v
+}
+
+// Struct checks v against its validate tags, returning a validation error listing every rejected field
+func Struct(v any) error {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+	invalid, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return err
+	}
+
+	fields := make([]FieldError, len(invalid))
+	for idx, fe := range invalid {
+		fields[idx] = FieldError{Field: fieldPath(fe), Rule: fe.Tag(), Message: message(fe)}
+	}
+	return Fields(fields)
+}
+
+// Fields returns a validation error listing fields, or nil when there are none. It reports the checks
+// a handler makes itself, in the same shape as Struct.
+func Fields(fields []FieldError) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return apierror.Validation("request is invalid", fields)
+}
+
+// ParseBody parses the JSON body of the request into out and validates it
+func ParseBody(c *fiber.Ctx, out any) error {
+	if err := c.BodyParser(out); err != nil {
+		return apierror.Validation("request body malformed", err.Error())
+	}
+	return Struct(out)
+}
+
+// fieldPath drops the name of the top level struct from the namespace of the field
+func fieldPath(fe validator.FieldError) string {
+	if _, path, ok := strings.Cut(fe.Namespace(), "."); ok {
+		return path
+	}
+	return fe.Field()
+}
+
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "required_without":
+		return fmt.Sprintf("is required when %s is not set", snakeCase(fe.Param()))
+	case "min":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("needs at least %s item(s)", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s characters", fe.Param())
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "gte":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "lte":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "len":
+		return fmt.Sprintf("must be %s characters long", fe.Param())
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "notblank":
+		return "must not be blank"
+	case "numeric":
+		return "must only contain digits"
+	case "email":
+		return "must be an email address"
+	case "objectid":
+		return "must be a valid id"
+	case "purpose":
+		return "must be one of credit, debit"
+	case "unique":
+		return "must not contain duplicates"
+	}
+	return fmt.Sprintf("failed the %s check", fe.Tag())
+}
+
+// snakeCase turns the name of a struct field a rule references into its json name, e.g. AccountId into
+// account_id
+func snakeCase(field string) string {
+	var b strings.Builder
+	for idx, r := range field {
+		if unicode.IsUpper(r) {
+			if idx > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}